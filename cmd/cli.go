@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/a1ishm/wallet/pkg/types"
+	"github.com/a1ishm/wallet/pkg/wallet"
+)
+
+const (
+	exitOk = iota
+	exitError
+	exitUsage
+	exitNotFound
+	exitConflict
+	exitInvalid
+	exitRejected
+)
+
+const usage = `usage: wallet [-data dir] [-output table|json] command [arguments]
+
+commands:
+  account register <phone>
+  deposit <account> <amount>
+  pay <account> <amount> <category>
+  reject <payment>
+  history <account>
+  favorites list <account>
+  sum [-goroutines n]
+  export <dir>
+  import <dir>
+  serve [-addr address]
+  shell
+
+Amounts are in minor units. Commands that change the wallet save it back
+to the data directory. The HTTP API of serve has no authentication, serve
+it to trusted clients only.
+`
+
+var errUsage = errors.New("invalid arguments")
+
+func exitCode(err error) int {
+	if errors.Is(err, errUsage) {
+		return exitUsage
+	}
+	item, ok := errorCodes[wallet.KindOf(err)]
+	if !ok {
+		return exitError
+	}
+
+	return item.exit
+}
+
+// cli runs a single command against the wallet stored in dir.
+type cli struct {
+	dir    string
+	json   bool
+	stdout io.Writer
+	svc    *wallet.Service
+}
+
+// run executes the command line and returns the process exit code.
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("wallet", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+	}
+	dir := flags.String("data", "data", "directory with dump files")
+	output := flags.String("output", "table", "output format: table or json")
+	err := flags.Parse(args)
+	if err != nil {
+		return exitUsage
+	}
+	if flags.NArg() == 0 || (*output != "table" && *output != "json") {
+		flags.Usage()
+		return exitUsage
+	}
+
+	c := &cli{dir: *dir, json: *output == "json", stdout: stdout}
+	err = c.execute(flags.Arg(0), flags.Args()[1:])
+	if err != nil {
+		fmt.Fprintln(stderr, "wallet:", err)
+		if errors.Is(err, errUsage) {
+			flags.Usage()
+		}
+		return exitCode(err)
+	}
+
+	return exitOk
+}
+
+func (c *cli) execute(command string, args []string) error {
+	switch command {
+	case "account":
+		if len(args) != 2 || args[0] != "register" {
+			return errUsage
+		}
+		return c.change(func() error {
+			account, err := c.svc.RegisterAccount(types.Phone(args[1]))
+			if err != nil {
+				return err
+			}
+			return c.printAccounts(account)
+		})
+
+	case "deposit":
+		if len(args) != 2 {
+			return errUsage
+		}
+		accountID, amount, err := parseAccountAmount(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		return c.change(func() error {
+			err := c.svc.Deposit(accountID, amount)
+			if err != nil {
+				return err
+			}
+			account, err := c.svc.FindAccountByID(accountID)
+			if err != nil {
+				return err
+			}
+			return c.printAccounts(account)
+		})
+
+	case "pay":
+		if len(args) != 3 {
+			return errUsage
+		}
+		accountID, amount, err := parseAccountAmount(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		return c.change(func() error {
+			payment, err := c.svc.Pay(accountID, amount, types.PaymentCategory(args[2]))
+			if err != nil {
+				return err
+			}
+			return c.printPayment(*payment)
+		})
+
+	case "reject":
+		if len(args) != 1 {
+			return errUsage
+		}
+		return c.change(func() error {
+			err := c.svc.Reject(args[0])
+			if err != nil {
+				return err
+			}
+			payment, err := c.svc.FindPaymentByID(args[0])
+			if err != nil {
+				return err
+			}
+			return c.printPayment(*payment)
+		})
+
+	case "history":
+		if len(args) != 1 {
+			return errUsage
+		}
+		accountID, err := parseAccountID(args[0])
+		if err != nil {
+			return err
+		}
+		return c.view(func() error {
+			payments, err := c.svc.ExportAccountHistory(accountID)
+			if err != nil {
+				return err
+			}
+			return c.printPayments(payments...)
+		})
+
+	case "favorites":
+		if len(args) != 2 || args[0] != "list" {
+			return errUsage
+		}
+		accountID, err := parseAccountID(args[1])
+		if err != nil {
+			return err
+		}
+		return c.view(func() error {
+			favorites, err := c.svc.AccountFavorites(accountID)
+			if err != nil {
+				return err
+			}
+			return c.printFavorites(favorites)
+		})
+
+	case "sum":
+		flags := flag.NewFlagSet("sum", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		goroutines := flags.Int("goroutines", 1, "number of goroutines")
+		if flags.Parse(args) != nil || flags.NArg() != 0 {
+			return errUsage
+		}
+		return c.view(func() error {
+			sum := c.svc.SumPayments(*goroutines)
+			if c.json {
+				return c.printJSON(map[string]types.Money{"sum": sum})
+			}
+			_, err := fmt.Fprintln(c.stdout, sum)
+			return err
+		})
+
+	case "export":
+		if len(args) != 1 {
+			return errUsage
+		}
+		return c.view(func() error {
+			return c.svc.Export(args[0])
+		})
+
+	case "import":
+		if len(args) != 1 {
+			return errUsage
+		}
+		return c.change(func() error {
+			return c.svc.Import(args[0])
+		})
+
+	case "serve":
+		flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		addr := flags.String("addr", ":9999", "address to listen on")
+		if flags.Parse(args) != nil || flags.NArg() != 0 {
+			return errUsage
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return serve(ctx, *addr, c.dir)
+
+	case "shell":
+		if len(args) != 0 {
+			return errUsage
+		}
+		return runShell(c.dir, os.Stdin, c.stdout)
+
+	default:
+		return errUsage
+	}
+}
+
+// view loads the wallet and calls fn.
+func (c *cli) view(fn func() error) error {
+	c.svc = &wallet.Service{}
+	err := c.svc.Import(c.dir)
+	if err != nil {
+		return err
+	}
+
+	return fn()
+}
+
+// change is view that saves the wallet if fn succeeds.
+func (c *cli) change(fn func() error) error {
+	err := c.view(fn)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(c.dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	return c.svc.Export(c.dir)
+}
+
+func parseAccountID(value string) (int64, error) {
+	accountID, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid account %q", errUsage, value)
+	}
+
+	return accountID, nil
+}
+
+func parseAccountAmount(account string, amount string) (int64, types.Money, error) {
+	accountID, err := parseAccountID(account)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	value, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: invalid amount %q", errUsage, amount)
+	}
+
+	return accountID, types.Money(value), nil
+}
+
+func (c *cli) printJSON(value interface{}) error {
+	encoder := json.NewEncoder(c.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func (c *cli) printTable(header string, rows [][]interface{}) error {
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, header)
+	for _, row := range rows {
+		for i, cell := range row {
+			if i != 0 {
+				fmt.Fprint(w, "\t")
+			}
+			fmt.Fprint(w, cell)
+		}
+		fmt.Fprintln(w)
+	}
+
+	return w.Flush()
+}
+
+func (c *cli) printAccounts(accounts ...*types.Account) error {
+	if c.json {
+		if len(accounts) == 1 {
+			return c.printJSON(accounts[0])
+		}
+		return c.printJSON(accounts)
+	}
+
+	var rows [][]interface{}
+	for _, account := range accounts {
+		rows = append(rows, []interface{}{account.ID, account.Phone, account.Balance})
+	}
+	return c.printTable("ID\tPHONE\tBALANCE", rows)
+}
+
+// printPayment prints the payment of a command that returns one, as an
+// object rather than a list in JSON.
+func (c *cli) printPayment(payment types.Payment) error {
+	if c.json {
+		return c.printJSON(payment)
+	}
+
+	return c.printPayments(payment)
+}
+
+func (c *cli) printPayments(payments ...types.Payment) error {
+	if c.json {
+		if payments == nil {
+			payments = []types.Payment{}
+		}
+		return c.printJSON(payments)
+	}
+
+	var rows [][]interface{}
+	for _, payment := range payments {
+		rows = append(rows, []interface{}{payment.ID, payment.AccountID, payment.Amount, payment.Fee, payment.Category, payment.Status})
+	}
+	return c.printTable("ID\tACCOUNT\tAMOUNT\tFEE\tCATEGORY\tSTATUS", rows)
+}
+
+func (c *cli) printFavorites(favorites []types.Favorite) error {
+	if c.json {
+		if favorites == nil {
+			favorites = []types.Favorite{}
+		}
+		return c.printJSON(favorites)
+	}
+
+	var rows [][]interface{}
+	for _, favorite := range favorites {
+		rows = append(rows, []interface{}{favorite.ID, favorite.Name, favorite.Amount, favorite.Category})
+	}
+	return c.printTable("ID\tNAME\tAMOUNT\tCATEGORY", rows)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+func runCommand(dir string, args ...string) (int, string, string) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	code := run(append([]string{"-data", dir}, args...), stdout, stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+
+	code, stdout, _ := runCommand(dir, "account", "register", "+992000000001")
+	if code != exitOk || !strings.Contains(stdout, "+992000000001") {
+		t.Errorf("invalid result, code: %v, output: %q", code, stdout)
+	}
+
+	code, _, _ = runCommand(dir, "deposit", "1", "100")
+	if code != exitOk {
+		t.Errorf("invalid code, expected: %v, actual: %v", exitOk, code)
+	}
+
+	code, stdout, _ = runCommand(dir, "-output", "json", "pay", "1", "40", "food")
+	var payment types.Payment
+	err := json.Unmarshal([]byte(stdout), &payment)
+	if err != nil || code != exitOk || payment.Amount != 40 {
+		t.Errorf("invalid result, code: %v, output: %q", code, stdout)
+		return
+	}
+
+	code, _, _ = runCommand(dir, "pay", "1", "40", "auto")
+	if code != exitOk {
+		t.Errorf("invalid code, expected: %v, actual: %v", exitOk, code)
+	}
+
+	code, stdout, _ = runCommand(dir, "reject", payment.ID)
+	if code != exitOk || !strings.Contains(stdout, "FAIL") {
+		t.Errorf("invalid result, code: %v, output: %q", code, stdout)
+	}
+
+	code, stdout, _ = runCommand(dir, "history", "1")
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if code != exitOk || len(lines) != 3 || !strings.HasPrefix(lines[0], "ID ") {
+		t.Errorf("invalid result, code: %v, output: %q", code, stdout)
+	}
+
+	code, stdout, _ = runCommand(dir, "sum")
+	if code != exitOk || stdout != "80\n" {
+		t.Errorf("invalid result, code: %v, output: %q", code, stdout)
+	}
+
+	code, stdout, _ = runCommand(dir, "-output", "json", "favorites", "list", "1")
+	if code != exitOk || strings.TrimSpace(stdout) != "[]" {
+		t.Errorf("invalid result, code: %v, output: %q", code, stdout)
+	}
+
+	other := t.TempDir()
+	code, _, _ = runCommand(dir, "export", other)
+	if code != exitOk {
+		t.Errorf("invalid code, expected: %v, actual: %v", exitOk, code)
+	}
+	code, _, _ = runCommand(t.TempDir(), "import", other)
+	if code != exitOk {
+		t.Errorf("invalid code, expected: %v, actual: %v", exitOk, code)
+	}
+}
+
+func TestRun_exitCodes(t *testing.T) {
+	dir := t.TempDir()
+	code, _, _ := runCommand(dir, "account", "register", "+992000000001")
+	if code != exitOk {
+		t.Errorf("invalid code, expected: %v, actual: %v", exitOk, code)
+	}
+
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{[]string{}, exitUsage},
+		{[]string{"unknown"}, exitUsage},
+		{[]string{"deposit", "x", "10"}, exitUsage},
+		{[]string{"-output", "xml", "sum"}, exitUsage},
+		{[]string{"deposit", "2", "10"}, exitNotFound},
+		{[]string{"reject", "unknown"}, exitNotFound},
+		{[]string{"account", "register", "+992000000001"}, exitConflict},
+		{[]string{"deposit", "1", "-10"}, exitInvalid},
+		{[]string{"pay", "1", "10", "food"}, exitRejected},
+	}
+
+	for _, test := range tests {
+		code, _, stderr := runCommand(dir, test.args...)
+		if code != test.code {
+			t.Errorf("%v: invalid code, expected: %v, actual: %v, stderr: %q", test.args, test.code, code, stderr)
+		}
+	}
+}
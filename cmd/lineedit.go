@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// lineEditor reads lines from a terminal in non-canonical mode, so it can
+// recall history with the up and down arrows and complete the last word
+// with tab. It only edits at the end of the line.
+type lineEditor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	prompt   string
+	history  []string
+	complete func(line string) []string
+}
+
+func (e *lineEditor) readLine() (string, error) {
+	_, err := io.WriteString(e.out, e.prompt)
+	if err != nil {
+		return "", err
+	}
+
+	var line []rune
+	recalled := len(e.history)
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch {
+		case r == '\r' || r == '\n':
+			_, err = io.WriteString(e.out, "\r\n")
+			return string(line), err
+
+		case r == 4:
+			if len(line) == 0 {
+				return "", io.EOF
+			}
+
+		case r == 127 || r == 8:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				io.WriteString(e.out, "\b \b")
+			}
+
+		case r == '\t':
+			line = e.completeLine(line)
+
+		case r == 27:
+			next, _, err := e.in.ReadRune()
+			if err != nil {
+				return "", err
+			}
+			if next != '[' {
+				continue
+			}
+			arrow, _, err := e.in.ReadRune()
+			if err != nil {
+				return "", err
+			}
+
+			switch {
+			case arrow == 'A' && recalled > 0:
+				recalled--
+			case arrow == 'B' && recalled < len(e.history):
+				recalled++
+			default:
+				continue
+			}
+			line = nil
+			if recalled < len(e.history) {
+				line = []rune(e.history[recalled])
+			}
+			e.redraw(line)
+
+		case r >= ' ':
+			line = append(line, r)
+			io.WriteString(e.out, string(r))
+		}
+	}
+}
+
+// completeLine extends the last word of line to the longest prefix shared
+// by all completions and lists them if there is still more than one.
+func (e *lineEditor) completeLine(line []rune) []rune {
+	if e.complete == nil {
+		return line
+	}
+
+	candidates := e.complete(string(line))
+	if len(candidates) == 0 {
+		return line
+	}
+
+	text := string(line)
+	word := text[strings.LastIndex(text, " ")+1:]
+	prefix := commonPrefix(candidates)
+	if len(candidates) == 1 {
+		prefix += " "
+	}
+	if len(prefix) > len(word) {
+		addition := prefix[len(word):]
+		io.WriteString(e.out, addition)
+		return append(line, []rune(addition)...)
+	}
+
+	sort.Strings(candidates)
+	io.WriteString(e.out, "\r\n"+strings.Join(candidates, "  ")+"\r\n")
+	e.redraw(line)
+	return line
+}
+
+func (e *lineEditor) redraw(line []rune) {
+	io.WriteString(e.out, "\r\x1b[K"+e.prompt+string(line))
+}
+
+func commonPrefix(values []string) string {
+	prefix := values[0]
+	for _, value := range values[1:] {
+		for !strings.HasPrefix(value, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+
+	return prefix
+}
+
+// isTerminal reports whether file is a character device, such as a terminal
+// rather than a pipe or a file.
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// rawMode switches the terminal to non-canonical mode without echo using
+// stty and returns the function restoring the previous settings.
+func rawMode(file *os.File) (func(), error) {
+	stty := func(args ...string) (string, error) {
+		cmd := exec.Command("stty", args...)
+		cmd.Stdin = file
+		out, err := cmd.Output()
+		return strings.TrimSpace(string(out)), err
+	}
+
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	_, err = stty("-icanon", "-echo", "min", "1", "time", "0")
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		stty(saved)
+	}, nil
+}
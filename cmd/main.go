@@ -1,236 +1,68 @@
 package main
 
 import (
-	// "io"
+	"context"
+	"errors"
 	"log"
+	"net/http"
+	"os"
+	"time"
 
-	// "math"
-	// "sync"
-	// "os"
-	// "path/filepath"
-	// "strconv"
-	// "strings"
-	// "github.com/a1ishm/wallet/pkg/types"
+	"github.com/a1ishm/wallet/pkg/wallet"
 )
 
-func main() {
-	defer log.Print("oh sorry")
-	a := true
-	if a {
-		log.Print("bye")
-		return
-	}
-
-
-	// arr := []types.Payment{}
-	// id := int64(4)
-
-	// ps := []*types.Payment{
-	// 	{ID: "A", AccountID: 1, Amount: 10_000_00, Category: "auto", Status: types.PaymentStatusOk},
-	// 	{ID: "B", AccountID: 2, Amount: 20_000_00, Category: "auto", Status: types.PaymentStatusOk},
-	// 	{ID: "C", AccountID: 3, Amount: 30_000_00, Category: "food", Status: types.PaymentStatusOk},
-	// 	{ID: "D", AccountID: 1, Amount: 40_000_00, Category: "food", Status: types.PaymentStatusOk},
-	// 	{ID: "E", AccountID: 2, Amount: 50_000_00, Category: "auto", Status: types.PaymentStatusOk},
-	// 	{ID: "F", AccountID: 6, Amount: 60_000_00, Category: "auto", Status: types.PaymentStatusOk},
-	// 	{ID: "G", AccountID: 1, Amount: 70_000_00, Category: "auto", Status: types.PaymentStatusOk},
-	// }
-
-	// for _, p := range ps {
-	// 	if p.AccountID == id {
-	// 		arr = append(arr, *p)
-	// 	}
-	// }
-
-	// log.Print(arr)
-
-	// goroutines := 3
-
-	// ps := []*types.Payment{
-	// 	{ID: "A", AccountID: 1, Amount: 10_000_00, Category: "auto", Status: types.PaymentStatusOk},
-	// 	{ID: "B", AccountID: 2, Amount: 20_000_00, Category: "auto", Status: types.PaymentStatusOk},
-	// 	{ID: "C", AccountID: 3, Amount: 30_000_00, Category: "food", Status: types.PaymentStatusOk},
-	// 	{ID: "D", AccountID: 4, Amount: 40_000_00, Category: "food", Status: types.PaymentStatusOk},
-	// 	{ID: "E", AccountID: 5, Amount: 50_000_00, Category: "auto", Status: types.PaymentStatusOk},
-	// 	{ID: "F", AccountID: 6, Amount: 60_000_00, Category: "auto", Status: types.PaymentStatusOk},
-	// }
-
-	// wg := sync.WaitGroup{}
-
-	// mu := sync.Mutex{}
-	// sum := int64(0)
-
-	// if goroutines > len(ps) {
-	// 	goroutines = len(ps)
-	// }
-
-	// div := []int{}
-
-	// add := len(ps) / goroutines
-
-	// floatAdd := float64(len(ps)) / float64(goroutines)
-
-	// for i := 0; i < goroutines; i++ {
-	// 	if i == goroutines-1 {
-	// 		if int(math.Round(floatAdd)) == add {
-	// 			div = append(div, add+(len(ps)%goroutines))
-	// 		} else {
-	// 			div = append(div, add)
-	// 		}
-	// 		break
-	// 	}
-
-	// 	if int(math.Round(floatAdd)) == add {
-	// 		div = append(div, add)
-	// 	} else {
-	// 		div = append(div, add+1)
-	// 	}
-	// }
-	// log.Print(div)
-	// // lastAdd := len(s.payments) % goroutines
-
-	// start := 0
-	// end := 0
-
-	// for i := 0; i < goroutines; i++ {
-	// 	wg.Add(1)
-	// 	go func(iter int) {
-	// 		defer wg.Done()
-
-	// 		end += div[iter]
-	// 		payments := append([]*types.Payment{}, ps[start:end]...)
-	// 		log.Printf("start: %v; end: %v", start, end)
-	// 		start += div[iter]
-
-	// 		val := int64(0)
-	// 		for _, payment := range payments {
-	// 			val += int64(payment.Amount)
-	// 		}
-	// 		mu.Lock()
-	// 		defer mu.Unlock()
-	// 		sum += val
-	// 	}(i)
-	// 	wg.Wait()
-	// }
-
-	// log.Print(types.Money(sum))
-
-	// --------------------------------------------------------------------------------------
+const shutdownTimeout = 10 * time.Second
 
-	// pss := []int{4, 7, 9, 13, 27, 33, 49}
-	// grts := 4
-
-	// for _, ps := range pss {
-	// 	divSum := 0
-	
-	// 	arr := []int{}
-	
-	// 	floatAdd := float64(ps) / float64(grts)
-	
-	// 	for i := 0; i < grts; i++ {
-	// 		if i == grts-1 {
-	// 			arr = append(arr, ps-divSum)
-	// 			break
-	// 		}
-	
-	// 		arr = append(arr, int(math.Ceil(floatAdd)))
-	// 		divSum += arr[i]
-	// 	}
-
-	// 	want := 0
-	// 	for _, a := range arr {
-	// 		want += a
-	// 	}
-	
-	// 	log.Printf("%v + %v + %v + %v = %v; want: %v", arr[0], arr[1], arr[2], arr[3], ps, want)	
-	// }
-
-	// 	payments := []*types.Payment{
-	// 		{ID: "A", AccountID: 1, Amount: 10_000_00, Category: "auto", Status: types.PaymentStatusOk},
-	// 		{ID: "B", AccountID: 2, Amount: 20_000_00, Category: "auto", Status: types.PaymentStatusOk},
-	// 		{ID: "C", AccountID: 3, Amount: 30_000_00, Category: "food", Status: types.PaymentStatusOk},
-	// 		{ID: "D", AccountID: 4, Amount: 40_000_00, Category: "food", Status: types.PaymentStatusOk},
-	// 		{ID: "E", AccountID: 5, Amount: 50_000_00, Category: "auto", Status: types.PaymentStatusOk},
-	// 		{ID: "F", AccountID: 6, Amount: 60_000_00, Category: "auto", Status: types.PaymentStatusOk},
-	// 	}
-
-	// 	goroutines := 2
-
-	// 	wg := sync.WaitGroup{}
-
-	// 	mu := sync.Mutex{}
-	// 	sum := ""
-
-	// 	add := len(payments) / goroutines
-	// 	lastAdd := len(payments) % goroutines
-
-	// 	start := 0
-	// 	end := add
-
-	// 	for i := 0; i < goroutines; i++ {
-	// 		wg.Add(1)
-
-	// 		go func(iter int) {
-	// 			defer wg.Done()
-
-	// 			payments := append([]*types.Payment{}, payments[start:end]...)
-
-	// 			if iter == goroutines-1 {
-	// 				start += lastAdd
-	// 				end += lastAdd
-	// 			} else {
-	// 				start += add
-	// 				end += add
-	// 			}
-
-	// 			val := ""
-	// 			for _, payment := range payments {
-	// 				val += payment.ID
-	// 			}
-	// 			mu.Lock()
-	// 			defer mu.Unlock()
-	// 			sum += val
-	// 		}(i)
-
-	// 		wg.Wait()
-	// 	}
-
-	// 	log.Print(sum)
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
 
-	// ----------------------------------------------------------------------------------------------------------------------------
+// serve loads the service from dir, serves it on addr until ctx is done,
+// waits for requests in flight and webhook deliveries and then exports the
+// service back to dir. The service is exported even if the wait times out,
+// the shutdown error is returned after that.
+func serve(ctx context.Context, addr string, dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
 
-	// a := []*types.Payment{
-	// 	{ID: "A", AccountID: 1, Amount: 10_000_00, Category: "auto", Status: types.PaymentStatusInProgress},
-	// 	{ID: "B", AccountID: 2, Amount: 20_000_00, Category: "auto", Status: types.PaymentStatusInProgress},
-	// 	{ID: "C", AccountID: 3, Amount: 30_000_00, Category: "auto", Status: types.PaymentStatusInProgress},
-	// 	{ID: "D", AccountID: 4, Amount: 40_000_00, Category: "auto", Status: types.PaymentStatusInProgress},
-	// }
+	svc := &wallet.Service{}
+	err = svc.Import(dir)
+	if err != nil {
+		return err
+	}
 
-	// b := append([]*types.Payment{}, a[1:4]...)
+	webhooks := svc.StartWebhooks(wallet.WebhookOptions{})
+	handler := newServer(svc, dir)
+	srv := &http.Server{Addr: addr, Handler: handler}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+	log.Printf("listening on %v", addr)
+
+	select {
+	case err = <-errs:
+		webhooks.Close(context.Background())
+		return err
+	case <-ctx.Done():
+	}
 
-	// log.Print(a)
-	// log.Print(b)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
 
-	// accounts := []*types.Account{
-	// 	{ID: 1, Phone: "+992000000001", Balance: 10_000_00},
-	// 	{ID: 2, Phone: "+992000000002", Balance: 20_000_00},
-	// 	{ID: 3, Phone: "+992000000003", Balance: 30_000_00},
-	// 	{ID: 4, Phone: "+992000000004", Balance: 40_000_00},
-	// 	{ID: 5, Phone: "+992000000005", Balance: 50_000_00},
-	// }
+	shutdownErr := srv.Shutdown(shutdownCtx)
+	err = <-errs
+	if err != nil && !errors.Is(err, http.ErrServerClosed) && shutdownErr == nil {
+		shutdownErr = err
+	}
+	webhooks.Close(shutdownCtx)
 
-	// path := "files"
-	// abs, err := filepath.Abs(path)
-	// if err != nil {
-	// 	log.Print(err)
-	// }
-	// log.Print(abs)
+	err = handler.export()
+	if err != nil {
+		return err
+	}
 
-	// _, err := os.Open(path)
-	// if err != nil {
-	// 	if os.IsNotExist(err) {
-	// 		log.Print("file doesn't exist")
-	// 	}
-	// } else {
-	// 	log.Print("error, not expected")
-	// }
+	return shutdownErr
 }
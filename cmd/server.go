@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/a1ishm/wallet/pkg/types"
+	"github.com/a1ishm/wallet/pkg/wallet"
+)
+
+// errorCodes maps kinds of wallet errors to HTTP status codes and CLI exit
+// codes, internal errors are the rest.
+var errorCodes = map[wallet.ErrorKind]struct {
+	status int
+	exit   int
+}{
+	wallet.KindNotFound:        {http.StatusNotFound, exitNotFound},
+	wallet.KindConflict:        {http.StatusConflict, exitConflict},
+	wallet.KindInvalid:         {http.StatusBadRequest, exitInvalid},
+	wallet.KindRejected:        {http.StatusUnprocessableEntity, exitRejected},
+	wallet.KindCanceled:        {http.StatusServiceUnavailable, exitError},
+	wallet.KindUnauthenticated: {http.StatusUnauthorized, exitRejected},
+	wallet.KindForbidden:       {http.StatusForbidden, exitRejected},
+}
+
+func statusFor(err error) int {
+	item, ok := errorCodes[wallet.KindOf(err)]
+	if !ok {
+		return http.StatusInternalServerError
+	}
+
+	return item.status
+}
+
+// server exposes a wallet.Service over JSON REST. The service isn't safe
+// for concurrent use, so every request holds mu.
+//
+// The API is outside the user and role model of wallet.Authorized: it has
+// no authentication, and any client may pay from or reject payments of any
+// account, like the CLI. It is meant for trusted clients on a private
+// network only.
+type server struct {
+	mu  sync.Mutex
+	svc *wallet.Service
+	dir string
+	mux *http.ServeMux
+}
+
+func newServer(svc *wallet.Service, dir string) *server {
+	srv := &server{svc: svc, dir: dir}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/accounts", srv.handleAccounts)
+	mux.HandleFunc("/accounts/", srv.handleAccount)
+	mux.HandleFunc("/payments/", srv.handlePayment)
+	mux.HandleFunc("/favorites/", srv.handleFavorite)
+	mux.HandleFunc("/export", srv.handleExport)
+	srv.mux = mux
+	return srv
+}
+
+func (srv *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	srv.mux.ServeHTTP(w, r)
+}
+
+// export writes the service to the data directory once no request holds it.
+func (srv *server) export() error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	return srv.svc.Export(srv.dir)
+}
+
+type accountRequest struct {
+	Phone types.Phone `json:"phone"`
+}
+
+type amountRequest struct {
+	Amount   types.Money           `json:"amount"`
+	Category types.PaymentCategory `json:"category"`
+}
+
+type favoriteRequest struct {
+	Name string `json:"name"`
+}
+
+// POST /accounts
+func (srv *server) handleAccounts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var request accountRequest
+	if !readJSON(w, r, &request) {
+		return
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	account, err := srv.svc.RegisterAccount(request.Phone)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, account)
+}
+
+// GET /accounts/{id}
+// POST /accounts/{id}/deposit
+// GET, POST /accounts/{id}/payments
+// GET /accounts/{id}/favorites
+func (srv *server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/accounts/"), "/")
+	accountID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || len(parts) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		account, err := srv.svc.FindAccountByID(accountID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, account)
+
+	case action == "deposit" && r.Method == http.MethodPost:
+		var request amountRequest
+		if !readJSON(w, r, &request) {
+			return
+		}
+		err := srv.svc.Deposit(accountID, request.Amount)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		account, err := srv.svc.FindAccountByID(accountID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, account)
+
+	case action == "payments" && r.Method == http.MethodGet:
+		payments, err := srv.svc.ExportAccountHistory(accountID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if payments == nil {
+			payments = []types.Payment{}
+		}
+		writeJSON(w, http.StatusOK, payments)
+
+	case action == "payments" && r.Method == http.MethodPost:
+		var request amountRequest
+		if !readJSON(w, r, &request) {
+			return
+		}
+		payment, err := srv.svc.Pay(accountID, request.Amount, request.Category)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, payment)
+
+	case action == "favorites" && r.Method == http.MethodGet:
+		favorites, err := srv.svc.AccountFavorites(accountID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if favorites == nil {
+			favorites = []types.Favorite{}
+		}
+		writeJSON(w, http.StatusOK, favorites)
+
+	case action == "" || action == "deposit" || action == "payments" || action == "favorites":
+		writeMethodNotAllowed(w, allowedAccountMethods(action))
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func allowedAccountMethods(action string) string {
+	switch action {
+	case "", "favorites":
+		return http.MethodGet
+	case "deposit":
+		return http.MethodPost
+	default:
+		return http.MethodGet + ", " + http.MethodPost
+	}
+}
+
+// GET /payments/{id}
+// POST /payments/{id}/reject
+// POST /payments/{id}/repeat
+// POST /payments/{id}/favorite
+func (srv *server) handlePayment(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/payments/"), "/")
+	if parts[0] == "" || len(parts) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+	paymentID := parts[0]
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+
+	if action == "" && r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	if action != "" && r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	switch action {
+	case "":
+		payment, err := srv.svc.FindPaymentByID(paymentID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, payment)
+
+	case "reject":
+		err := srv.svc.Reject(paymentID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case "repeat":
+		payment, err := srv.svc.Repeat(paymentID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, payment)
+
+	case "favorite":
+		var request favoriteRequest
+		if !readJSON(w, r, &request) {
+			return
+		}
+		favorite, err := srv.svc.FavoritePayment(paymentID, request.Name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, favorite)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// POST /favorites/{id}/pay
+func (srv *server) handleFavorite(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/favorites/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] != "pay" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	payment, err := srv.svc.PayFromFavorite(parts[0])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, payment)
+}
+
+// POST /export writes the service to the data directory.
+func (srv *server) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	err := srv.export()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, value interface{}) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(value)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return false
+	}
+
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := statusFor(err)
+	if status == http.StatusInternalServerError {
+		log.Print(err)
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error(), "code": string(wallet.CodeOf(err))})
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+}
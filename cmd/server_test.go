@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+	"github.com/a1ishm/wallet/pkg/wallet"
+)
+
+func request(t *testing.T, server *httptest.Server, method string, path string, body interface{}, result interface{}) int {
+	t.Helper()
+
+	var data []byte
+	if body != nil {
+		var err error
+		data, err = json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req, err := http.NewRequest(method, server.URL+path, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if result != nil {
+		err = json.NewDecoder(resp.Body).Decode(result)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return resp.StatusCode
+}
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	server := httptest.NewServer(newServer(&wallet.Service{}, dir))
+	defer server.Close()
+
+	var account types.Account
+	status := request(t, server, http.MethodPost, "/accounts", map[string]string{"phone": "+992000000001"}, &account)
+	if status != http.StatusCreated || account.ID != 1 {
+		t.Errorf("invalid result, status: %v, account: %v", status, account)
+	}
+
+	status = request(t, server, http.MethodPost, "/accounts", map[string]string{"phone": "+992000000001"}, nil)
+	if status != http.StatusConflict {
+		t.Errorf("invalid status, expected: %v, actual: %v", http.StatusConflict, status)
+	}
+
+	accountPath := "/accounts/" + strconv.FormatInt(account.ID, 10)
+	status = request(t, server, http.MethodPost, accountPath+"/deposit", map[string]int{"amount": 100}, &account)
+	if status != http.StatusOK || account.Balance != 100 {
+		t.Errorf("invalid result, status: %v, account: %v", status, account)
+	}
+
+	var payment types.Payment
+	status = request(t, server, http.MethodPost, accountPath+"/payments", map[string]interface{}{"amount": 40, "category": "food"}, &payment)
+	if status != http.StatusCreated || payment.Amount != 40 {
+		t.Errorf("invalid result, status: %v, payment: %v", status, payment)
+	}
+
+	status = request(t, server, http.MethodPost, accountPath+"/payments", map[string]interface{}{"amount": 1_000, "category": "food"}, nil)
+	if status != http.StatusUnprocessableEntity {
+		t.Errorf("invalid status, expected: %v, actual: %v", http.StatusUnprocessableEntity, status)
+	}
+
+	var favorite types.Favorite
+	status = request(t, server, http.MethodPost, "/payments/"+payment.ID+"/favorite", map[string]string{"name": "lunch"}, &favorite)
+	if status != http.StatusCreated || favorite.Name != "lunch" {
+		t.Errorf("invalid result, status: %v, favorite: %v", status, favorite)
+	}
+
+	var favorites []types.Favorite
+	status = request(t, server, http.MethodGet, accountPath+"/favorites", nil, &favorites)
+	if status != http.StatusOK || len(favorites) != 1 {
+		t.Errorf("invalid result, status: %v, favorites: %v", status, favorites)
+	}
+
+	status = request(t, server, http.MethodPost, "/favorites/"+favorite.ID+"/pay", nil, &payment)
+	if status != http.StatusCreated || payment.Amount != 40 {
+		t.Errorf("invalid result, status: %v, payment: %v", status, payment)
+	}
+
+	status = request(t, server, http.MethodPost, "/payments/"+payment.ID+"/reject", nil, nil)
+	if status != http.StatusNoContent {
+		t.Errorf("invalid status, expected: %v, actual: %v", http.StatusNoContent, status)
+	}
+
+	status = request(t, server, http.MethodPost, "/payments/"+payment.ID+"/repeat", nil, &payment)
+	if status != http.StatusCreated {
+		t.Errorf("invalid status, expected: %v, actual: %v", http.StatusCreated, status)
+	}
+
+	var payments []types.Payment
+	status = request(t, server, http.MethodGet, accountPath+"/payments", nil, &payments)
+	if status != http.StatusOK || len(payments) != 3 {
+		t.Errorf("invalid result, status: %v, payments: %v", status, payments)
+	}
+
+	request(t, server, http.MethodGet, accountPath, nil, &account)
+	if account.Balance != 20 {
+		t.Errorf("invalid balance, expected: %v, actual: %v", 20, account.Balance)
+	}
+
+	status = request(t, server, http.MethodPost, "/export", nil, nil)
+	if status != http.StatusNoContent {
+		t.Errorf("invalid status, expected: %v, actual: %v", http.StatusNoContent, status)
+	}
+	_, err := os.Stat(filepath.Join(dir, "accounts.dump"))
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServer_errors(t *testing.T) {
+	server := httptest.NewServer(newServer(&wallet.Service{}, t.TempDir()))
+	defer server.Close()
+
+	tests := []struct {
+		method string
+		path   string
+		body   interface{}
+		status int
+	}{
+		{http.MethodGet, "/accounts/1", nil, http.StatusNotFound},
+		{http.MethodPost, "/accounts/1/deposit", map[string]int{"amount": 10}, http.StatusNotFound},
+		{http.MethodPost, "/accounts/1/deposit", map[string]int{"amount": -10}, http.StatusBadRequest},
+		{http.MethodPost, "/accounts/1/deposit", map[string]string{"unknown": "x"}, http.StatusBadRequest},
+		{http.MethodGet, "/accounts/1/deposit", nil, http.StatusMethodNotAllowed},
+		{http.MethodGet, "/accounts/x", nil, http.StatusNotFound},
+		{http.MethodGet, "/accounts/1/unknown", nil, http.StatusNotFound},
+		{http.MethodPost, "/payments/unknown/reject", nil, http.StatusNotFound},
+		{http.MethodGet, "/payments/unknown", nil, http.StatusNotFound},
+		{http.MethodDelete, "/payments/unknown", nil, http.StatusMethodNotAllowed},
+		{http.MethodPost, "/favorites/unknown/pay", nil, http.StatusNotFound},
+		{http.MethodGet, "/accounts", nil, http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+		status := request(t, server, test.method, test.path, test.body, nil)
+		if status != test.status {
+			t.Errorf("%v %v: invalid status, expected: %v, actual: %v", test.method, test.path, test.status, status)
+		}
+	}
+}
+
+func TestServe_shutdown(t *testing.T) {
+	dir := t.TempDir()
+	svc := &wallet.Service{}
+	_, err := svc.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = svc.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err = serve(ctx, "127.0.0.1:0", dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	imported := &wallet.Service{}
+	err = imported.Import(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	records := imported.AuditLog(wallet.AuditQuery{Operation: "Import"})
+	if len(records) != 2 {
+		t.Errorf("service must be exported on shutdown, import records: %v", len(records))
+	}
+}
@@ -0,0 +1,522 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/a1ishm/wallet/pkg/types"
+	"github.com/a1ishm/wallet/pkg/wallet"
+)
+
+type argKind int
+
+const (
+	argText argKind = iota
+	argAccount
+	argPayment
+	argFavorite
+	argCategory
+	argCommand
+)
+
+type shellCommand struct {
+	name  string
+	args  []argKind
+	usage string
+}
+
+var shellCommands = []shellCommand{
+	{"help", nil, "help"},
+	{"history", nil, "history"},
+	{"load", []argKind{argText}, "load <dir>"},
+	{"save", []argKind{argText}, "save [dir]"},
+	{"accounts", nil, "accounts"},
+	{"account", []argKind{argAccount}, "account <account>"},
+	{"payments", []argKind{argAccount}, "payments <account>"},
+	{"payment", []argKind{argPayment}, "payment <payment>"},
+	{"favorites", []argKind{argAccount}, "favorites <account>"},
+	{"entries", []argKind{argAccount}, "entries <account>"},
+	{"sum", nil, "sum"},
+	{"register", []argKind{argText}, "register <phone>"},
+	{"deposit", []argKind{argAccount, argText}, "deposit <account> <amount>"},
+	{"pay", []argKind{argAccount, argText, argCategory}, "pay <account> <amount> <category>"},
+	{"reject", []argKind{argPayment}, "reject <payment>"},
+	{"repeat", []argKind{argPayment}, "repeat <payment>"},
+	{"favorite", []argKind{argPayment, argText}, "favorite <payment> <name>"},
+	{"payfav", []argKind{argFavorite}, "payfav <favorite>"},
+	{"dry", []argKind{argCommand}, "dry <command> - show what a command would change"},
+	{"exit", nil, "exit"},
+}
+
+var errExit = errors.New("exit")
+
+// shell is an interactive session over a wallet loaded from a dump
+// directory. Changes stay in memory until save.
+type shell struct {
+	printer *cli
+	svc     *wallet.Service
+	dir     string
+	history []string
+	dirty   bool
+}
+
+func newShell(svc *wallet.Service, dir string, stdout io.Writer) *shell {
+	return &shell{printer: &cli{stdout: stdout}, svc: svc, dir: dir}
+}
+
+// runShell loads dir and reads commands from in until exit or end of input.
+// On a terminal lines are edited with history and tab completion.
+func runShell(dir string, in *os.File, stdout io.Writer) error {
+	svc := &wallet.Service{}
+	err := svc.Import(dir)
+	if err != nil {
+		return err
+	}
+	sh := newShell(svc, dir, stdout)
+
+	if !isTerminal(in) {
+		reader := bufio.NewReader(in)
+		return sh.run(func() (string, error) {
+			return readPlainLine(reader)
+		})
+	}
+
+	restore, err := rawMode(in)
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	editor := &lineEditor{in: bufio.NewReader(in), out: stdout, prompt: "wallet> ", complete: sh.complete}
+	return sh.run(func() (string, error) {
+		editor.history = sh.history
+		return editor.readLine()
+	})
+}
+
+func readPlainLine(in *bufio.Reader) (string, error) {
+	line, err := in.ReadString('\n')
+	if err == io.EOF && line != "" {
+		return line, nil
+	}
+
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+func (sh *shell) run(readLine func() (string, error)) error {
+	for {
+		line, err := readLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = sh.execLine(strings.TrimSpace(line))
+		if err == errExit {
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintln(sh.printer.stdout, "error:", err)
+		}
+	}
+}
+
+// execLine runs a line, expanding !n into the n-th history entry.
+func (sh *shell) execLine(line string) error {
+	if line == "" {
+		return nil
+	}
+
+	if strings.HasPrefix(line, "!") {
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 1 || n > len(sh.history) {
+			return fmt.Errorf("no history entry %v", line[1:])
+		}
+		line = sh.history[n-1]
+		fmt.Fprintln(sh.printer.stdout, line)
+	}
+	sh.history = append(sh.history, line)
+
+	return sh.execute(strings.Fields(line))
+}
+
+func (sh *shell) execute(args []string) error {
+	command, ok := findShellCommand(args[0])
+	if !ok {
+		return fmt.Errorf("unknown command %v, try help", args[0])
+	}
+	args = args[1:]
+
+	minArgs := len(command.args)
+	switch command.name {
+	case "save", "dry":
+		minArgs = 0
+	}
+	if len(args) < minArgs || (len(args) > len(command.args) && command.name != "favorite" && command.name != "dry") {
+		return fmt.Errorf("usage: %v", command.usage)
+	}
+
+	out := sh.printer
+	switch command.name {
+	case "help":
+		for _, command := range shellCommands {
+			fmt.Fprintln(out.stdout, command.usage)
+		}
+		return nil
+
+	case "history":
+		for i, line := range sh.history {
+			fmt.Fprintf(out.stdout, "%5d  %v\n", i+1, line)
+		}
+		return nil
+
+	case "exit":
+		if sh.dirty {
+			sh.dirty = false
+			return errors.New("there are unsaved changes, save them or exit again")
+		}
+		return errExit
+
+	case "load", "save":
+		if command.name == "load" {
+			svc := &wallet.Service{}
+			err := svc.Import(args[0])
+			if err != nil {
+				return err
+			}
+			sh.svc, sh.dir, sh.dirty = svc, args[0], false
+			fmt.Fprintf(out.stdout, "loaded %v accounts\n", len(svc.Accounts()))
+			return nil
+		}
+		dir := sh.dir
+		if len(args) == 1 {
+			dir = args[0]
+		}
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return err
+		}
+		err = sh.svc.Export(dir)
+		if err != nil {
+			return err
+		}
+		sh.dirty = false
+		return nil
+
+	case "dry":
+		if len(args) == 0 {
+			return fmt.Errorf("usage: %v", command.usage)
+		}
+		return sh.dryRun(args)
+
+	case "accounts":
+		var accounts []*types.Account
+		for _, account := range sh.svc.Accounts() {
+			account := account
+			accounts = append(accounts, &account)
+		}
+		return out.printAccounts(accounts...)
+
+	case "sum":
+		_, err := fmt.Fprintln(out.stdout, sh.svc.SumPayments(1))
+		return err
+
+	case "payment":
+		payment, err := sh.svc.FindPaymentByID(args[0])
+		if err != nil {
+			return err
+		}
+		return out.printPayment(*payment)
+	}
+
+	return sh.executeAccount(command.name, args)
+}
+
+// executeAccount runs the commands that work with an account or its
+// payments.
+func (sh *shell) executeAccount(command string, args []string) error {
+	out := sh.printer
+	switch command {
+	case "account", "payments", "favorites", "entries", "deposit", "pay":
+		accountID, err := parseAccountID(args[0])
+		if err != nil {
+			return err
+		}
+
+		switch command {
+		case "account":
+			account, err := sh.svc.FindAccountByID(accountID)
+			if err != nil {
+				return err
+			}
+			return out.printAccounts(account)
+
+		case "payments":
+			payments, err := sh.svc.ExportAccountHistory(accountID)
+			if err != nil {
+				return err
+			}
+			return out.printPayments(payments...)
+
+		case "favorites":
+			favorites, err := sh.svc.AccountFavorites(accountID)
+			if err != nil {
+				return err
+			}
+			return out.printFavorites(favorites)
+
+		case "entries":
+			entries, err := sh.svc.AccountEntries(accountID)
+			if err != nil {
+				return err
+			}
+			var rows [][]interface{}
+			for _, entry := range entries {
+				rows = append(rows, []interface{}{entry.Time.Format("2006-01-02 15:04:05"), entry.Kind, entry.Amount, entry.Reference})
+			}
+			return out.printTable("TIME\tKIND\tAMOUNT\tREFERENCE", rows)
+
+		case "deposit":
+			_, amount, err := parseAccountAmount(args[0], args[1])
+			if err != nil {
+				return err
+			}
+			err = sh.svc.Deposit(accountID, amount)
+			if err != nil {
+				return err
+			}
+			sh.dirty = true
+			account, err := sh.svc.FindAccountByID(accountID)
+			if err != nil {
+				return err
+			}
+			return out.printAccounts(account)
+
+		default:
+			_, amount, err := parseAccountAmount(args[0], args[1])
+			if err != nil {
+				return err
+			}
+			payment, err := sh.svc.Pay(accountID, amount, types.PaymentCategory(args[2]))
+			if err != nil {
+				return err
+			}
+			sh.dirty = true
+			return out.printPayment(*payment)
+		}
+
+	case "register":
+		account, err := sh.svc.RegisterAccount(types.Phone(args[0]))
+		if err != nil {
+			return err
+		}
+		sh.dirty = true
+		return out.printAccounts(account)
+
+	case "reject":
+		err := sh.svc.Reject(args[0])
+		if err != nil {
+			return err
+		}
+		sh.dirty = true
+		payment, err := sh.svc.FindPaymentByID(args[0])
+		if err != nil {
+			return err
+		}
+		return out.printPayment(*payment)
+
+	case "repeat", "payfav":
+		var payment *types.Payment
+		var err error
+		if command == "repeat" {
+			payment, err = sh.svc.Repeat(args[0])
+		} else {
+			payment, err = sh.svc.PayFromFavorite(args[0])
+		}
+		if err != nil {
+			return err
+		}
+		sh.dirty = true
+		return out.printPayment(*payment)
+
+	case "favorite":
+		favorite, err := sh.svc.FavoritePayment(args[0], strings.Join(args[1:], " "))
+		if err != nil {
+			return err
+		}
+		sh.dirty = true
+		return out.printFavorites([]types.Favorite{*favorite})
+	}
+
+	return fmt.Errorf("unknown command %v", command)
+}
+
+// dryRun runs the command against a copy of the wallet made by exporting
+// and importing it and prints how balances would change. Settings that
+// aren't exported, such as fee rules, don't apply to the copy.
+func (sh *shell) dryRun(args []string) error {
+	switch args[0] {
+	case "load", "save", "exit", "dry":
+		return fmt.Errorf("%v can't be run dry", args[0])
+	}
+
+	clone, err := cloneService(sh.svc)
+	if err != nil {
+		return err
+	}
+
+	before := make(map[int64]types.Money)
+	for _, account := range clone.Accounts() {
+		before[account.ID] = account.Balance
+	}
+
+	svc, dirty := sh.svc, sh.dirty
+	sh.svc = clone
+	defer func() {
+		sh.svc, sh.dirty = svc, dirty
+	}()
+
+	err = sh.execute(args)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(sh.printer.stdout, "dry run, nothing was changed:")
+	for _, account := range clone.Accounts() {
+		balance, ok := before[account.ID]
+		switch {
+		case !ok:
+			fmt.Fprintf(sh.printer.stdout, "  account %v would be registered\n", account.ID)
+		case balance != account.Balance:
+			fmt.Fprintf(sh.printer.stdout, "  account %v balance %v -> %v\n", account.ID, balance, account.Balance)
+		}
+	}
+
+	return nil
+}
+
+func cloneService(svc *wallet.Service) (*wallet.Service, error) {
+	dir, err := ioutil.TempDir("", "wallet-dry-run")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			log.Print(err)
+		}
+	}()
+
+	err = svc.Export(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	clone := &wallet.Service{}
+	err = clone.Import(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return clone, nil
+}
+
+func findShellCommand(name string) (shellCommand, bool) {
+	for _, command := range shellCommands {
+		if command.name == name {
+			return command, true
+		}
+	}
+
+	return shellCommand{}, false
+}
+
+// complete returns the completions of the last word of line: command names
+// for the first word and account IDs, payment IDs, favorite IDs or
+// categories for arguments, depending on the command.
+func (sh *shell) complete(line string) []string {
+	words := strings.Split(line, " ")
+	for len(words) > 1 {
+		command, ok := findShellCommand(words[0])
+		if !ok || len(command.args) == 0 {
+			return nil
+		}
+		if command.args[0] == argCommand {
+			words = words[1:]
+			continue
+		}
+
+		position := len(words) - 2
+		if position >= len(command.args) {
+			return nil
+		}
+		return withPrefix(sh.candidates(command.args[position]), words[len(words)-1])
+	}
+
+	var names []string
+	for _, command := range shellCommands {
+		names = append(names, command.name)
+	}
+	return withPrefix(names, words[0])
+}
+
+func (sh *shell) candidates(kind argKind) []string {
+	var values []string
+	switch kind {
+	case argAccount:
+		for _, account := range sh.svc.Accounts() {
+			values = append(values, strconv.FormatInt(account.ID, 10))
+		}
+
+	case argPayment:
+		it := sh.svc.Payments()
+		for it.Next() {
+			values = append(values, it.Payment().ID)
+		}
+
+	case argFavorite:
+		for _, account := range sh.svc.Accounts() {
+			favorites, _ := sh.svc.AccountFavorites(account.ID)
+			for _, favorite := range favorites {
+				values = append(values, favorite.ID)
+			}
+		}
+
+	case argCategory:
+		seen := make(map[string]bool)
+		for _, category := range sh.svc.Categories() {
+			seen[string(category.ID)] = true
+		}
+		it := sh.svc.Payments()
+		for it.Next() {
+			seen[string(it.Payment().Category)] = true
+		}
+		for category := range seen {
+			values = append(values, category)
+		}
+		sort.Strings(values)
+	}
+
+	return values
+}
+
+func withPrefix(values []string, prefix string) []string {
+	var matching []string
+	for _, value := range values {
+		if strings.HasPrefix(value, prefix) {
+			matching = append(matching, value)
+		}
+	}
+
+	return matching
+}
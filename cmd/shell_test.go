@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+	"github.com/a1ishm/wallet/pkg/wallet"
+)
+
+func newTestShell(t *testing.T) (*shell, *bytes.Buffer) {
+	svc := &wallet.Service{}
+	account, err := svc.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = svc.Deposit(account.ID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := &bytes.Buffer{}
+	return newShell(svc, t.TempDir(), stdout), stdout
+}
+
+func runScript(sh *shell, script string) error {
+	in := bufio.NewReader(strings.NewReader(script))
+	return sh.run(func() (string, error) {
+		return readPlainLine(in)
+	})
+}
+
+func TestShell(t *testing.T) {
+	sh, stdout := newTestShell(t)
+
+	err := runScript(sh, "pay 1 40 food\nhistory\n!1\nunknown\naccount 1\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	account, err := sh.svc.FindAccountByID(1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account.Balance != 20 {
+		t.Errorf("invalid balance, expected: %v, actual: %v", 20, account.Balance)
+	}
+	if !strings.Contains(stdout.String(), "    1  pay 1 40 food") || !strings.Contains(stdout.String(), "error: unknown command unknown") {
+		t.Errorf("invalid output: %q", stdout)
+	}
+
+	stdout.Reset()
+	err = runScript(sh, "exit\nsave\nexit\nsum\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !strings.Contains(stdout.String(), "unsaved changes") || strings.Contains(stdout.String(), "80") {
+		t.Errorf("exit must warn about unsaved changes once and stop, output: %q", stdout)
+	}
+
+	loaded := &wallet.Service{}
+	err = loaded.Import(sh.dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if loaded.SumPayments(1) != 80 {
+		t.Errorf("saved wallet must contain the payments, sum: %v", loaded.SumPayments(1))
+	}
+}
+
+func TestShell_dryRun(t *testing.T) {
+	sh, stdout := newTestShell(t)
+
+	err := runScript(sh, "dry pay 1 30 food\ndry register +992000000002\ndry save\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, want := range []string{"account 1 balance 100 -> 70", "account 2 would be registered", "error: save can't be run dry"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("output must contain %q, output: %q", want, stdout)
+		}
+	}
+
+	account, err := sh.svc.FindAccountByID(1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account.Balance != 100 || sh.svc.SumPayments(1) != 0 || sh.dirty {
+		t.Errorf("dry run must not change the wallet, balance: %v", account.Balance)
+	}
+}
+
+func TestShell_complete(t *testing.T) {
+	sh, _ := newTestShell(t)
+	payment, err := sh.svc.Pay(1, 10, "food")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = sh.svc.Pay(1, 10, "fun")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"pa", []string{"payments", "payment", "pay", "payfav"}},
+		{"deposit ", []string{"1"}},
+		{"pay 1 10 f", []string{"food", "fun"}},
+		{"dry reject " + payment.ID[:8], []string{payment.ID}},
+		{"sum ", nil},
+	}
+
+	for _, test := range tests {
+		got := sh.complete(test.line)
+		if !reflect.DeepEqual(test.want, got) {
+			t.Errorf("%q: invalid result, expected: %v, actual: %v", test.line, test.want, got)
+		}
+	}
+}
+
+func TestLineEditor(t *testing.T) {
+	out := &bytes.Buffer{}
+	editor := &lineEditor{
+		in:      bufio.NewReader(strings.NewReader("pay 1\x1b[A\x1b[A\r" + "acc\t\t1x\x7f\r" + "fo\t\r" + "\x04")),
+		out:     out,
+		history: []string{"sum", "history"},
+		complete: func(line string) []string {
+			return withPrefix([]string{"account", "accounts", "food"}, line[strings.LastIndex(line, " ")+1:])
+		},
+	}
+
+	var lines []string
+	for {
+		line, err := editor.readLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		lines = append(lines, line)
+	}
+
+	want := []string{"sum", "account1", "food "}
+	if !reflect.DeepEqual(want, lines) {
+		t.Errorf("invalid result, expected: %q, actual: %q", want, lines)
+	}
+	if !strings.Contains(out.String(), "account  accounts") {
+		t.Errorf("ambiguous completion must list candidates, output: %q", out)
+	}
+}
+
+func TestCloneService(t *testing.T) {
+	sh, _ := newTestShell(t)
+
+	clone, err := cloneService(sh.svc)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	accounts := clone.Accounts()
+	for i := range accounts {
+		accounts[i].Created = time.Time{}
+	}
+	want := []types.Account{{ID: 1, Phone: "+992000000001", Balance: 100}}
+	if !reflect.DeepEqual(want, accounts) {
+		t.Errorf("invalid result, expected: %v, actual: %v", want, accounts)
+	}
+}
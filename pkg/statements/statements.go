@@ -0,0 +1,201 @@
+// Package statements builds account statements from the wallet ledger and
+// renders them to CSV and to printable HTML.
+package statements
+
+import (
+	"encoding/csv"
+	"errors"
+	"html/template"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+	"github.com/a1ishm/wallet/pkg/wallet"
+)
+
+var ErrInvalidPeriod = errors.New("statement period must end after it starts")
+
+type Line struct {
+	Time      time.Time
+	Kind      types.EntryKind
+	Reference string
+	Category  types.PaymentCategory
+	Amount    types.Money
+	Fee       types.Money
+	Balance   types.Money
+}
+
+// Statement covers the period [From, To). Opening + Credits + Debits is
+// always Closing, Debits being negative.
+type Statement struct {
+	AccountID int64
+	Phone     types.Phone
+	From      time.Time
+	To        time.Time
+	Opening   types.Money
+	Lines     []Line
+	Credits   types.Money
+	Debits    types.Money
+	Fees      types.Money
+	Closing   types.Money
+}
+
+// New builds the statement of the account for the period. The opening
+// balance is worked out backwards from the current balance, so the
+// statement reconciles with it even if the account has money that came
+// before the ledger was kept.
+func New(s *wallet.Service, accountID int64, from time.Time, to time.Time) (*Statement, error) {
+	if !to.After(from) {
+		return nil, ErrInvalidPeriod
+	}
+
+	account, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := s.AccountEntries(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := &Statement{
+		AccountID: accountID,
+		Phone:     account.Phone,
+		From:      from,
+		To:        to,
+		Opening:   account.Balance,
+	}
+	for _, entry := range entries {
+		if !entry.Time.Before(from) {
+			statement.Opening -= entry.Amount
+		}
+	}
+
+	balance := statement.Opening
+	for _, entry := range entries {
+		if entry.Time.Before(from) || !entry.Time.Before(to) {
+			continue
+		}
+
+		balance += entry.Amount
+		line := Line{
+			Time:      entry.Time,
+			Kind:      entry.Kind,
+			Reference: entry.Reference,
+			Amount:    entry.Amount,
+			Fee:       entry.Fee,
+			Balance:   balance,
+		}
+		if payment, err := s.FindPaymentByID(entry.Reference); err == nil {
+			line.Category = payment.Category
+		}
+		statement.Lines = append(statement.Lines, line)
+
+		if entry.Amount > 0 {
+			statement.Credits += entry.Amount
+		} else {
+			statement.Debits += entry.Amount
+		}
+		statement.Fees += entry.Fee
+	}
+	statement.Closing = balance
+
+	return statement, nil
+}
+
+// Monthly builds the statement for the calendar month in loc.
+func Monthly(s *wallet.Service, accountID int64, year int, month time.Month, loc *time.Location) (*Statement, error) {
+	from := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	return New(s, accountID, from, from.AddDate(0, 1, 0))
+}
+
+// FormatMoney formats an amount in minor units as units with two decimals.
+func FormatMoney(amount types.Money) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	cents := strconv.FormatInt(int64(amount%100), 10)
+	if len(cents) == 1 {
+		cents = "0" + cents
+	}
+
+	return sign + strconv.FormatInt(int64(amount/100), 10) + "." + cents
+}
+
+// WriteCSV writes the statement as CSV: a header, the opening balance, a
+// row per line and the closing balance.
+func (st *Statement) WriteCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	rows := [][]string{
+		{"date", "type", "reference", "category", "amount", "fee", "balance"},
+		{st.From.Format(time.RFC3339), "OPENING", "", "", "", "", FormatMoney(st.Opening)},
+	}
+	for _, line := range st.Lines {
+		rows = append(rows, []string{
+			line.Time.Format(time.RFC3339),
+			string(line.Kind),
+			line.Reference,
+			string(line.Category),
+			FormatMoney(line.Amount),
+			FormatMoney(line.Fee),
+			FormatMoney(line.Balance),
+		})
+	}
+	rows = append(rows, []string{st.To.Format(time.RFC3339), "CLOSING", "", "", "", "", FormatMoney(st.Closing)})
+
+	err := out.WriteAll(rows)
+	if err != nil {
+		return err
+	}
+
+	return out.Error()
+}
+
+var htmlTemplate = template.Must(template.New("statement").Funcs(template.FuncMap{
+	"money": FormatMoney,
+	"date": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04")
+	},
+	"day": func(t time.Time) string {
+		return t.Format("2006-01-02")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Statement {{.AccountID}}</title>
+<style>
+body { font-family: sans-serif; font-size: 12px; }
+table { width: 100%; border-collapse: collapse; }
+th, td { padding: 4px; border-bottom: 1px solid #ccc; text-align: left; }
+td.money, th.money { text-align: right; }
+@media print { @page { size: A4; margin: 15mm; } }
+</style>
+</head>
+<body>
+<h1>Account statement</h1>
+<p>Account {{.AccountID}}, {{.Phone}}<br>Period {{day .From}} &ndash; {{day .To}}</p>
+<table>
+<thead>
+<tr><th>Date</th><th>Type</th><th>Reference</th><th>Category</th><th class="money">Amount</th><th class="money">Fee</th><th class="money">Balance</th></tr>
+</thead>
+<tbody>
+<tr><td>{{date .From}}</td><td colspan="5">Opening balance</td><td class="money">{{money .Opening}}</td></tr>
+{{range .Lines}}<tr><td>{{date .Time}}</td><td>{{.Kind}}</td><td>{{.Reference}}</td><td>{{.Category}}</td><td class="money">{{money .Amount}}</td><td class="money">{{money .Fee}}</td><td class="money">{{money .Balance}}</td></tr>
+{{end}}<tr><td>{{date .To}}</td><td colspan="5">Closing balance</td><td class="money">{{money .Closing}}</td></tr>
+</tbody>
+</table>
+<p>Credits {{money .Credits}}, debits {{money .Debits}}, of them fees {{money .Fees}}.</p>
+</body>
+</html>
+`))
+
+// WriteHTML renders the statement as an HTML page suitable for printing.
+func (st *Statement) WriteHTML(w io.Writer) error {
+	return htmlTemplate.Execute(w, st)
+}
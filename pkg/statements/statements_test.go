@@ -0,0 +1,218 @@
+package statements
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+	"github.com/a1ishm/wallet/pkg/wallet"
+)
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+// newTestStatementService registers an account with a deposit in January
+// and a deposit, a rejected payment and a paid one in February 2021.
+func newTestStatementService() (*wallet.Service, *types.Account, *testClock, error) {
+	s := &wallet.Service{}
+	clock := &testClock{now: time.Date(2021, 1, 20, 10, 0, 0, 0, time.UTC)}
+	s.SetClock(clock)
+
+	account, err := s.RegisterAccount("+992000000001")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	err = s.AddFeeRule(wallet.FeeRule{Flat: 1_00})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	err = s.Deposit(account.ID, 100_00)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	clock.now = time.Date(2021, 2, 3, 10, 0, 0, 0, time.UTC)
+	err = s.Deposit(account.ID, 50_00)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	clock.now = time.Date(2021, 2, 10, 10, 0, 0, 0, time.UTC)
+	payment, err := s.Pay(account.ID, 20_00, "food")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	err = s.Reject(payment.ID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	clock.now = time.Date(2021, 2, 15, 10, 0, 0, 0, time.UTC)
+	_, err = s.Pay(account.ID, 30_00, "auto")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	clock.now = time.Date(2021, 3, 2, 10, 0, 0, 0, time.UTC)
+	err = s.Deposit(account.ID, 5_00)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return s, account, clock, nil
+}
+
+func TestMonthly(t *testing.T) {
+	s, account, _, err := newTestStatementService()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	statement, err := Monthly(s, account.ID, 2021, time.February, time.UTC)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var kinds []types.EntryKind
+	var balances []types.Money
+	for _, line := range statement.Lines {
+		kinds = append(kinds, line.Kind)
+		balances = append(balances, line.Balance)
+	}
+
+	wantKinds := []types.EntryKind{types.EntryDeposit, types.EntryPayment, types.EntryRefund, types.EntryPayment}
+	if !reflect.DeepEqual(wantKinds, kinds) {
+		t.Errorf("invalid lines, expected: %v, actual: %v", wantKinds, kinds)
+	}
+	wantBalances := []types.Money{150_00, 129_00, 150_00, 119_00}
+	if !reflect.DeepEqual(wantBalances, balances) {
+		t.Errorf("invalid balances, expected: %v, actual: %v", wantBalances, balances)
+	}
+	if statement.Opening != 100_00 || statement.Closing != 119_00 {
+		t.Errorf("invalid opening and closing, expected: 10000 and 11900, actual: %v and %v", statement.Opening, statement.Closing)
+	}
+	if statement.Opening+statement.Credits+statement.Debits != statement.Closing {
+		t.Errorf("statement doesn't reconcile: %v + %v + %v != %v", statement.Opening, statement.Credits, statement.Debits, statement.Closing)
+	}
+	if statement.Lines[3].Category != "auto" || statement.Fees != -1_00 {
+		t.Errorf("invalid category or fees: %v, %v", statement.Lines[3].Category, statement.Fees)
+	}
+}
+
+func TestNew_reconcilesWithBalance(t *testing.T) {
+	s, account, clock, err := newTestStatementService()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	statement, err := New(s, account.ID, time.Time{}, clock.now.Add(time.Second))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if statement.Opening != 0 || statement.Closing != account.Balance {
+		t.Errorf("invalid result, expected: 0 and %v, actual: %v and %v", account.Balance, statement.Opening, statement.Closing)
+	}
+}
+
+func TestNew_invalidPeriod(t *testing.T) {
+	s, account, clock, err := newTestStatementService()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = New(s, account.ID, clock.now, clock.now)
+	if !errors.Is(err, ErrInvalidPeriod) {
+		t.Errorf("New(): must return ErrInvalidPeriod, returned %v", err)
+	}
+
+	_, err = New(s, account.ID+1, time.Time{}, clock.now)
+	if !errors.Is(err, wallet.ErrAccountNotFound) {
+		t.Errorf("New(): must return ErrAccountNotFound, returned %v", err)
+	}
+}
+
+func TestStatement_WriteCSV(t *testing.T) {
+	s, account, _, err := newTestStatementService()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	statement, err := Monthly(s, account.ID, 2021, time.March, time.UTC)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	err = statement.WriteCSV(buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	deposits, err := s.ExportAccountDeposits(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	want := "date,type,reference,category,amount,fee,balance\n" +
+		"2021-03-01T00:00:00Z,OPENING,,,,,119.00\n" +
+		"2021-03-02T10:00:00Z,DEPOSIT," + deposits[2].ID + ",,5.00,0.00,124.00\n" +
+		"2021-04-01T00:00:00Z,CLOSING,,,,,124.00\n"
+	if buf.String() != want {
+		t.Errorf("invalid result, expected: %q, actual: %q", want, buf.String())
+	}
+}
+
+func TestStatement_WriteHTML(t *testing.T) {
+	s, account, _, err := newTestStatementService()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	statement, err := Monthly(s, account.ID, 2021, time.February, time.UTC)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	err = statement.WriteHTML(buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, want := range []string{"992000000001", "2021-02-01", "<td class=\"money\">100.00</td>", "<td class=\"money\">119.00</td>"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("rendered statement must contain %q", want)
+		}
+	}
+}
+
+func TestFormatMoney(t *testing.T) {
+	for amount, want := range map[types.Money]string{0: "0.00", 5: "0.05", 12_34: "12.34", -1_00: "-1.00"} {
+		if got := FormatMoney(amount); got != want {
+			t.Errorf("invalid result for %v, expected: %v, actual: %v", amount, want, got)
+		}
+	}
+}
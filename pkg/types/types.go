@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 type Money int64
 
 type PaymentCategory string
@@ -10,6 +12,8 @@ const (
 	PaymentStatusOk         PaymentStatus = "OK"
 	PaymentStatusFail       PaymentStatus = "FAIL"
 	PaymentStatusInProgress PaymentStatus = "INPROGRESS"
+	PaymentStatusReview     PaymentStatus = "REVIEW"
+	PaymentStatusPending    PaymentStatus = "PENDING"
 )
 
 type Payment struct {
@@ -18,14 +22,20 @@ type Payment struct {
 	Amount    Money
 	Category  PaymentCategory
 	Status    PaymentStatus
+	Fee       Money
+	Created   time.Time
 }
 
 type Phone string
 
+type AccountTier string
+
 type Account struct {
 	ID      int64
 	Phone   Phone
 	Balance Money
+	Tier    AccountTier
+	Created time.Time
 }
 
 type Favorite struct {
@@ -35,3 +45,295 @@ type Favorite struct {
 	Amount    Money
 	Category  PaymentCategory
 }
+
+type Reward struct {
+	ID         string
+	CampaignID string
+	PaymentID  string
+	AccountID  int64
+	Amount     Money
+	Bonus      bool
+	Reversed   bool
+}
+
+type ScheduleFrequency string
+
+const (
+	ScheduleDaily   ScheduleFrequency = "DAILY"
+	ScheduleWeekly  ScheduleFrequency = "WEEKLY"
+	ScheduleMonthly ScheduleFrequency = "MONTHLY"
+	ScheduleCron    ScheduleFrequency = "CRON"
+)
+
+type Schedule struct {
+	ID         string
+	FavoriteID string
+	Frequency  ScheduleFrequency
+	Cron       string
+	Start      time.Time
+	End        time.Time
+	Count      int
+	Next       time.Time
+	RetryAt    time.Time
+	Attempts   int
+	Runs       int
+	Active     bool
+}
+
+type ScheduleRunStatus string
+
+const (
+	ScheduleRunOk    ScheduleRunStatus = "OK"
+	ScheduleRunRetry ScheduleRunStatus = "RETRY"
+	ScheduleRunFail  ScheduleRunStatus = "FAIL"
+)
+
+type ScheduleRun struct {
+	ScheduleID string
+	Time       time.Time
+	PaymentID  string
+	Status     ScheduleRunStatus
+	Error      string
+}
+
+type BudgetPeriod string
+
+const (
+	BudgetWeekly  BudgetPeriod = "WEEKLY"
+	BudgetMonthly BudgetPeriod = "MONTHLY"
+)
+
+type BudgetMode string
+
+const (
+	BudgetSoft BudgetMode = "SOFT"
+	BudgetHard BudgetMode = "HARD"
+)
+
+type Budget struct {
+	ID          string
+	AccountID   int64
+	Category    PaymentCategory
+	Period      BudgetPeriod
+	Mode        BudgetMode
+	Limit       Money
+	Spent       Money
+	PeriodStart time.Time
+	Alerted     int
+}
+
+type BudgetAlert struct {
+	BudgetID  string
+	AccountID int64
+	Category  PaymentCategory
+	Threshold int
+	Spent     Money
+	Limit     Money
+	Time      time.Time
+}
+
+type EntryKind string
+
+const (
+	EntryPayment  EntryKind = "PAYMENT"
+	EntryRefund   EntryKind = "REFUND"
+	EntryDeposit  EntryKind = "DEPOSIT"
+	EntryCashback EntryKind = "CASHBACK"
+	EntryClawback EntryKind = "CLAWBACK"
+	EntryReversal EntryKind = "REVERSAL"
+)
+
+// Entry is a change of an account balance. Amount is signed and includes
+// Fee, the part of it that is a fee (negative when charged, positive when
+// refunded). Reference is the ID of the payment or deposit the entry belongs
+// to.
+type Entry struct {
+	AccountID int64
+	Kind      EntryKind
+	Amount    Money
+	Fee       Money
+	Reference string
+	Time      time.Time
+}
+
+type DepositChannel string
+
+const (
+	DepositCard     DepositChannel = "CARD"
+	DepositBank     DepositChannel = "BANK"
+	DepositCash     DepositChannel = "CASH"
+	DepositTransfer DepositChannel = "TRANSFER"
+)
+
+type Deposit struct {
+	ID        string
+	AccountID int64
+	Amount    Money
+	Channel   DepositChannel
+	Source    string
+	Reference string
+	Created   time.Time
+	Reversed  bool
+}
+
+// Transaction is a payment or a deposit in the history of an account, Kind
+// tells which. Amount is negative for payments. Deposits have no category,
+// their status is OK, or FAIL once reversed.
+type Transaction struct {
+	ID        string
+	AccountID int64
+	Kind      EntryKind
+	Amount    Money
+	Category  PaymentCategory
+	Channel   DepositChannel
+	Status    PaymentStatus
+	Created   time.Time
+}
+
+type AuditBalance struct {
+	AccountID int64
+	Before    Money
+	After     Money
+}
+
+// AuditRecord describes a call that changed the service. Hash covers the
+// record and PrevHash, the hash of the record before it, so records can't
+// be edited, removed or reordered unnoticed.
+type AuditRecord struct {
+	Seq       int64
+	Time      time.Time
+	Actor     string
+	Operation string
+	AccountID int64
+	Arguments []string
+	Balances  []AuditBalance
+	Error     string
+	PrevHash  string
+	Hash      string
+}
+
+type EventType string
+
+const (
+	EventAccountRegistered EventType = "ACCOUNT_REGISTERED"
+	EventDeposited         EventType = "DEPOSITED"
+	EventPaymentCreated    EventType = "PAYMENT_CREATED"
+	EventPaymentRejected   EventType = "PAYMENT_REJECTED"
+	EventPaymentHeld       EventType = "PAYMENT_HELD"
+	EventPaymentReleased   EventType = "PAYMENT_RELEASED"
+	EventPaymentPending    EventType = "PAYMENT_PENDING"
+	EventPaymentApproved   EventType = "PAYMENT_APPROVED"
+	EventPaymentExpired    EventType = "PAYMENT_EXPIRED"
+	EventPaymentConfirmed  EventType = "PAYMENT_CONFIRMED"
+	EventFavoriteCreated   EventType = "FAVORITE_CREATED"
+)
+
+// Event is something that happened to an account. ObjectID is the ID of the
+// payment, deposit or favorite the event is about, or the phone of the
+// registered account. Seq grows by one with every event of the service.
+type Event struct {
+	ID        string
+	Seq       int64
+	Type      EventType
+	AccountID int64
+	ObjectID  string
+	Amount    Money
+	Category  PaymentCategory
+	Time      time.Time
+}
+
+// Webhook is an endpoint notified about events of the given types, all
+// types when Events is empty.
+type Webhook struct {
+	ID      string
+	URL     string
+	Secret  string
+	Events  []EventType
+	Created time.Time
+}
+
+// DeadLetter is an event that couldn't be delivered to a webhook.
+type DeadLetter struct {
+	ID        string
+	WebhookID string
+	Event     Event
+	Attempts  int
+	Error     string
+	Failed    time.Time
+}
+
+// OutboxMessage is an event saved with the service state until it is
+// published. Attempts is the number of failed attempts to publish it and
+// Error is the last failure.
+type OutboxMessage struct {
+	Event    Event
+	Attempts int
+	Error    string
+}
+
+// RiskReview is a payment held by risk checks until it is approved or
+// rejected. Reasons are what the rules that held it said.
+type RiskReview struct {
+	PaymentID string
+	AccountID int64
+	Amount    Money
+	Reasons   []string
+	Created   time.Time
+}
+
+// ApprovalPolicy requires payments of the account larger than Threshold to
+// be approved by Required different users. Approvers are the logins of the
+// users who may approve, any users but the account's owners when empty.
+// Unapproved payments expire after Expiry, zero means never.
+type ApprovalPolicy struct {
+	AccountID int64
+	Threshold Money
+	Required  int
+	Approvers []string
+	Expiry    time.Duration
+}
+
+// Approval is a payment waiting for approvals. Approvers are the logins of
+// the users who have already approved it.
+type Approval struct {
+	PaymentID string
+	AccountID int64
+	Amount    Money
+	Required  int
+	Approvers []string
+	Created   time.Time
+	Expires   time.Time
+}
+
+type Role string
+
+const (
+	RoleOwner      Role = "OWNER"
+	RoleJointOwner Role = "JOINT_OWNER"
+	RoleViewer     Role = "VIEWER"
+)
+
+// User is a person using the wallet. Credential is the PIN or password
+// hashed with a KDF, never the secret itself.
+type User struct {
+	ID         string
+	Login      string
+	Credential string
+	Created    time.Time
+}
+
+// Membership links a user to an account with a role.
+type Membership struct {
+	UserID    string
+	AccountID int64
+	Role      Role
+}
+
+// Session is a logged in user. TokenHash is the SHA-256 of the token given
+// to the user.
+type Session struct {
+	TokenHash string
+	UserID    string
+	Created   time.Time
+	Expires   time.Time
+}
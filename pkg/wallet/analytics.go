@@ -0,0 +1,183 @@
+package wallet
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+type GroupBy uint8
+
+const (
+	GroupByCategory GroupBy = 1 << iota
+	GroupByAccount
+	GroupByStatus
+)
+
+// AnalyticsQuery selects payments for Analytics. Zero From and To leave the
+// window open; an empty Statuses list selects every status except FAIL, so
+// rejected payments aren't counted unless asked for. With Deposits deposits
+// are counted too, in groups of their own: they have no category and their
+// status is OK, or FAIL once reversed.
+type AnalyticsQuery struct {
+	GroupBy    GroupBy
+	From       time.Time
+	To         time.Time
+	Statuses   []types.PaymentStatus
+	Deposits   bool
+	Goroutines int
+}
+
+// AnalyticsKey identifies a group. Fields not selected by GroupBy are left
+// zero. Kind tells payments from deposits and is set only when the query
+// asks for deposits.
+type AnalyticsKey struct {
+	Category  types.PaymentCategory
+	AccountID int64
+	Status    types.PaymentStatus
+	Kind      types.EntryKind
+}
+
+type AnalyticsGroup struct {
+	Key     AnalyticsKey
+	Count   int
+	Total   types.Money
+	Average types.Money
+	Min     types.Money
+	Max     types.Money
+	P50     types.Money
+	P90     types.Money
+	P99     types.Money
+}
+
+func (q AnalyticsQuery) matches(status types.PaymentStatus, created time.Time) bool {
+	if len(q.Statuses) == 0 {
+		if status == types.PaymentStatusFail {
+			return false
+		}
+	} else {
+		found := false
+		for _, item := range q.Statuses {
+			if status == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if !q.From.IsZero() && created.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !created.Before(q.To) {
+		return false
+	}
+
+	return true
+}
+
+func (q AnalyticsQuery) key(kind types.EntryKind, category types.PaymentCategory, accountID int64, status types.PaymentStatus) AnalyticsKey {
+	var key AnalyticsKey
+	if q.GroupBy&GroupByCategory != 0 {
+		key.Category = category
+	}
+	if q.GroupBy&GroupByAccount != 0 {
+		key.AccountID = accountID
+	}
+	if q.GroupBy&GroupByStatus != 0 {
+		key.Status = status
+	}
+	if q.Deposits {
+		key.Kind = kind
+	}
+
+	return key
+}
+
+// Analytics returns totals, counts, averages and percentiles of payment
+// amounts grouped as the query asks, ordered by group key. Payments are
+// processed in parallel by up to query.Goroutines goroutines.
+func (s *Service) Analytics(query AnalyticsQuery) []AnalyticsGroup {
+	result := s.mapReducePayments(query.Goroutines, func(payments []*types.Payment) interface{} {
+		amounts := make(map[AnalyticsKey][]types.Money)
+		for _, payment := range payments {
+			if query.matches(payment.Status, payment.Created) {
+				key := query.key(types.EntryPayment, payment.Category, payment.AccountID, payment.Status)
+				amounts[key] = append(amounts[key], payment.Amount)
+			}
+		}
+		return amounts
+	}, func(result interface{}, partial interface{}) interface{} {
+		amounts := result.(map[AnalyticsKey][]types.Money)
+		for key, values := range partial.(map[AnalyticsKey][]types.Money) {
+			amounts[key] = append(amounts[key], values...)
+		}
+		return amounts
+	}, make(map[AnalyticsKey][]types.Money))
+	amounts := result.(map[AnalyticsKey][]types.Money)
+	if query.Deposits {
+		for _, deposit := range s.deposits {
+			status := depositStatus(deposit)
+			if query.matches(status, deposit.Created) {
+				key := query.key(types.EntryDeposit, "", deposit.AccountID, status)
+				amounts[key] = append(amounts[key], deposit.Amount)
+			}
+		}
+	}
+
+	groups := make([]AnalyticsGroup, 0, len(amounts))
+	for key, values := range amounts {
+		groups = append(groups, analyticsGroup(key, values))
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i].Key, groups[j].Key
+		if a.Category != b.Category {
+			return a.Category < b.Category
+		}
+		if a.AccountID != b.AccountID {
+			return a.AccountID < b.AccountID
+		}
+		if a.Status != b.Status {
+			return a.Status < b.Status
+		}
+		return a.Kind < b.Kind
+	})
+	return groups
+}
+
+func analyticsGroup(key AnalyticsKey, amounts []types.Money) AnalyticsGroup {
+	sort.Slice(amounts, func(i, j int) bool {
+		return amounts[i] < amounts[j]
+	})
+
+	group := AnalyticsGroup{
+		Key:   key,
+		Count: len(amounts),
+		Min:   amounts[0],
+		Max:   amounts[len(amounts)-1],
+		P50:   percentile(amounts, 50),
+		P90:   percentile(amounts, 90),
+		P99:   percentile(amounts, 99),
+	}
+	for _, amount := range amounts {
+		group.Total += amount
+	}
+	group.Average = group.Total / types.Money(group.Count)
+
+	return group
+}
+
+// percentile returns the nearest-rank percentile of sorted amounts.
+func percentile(sorted []types.Money, p int) types.Money {
+	rank := int(math.Ceil(float64(p) / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
@@ -0,0 +1,99 @@
+package wallet
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+func analyticsTestPayments() []*types.Payment {
+	day := func(d int) time.Time {
+		return time.Date(2021, 5, d, 12, 0, 0, 0, time.UTC)
+	}
+
+	return []*types.Payment{
+		{ID: "A", AccountID: 1, Amount: 10_00, Category: "auto", Status: types.PaymentStatusOk, Created: day(1)},
+		{ID: "B", AccountID: 2, Amount: 20_00, Category: "auto", Status: types.PaymentStatusOk, Created: day(2)},
+		{ID: "C", AccountID: 1, Amount: 30_00, Category: "food", Status: types.PaymentStatusInProgress, Created: day(3)},
+		{ID: "D", AccountID: 1, Amount: 40_00, Category: "food", Status: types.PaymentStatusFail, Created: day(4)},
+		{ID: "E", AccountID: 2, Amount: 50_00, Category: "auto", Status: types.PaymentStatusOk, Created: day(5)},
+		{ID: "F", AccountID: 1, Amount: 60_00, Category: "food", Status: types.PaymentStatusOk, Created: day(6)},
+		{ID: "G", AccountID: 1, Amount: 70_00, Category: "auto", Status: types.PaymentStatusOk, Created: day(7)},
+	}
+}
+
+func TestService_Analytics_byCategory(t *testing.T) {
+	s := newTestService()
+	s.payments = append(s.payments, analyticsTestPayments()...)
+
+	want := []AnalyticsGroup{
+		{Key: AnalyticsKey{Category: "auto"}, Count: 4, Total: 150_00, Average: 37_50, Min: 10_00, Max: 70_00, P50: 20_00, P90: 70_00, P99: 70_00},
+		{Key: AnalyticsKey{Category: "food"}, Count: 2, Total: 90_00, Average: 45_00, Min: 30_00, Max: 60_00, P50: 30_00, P90: 60_00, P99: 60_00},
+	}
+
+	for _, goroutines := range []int{0, 1, 3, 100} {
+		got := s.Analytics(AnalyticsQuery{GroupBy: GroupByCategory, Goroutines: goroutines})
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("goroutines %v: invalid result, expected: %v, actual: %v", goroutines, want, got)
+		}
+	}
+}
+
+func TestService_Analytics_window(t *testing.T) {
+	s := newTestService()
+	s.payments = append(s.payments, analyticsTestPayments()...)
+
+	got := s.Analytics(AnalyticsQuery{
+		GroupBy:    GroupByAccount | GroupByStatus,
+		From:       time.Date(2021, 5, 3, 0, 0, 0, 0, time.UTC),
+		To:         time.Date(2021, 5, 7, 0, 0, 0, 0, time.UTC),
+		Statuses:   []types.PaymentStatus{types.PaymentStatusOk, types.PaymentStatusFail},
+		Goroutines: 2,
+	})
+
+	want := []AnalyticsGroup{
+		{Key: AnalyticsKey{AccountID: 1, Status: types.PaymentStatusFail}, Count: 1, Total: 40_00, Average: 40_00, Min: 40_00, Max: 40_00, P50: 40_00, P90: 40_00, P99: 40_00},
+		{Key: AnalyticsKey{AccountID: 1, Status: types.PaymentStatusOk}, Count: 1, Total: 60_00, Average: 60_00, Min: 60_00, Max: 60_00, P50: 60_00, P90: 60_00, P99: 60_00},
+		{Key: AnalyticsKey{AccountID: 2, Status: types.PaymentStatusOk}, Count: 1, Total: 50_00, Average: 50_00, Min: 50_00, Max: 50_00, P50: 50_00, P90: 50_00, P99: 50_00},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("invalid result, expected: %v, actual: %v", want, got)
+	}
+}
+
+func TestService_Analytics_empty(t *testing.T) {
+	s := newTestService()
+
+	got := s.Analytics(AnalyticsQuery{GroupBy: GroupByCategory, Goroutines: 4})
+	if len(got) != 0 {
+		t.Errorf("invalid result, expected no groups, actual: %v", got)
+	}
+}
+
+func TestService_Analytics_deposits(t *testing.T) {
+	s := newTestService()
+	s.payments = append(s.payments, analyticsTestPayments()...)
+	s.deposits = append(s.deposits,
+		&types.Deposit{ID: "X", AccountID: 1, Amount: 100_00, Channel: types.DepositCard, Created: time.Date(2021, 5, 2, 12, 0, 0, 0, time.UTC)},
+		&types.Deposit{ID: "Y", AccountID: 1, Amount: 300_00, Channel: types.DepositBank, Created: time.Date(2021, 5, 3, 12, 0, 0, 0, time.UTC)},
+		&types.Deposit{ID: "Z", AccountID: 1, Amount: 500_00, Channel: types.DepositBank, Created: time.Date(2021, 5, 4, 12, 0, 0, 0, time.UTC), Reversed: true},
+		&types.Deposit{ID: "W", AccountID: 2, Amount: 700_00, Channel: types.DepositCash, Created: time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)},
+	)
+
+	got := s.Analytics(AnalyticsQuery{
+		GroupBy:  GroupByAccount,
+		To:       time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC),
+		Deposits: true,
+	})
+
+	want := []AnalyticsGroup{
+		{Key: AnalyticsKey{AccountID: 1, Kind: types.EntryDeposit}, Count: 2, Total: 400_00, Average: 200_00, Min: 100_00, Max: 300_00, P50: 100_00, P90: 300_00, P99: 300_00},
+		{Key: AnalyticsKey{AccountID: 1, Kind: types.EntryPayment}, Count: 4, Total: 170_00, Average: 42_50, Min: 10_00, Max: 70_00, P50: 30_00, P90: 70_00, P99: 70_00},
+		{Key: AnalyticsKey{AccountID: 2, Kind: types.EntryPayment}, Count: 2, Total: 70_00, Average: 35_00, Min: 20_00, Max: 50_00, P50: 20_00, P90: 50_00, P99: 50_00},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("invalid result, expected: %v, actual: %v", want, got)
+	}
+}
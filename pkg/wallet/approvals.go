@@ -0,0 +1,381 @@
+package wallet
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+var ErrInvalidApprovalPolicy = errors.New("invalid approval policy")
+var ErrApprovalPolicyNotFound = errors.New("approval policy not found")
+var ErrApprovalNotFound = errors.New("payment is not waiting for approval")
+var ErrApprovalExpired = errors.New("approval expired")
+var ErrNotApprover = errors.New("not an approver of the account")
+var ErrAlreadyApproved = errors.New("payment already approved by this approver")
+
+// SetApprovalPolicy sets the approval policy of the account, replacing the
+// existing one. Payments already waiting for approval keep their number of
+// required approvals.
+func (s *Service) SetApprovalPolicy(policy types.ApprovalPolicy) (err error) {
+	defer s.audit("SetApprovalPolicy", policy.AccountID, policy).done(&err)
+
+	_, err = s.FindAccountByID(policy.AccountID)
+	if err != nil {
+		return err
+	}
+
+	if policy.Threshold < 0 || policy.Required < 1 || policy.Expiry < 0 {
+		return newError("SetApprovalPolicy", ErrInvalidApprovalPolicy, policy.AccountID, "", policy.Threshold)
+	}
+	if len(policy.Approvers) != 0 && policy.Required > len(policy.Approvers) {
+		return newError("SetApprovalPolicy", ErrInvalidApprovalPolicy, policy.AccountID, "", policy.Threshold)
+	}
+	for _, approver := range policy.Approvers {
+		if approver == "" {
+			return newError("SetApprovalPolicy", ErrInvalidApprovalPolicy, policy.AccountID, "", policy.Threshold)
+		}
+	}
+
+	policy.Approvers = append([]string{}, policy.Approvers...)
+	for i, item := range s.approvalPolicies {
+		if item.AccountID == policy.AccountID {
+			s.approvalPolicies[i] = &policy
+			return nil
+		}
+	}
+
+	s.approvalPolicies = append(s.approvalPolicies, &policy)
+	return nil
+}
+
+func (s *Service) FindApprovalPolicy(accountID int64) (*types.ApprovalPolicy, error) {
+	for _, policy := range s.approvalPolicies {
+		if policy.AccountID == accountID {
+			return policy, nil
+		}
+	}
+
+	return nil, newError("FindApprovalPolicy", ErrApprovalPolicyNotFound, accountID, "", 0)
+}
+
+// RemoveApprovalPolicy stops requiring approvals for new payments of the
+// account.
+func (s *Service) RemoveApprovalPolicy(accountID int64) (err error) {
+	defer s.audit("RemoveApprovalPolicy", accountID, accountID).done(&err)
+
+	for i, policy := range s.approvalPolicies {
+		if policy.AccountID == accountID {
+			s.approvalPolicies = append(s.approvalPolicies[:i], s.approvalPolicies[i+1:]...)
+			return nil
+		}
+	}
+
+	return newError("RemoveApprovalPolicy", ErrApprovalPolicyNotFound, accountID, "", 0)
+}
+
+// requireApproval returns the approval a payment needs or nil.
+func (s *Service) requireApproval(payment *types.Payment) *types.Approval {
+	policy, err := s.FindApprovalPolicy(payment.AccountID)
+	if err != nil || payment.Amount <= policy.Threshold {
+		return nil
+	}
+
+	approval := &types.Approval{
+		PaymentID: payment.ID,
+		AccountID: payment.AccountID,
+		Amount:    payment.Amount,
+		Required:  policy.Required,
+		Approvers: []string{},
+		Created:   payment.Created,
+	}
+	if policy.Expiry > 0 {
+		approval.Expires = payment.Created.Add(policy.Expiry)
+	}
+
+	return approval
+}
+
+func (s *Service) findApproval(paymentID string) *types.Approval {
+	for _, approval := range s.approvals {
+		if approval.PaymentID == paymentID {
+			return approval
+		}
+	}
+
+	return nil
+}
+
+// PendingApprovals returns the payments of the account waiting for
+// approval, oldest first.
+func (s *Service) PendingApprovals(accountID int64) ([]types.Approval, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	approvals := []types.Approval{}
+	for _, approval := range s.approvals {
+		if approval.AccountID != accountID {
+			continue
+		}
+		payment, err := s.FindPaymentByID(approval.PaymentID)
+		if err == nil && payment.Status == types.PaymentStatusPending {
+			approvals = append(approvals, *approval)
+		}
+	}
+
+	return approvals, nil
+}
+
+// pendingApproval returns the approval of a payment waiting for it, checking
+// that the user may approve payments of the account. Owners and joint owners
+// can't approve their own payments.
+func (s *Service) pendingApproval(op string, paymentID string, user *types.User) (*types.Approval, error) {
+	payment, err := s.FindPaymentByID(paymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	approval := s.findApproval(paymentID)
+	if approval == nil || payment.Status != types.PaymentStatusPending {
+		return nil, newError(op, ErrApprovalNotFound, payment.AccountID, paymentID, 0)
+	}
+	if !approval.Expires.IsZero() && !s.now().Before(approval.Expires) {
+		return nil, newError(op, ErrApprovalExpired, payment.AccountID, paymentID, 0)
+	}
+
+	membership := s.findMembership(user.ID, payment.AccountID)
+	if membership != nil && membership.Role != types.RoleViewer {
+		return nil, newError(op, ErrNotApprover, payment.AccountID, user.Login, 0)
+	}
+	policy, err := s.FindApprovalPolicy(payment.AccountID)
+	if err == nil && len(policy.Approvers) != 0 && !containsString(policy.Approvers, user.Login) {
+		return nil, newError(op, ErrNotApprover, payment.AccountID, user.Login, 0)
+	}
+
+	return approval, nil
+}
+
+// Approve records the approval of a payment waiting for it by the user.
+// Once enough different users approved it the payment goes on as usual.
+func (a *Authorized) Approve(paymentID string) (*types.Payment, error) {
+	user, err := a.s.SessionUser(a.token)
+	if err != nil {
+		return nil, err
+	}
+	defer a.as(user)()
+
+	return a.s.approve(paymentID, user)
+}
+
+func (s *Service) approve(paymentID string, user *types.User) (_ *types.Payment, err error) {
+	defer s.audit("Approve", 0, paymentID, user.Login).done(&err)
+
+	approval, err := s.pendingApproval("Approve", paymentID, user)
+	if err != nil {
+		return nil, err
+	}
+	if containsString(approval.Approvers, user.Login) {
+		return nil, newError("Approve", ErrAlreadyApproved, approval.AccountID, user.Login, 0)
+	}
+
+	payment, err := s.FindPaymentByID(paymentID)
+	if err != nil {
+		return nil, err
+	}
+	account, err := s.FindAccountByID(payment.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(approval.Approvers)+1 >= approval.Required {
+		err = s.releasePayment("Approve", account, payment, types.EventPaymentApproved)
+		if err != nil {
+			return nil, err
+		}
+	}
+	approval.Approvers = append(approval.Approvers, user.Login)
+
+	return payment, nil
+}
+
+// Decline rejects a payment waiting for approval and releases its funds.
+func (a *Authorized) Decline(paymentID string) error {
+	user, err := a.s.SessionUser(a.token)
+	if err != nil {
+		return err
+	}
+	defer a.as(user)()
+
+	return a.s.decline(paymentID, user)
+}
+
+func (s *Service) decline(paymentID string, user *types.User) (err error) {
+	defer s.audit("Decline", 0, paymentID, user.Login).done(&err)
+
+	_, err = s.pendingApproval("Decline", paymentID, user)
+	if err != nil {
+		return err
+	}
+
+	return s.Reject(paymentID)
+}
+
+// ExpireApprovals rejects payments whose approval expired by the service
+// clock, releasing their funds, and returns their approvals.
+func (s *Service) ExpireApprovals() []types.Approval {
+	defer s.audit("ExpireApprovals", 0).done(nil)
+
+	now := s.now()
+
+	var expired []types.Approval
+	for _, approval := range s.approvals {
+		if approval.Expires.IsZero() || now.Before(approval.Expires) {
+			continue
+		}
+
+		payment, err := s.FindPaymentByID(approval.PaymentID)
+		if err != nil || payment.Status != types.PaymentStatusPending {
+			continue
+		}
+
+		err = s.rejectPayment(payment, types.EventPaymentExpired)
+		if err != nil {
+			continue
+		}
+		expired = append(expired, *approval)
+	}
+
+	return expired
+}
+
+func containsString(values []string, value string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func joinEscaped(values []string) string {
+	escaped := make([]string, 0, len(values))
+	for _, value := range values {
+		escaped = append(escaped, url.QueryEscape(value))
+	}
+
+	return strings.Join(escaped, ",")
+}
+
+func splitEscaped(value string) ([]string, error) {
+	values := []string{}
+	if value == "" {
+		return values, nil
+	}
+
+	for _, item := range strings.Split(value, ",") {
+		item, err := url.QueryUnescape(item)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, item)
+	}
+
+	return values, nil
+}
+
+func (s *Service) approvalPolicyRecords() [][]string {
+	var records [][]string
+	for _, policy := range s.approvalPolicies {
+		records = append(records, []string{
+			strconv.FormatInt(policy.AccountID, 10),
+			strconv.FormatInt(int64(policy.Threshold), 10),
+			strconv.Itoa(policy.Required),
+			joinEscaped(policy.Approvers),
+			policy.Expiry.String(),
+		})
+	}
+
+	return records
+}
+
+func parseApprovalPolicy(record []string) (*types.ApprovalPolicy, error) {
+	if len(record) < 5 {
+		return nil, ErrInvalidRecord
+	}
+
+	var err error
+	policy := &types.ApprovalPolicy{}
+	if policy.AccountID, err = strconv.ParseInt(record[0], 10, 64); err != nil {
+		return nil, err
+	}
+	threshold, err := strconv.ParseInt(record[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	policy.Threshold = types.Money(threshold)
+	if policy.Required, err = strconv.Atoi(record[2]); err != nil {
+		return nil, err
+	}
+	if policy.Approvers, err = splitEscaped(record[3]); err != nil {
+		return nil, err
+	}
+	if policy.Expiry, err = time.ParseDuration(record[4]); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+func (s *Service) approvalRecords() [][]string {
+	var records [][]string
+	for _, approval := range s.approvals {
+		records = append(records, []string{
+			approval.PaymentID,
+			strconv.FormatInt(approval.AccountID, 10),
+			strconv.FormatInt(int64(approval.Amount), 10),
+			strconv.Itoa(approval.Required),
+			joinEscaped(approval.Approvers),
+			formatTime(approval.Created),
+			formatTime(approval.Expires),
+		})
+	}
+
+	return records
+}
+
+func parseApproval(record []string) (*types.Approval, error) {
+	if len(record) < 7 {
+		return nil, ErrInvalidRecord
+	}
+
+	var err error
+	approval := &types.Approval{PaymentID: record[0]}
+	if approval.AccountID, err = strconv.ParseInt(record[1], 10, 64); err != nil {
+		return nil, err
+	}
+	amount, err := strconv.ParseInt(record[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	approval.Amount = types.Money(amount)
+	if approval.Required, err = strconv.Atoi(record[3]); err != nil {
+		return nil, err
+	}
+	if approval.Approvers, err = splitEscaped(record[4]); err != nil {
+		return nil, err
+	}
+	if approval.Created, err = parseTime(record[5]); err != nil {
+		return nil, err
+	}
+	if approval.Expires, err = parseTime(record[6]); err != nil {
+		return nil, err
+	}
+
+	return approval, nil
+}
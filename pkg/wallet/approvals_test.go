@@ -0,0 +1,299 @@
+package wallet
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+func newApprovalTestService(t *testing.T) (*testService, *testClock, *types.Account, map[string]*Authorized) {
+	fastKDF(t)
+	s, clock, account := newRiskTestService(t, 10_000)
+	err := s.SetApprovalPolicy(types.ApprovalPolicy{
+		AccountID: account.ID,
+		Threshold: 500,
+		Required:  2,
+		Approvers: []string{"alice", "bob", "carol"},
+		Expiry:    24 * time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.SetSessionTTL(7 * 24 * time.Hour)
+	users := map[string]*Authorized{}
+	for _, login := range []string{"alice", "bob", "carol", "mallory"} {
+		users[login] = s.authorize(t, login, account.ID, "")
+	}
+	users["owner"] = s.authorize(t, "owner", account.ID, types.RoleOwner)
+
+	return s, clock, account, users
+}
+
+func TestService_Approve(t *testing.T) {
+	s, _, account, users := newApprovalTestService(t)
+
+	small, err := s.Pay(account.ID, 500, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if small.Status != types.PaymentStatusInProgress {
+		t.Errorf("payments up to the threshold must not need approval, status: %v", small.Status)
+	}
+
+	payment, err := s.Pay(account.ID, 1_000, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if payment.Status != types.PaymentStatusPending || account.Balance != 8_500 {
+		t.Errorf("pending payment must hold the funds, status: %v, balance: %v", payment.Status, account.Balance)
+	}
+
+	pending, err := s.PendingApprovals(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(pending) != 1 || pending[0].PaymentID != payment.ID || pending[0].Required != 2 {
+		t.Errorf("invalid pending approvals: %+v", pending)
+	}
+
+	_, err = users["mallory"].Approve(payment.ID)
+	if !errors.Is(err, ErrNotApprover) {
+		t.Errorf("Approve(): must return ErrNotApprover, returned %v", err)
+	}
+	err = s.SetApprovalPolicy(types.ApprovalPolicy{AccountID: account.ID, Threshold: 500, Required: 2})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = users["owner"].Approve(payment.ID)
+	if !errors.Is(err, ErrNotApprover) {
+		t.Errorf("Approve(): must not let owners approve their payments, returned %v", err)
+	}
+	_, err = users["alice"].Approve(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = users["alice"].Approve(payment.ID)
+	if !errors.Is(err, ErrAlreadyApproved) {
+		t.Errorf("Approve(): must return ErrAlreadyApproved, returned %v", err)
+	}
+	if payment.Status != types.PaymentStatusPending {
+		t.Errorf("payment must wait for the second approver, status: %v", payment.Status)
+	}
+
+	approved, err := users["bob"].Approve(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if approved.Status != types.PaymentStatusInProgress {
+		t.Errorf("approved payment must go on, status: %v", approved.Status)
+	}
+	_, err = users["carol"].Approve(payment.ID)
+	if !errors.Is(err, ErrApprovalNotFound) {
+		t.Errorf("Approve(): must return ErrApprovalNotFound, returned %v", err)
+	}
+}
+
+func TestService_Decline(t *testing.T) {
+	s, _, account, users := newApprovalTestService(t)
+
+	payment, err := s.Pay(account.ID, 1_000, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = users["alice"].Approve(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = users["carol"].Decline(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if payment.Status != types.PaymentStatusFail || account.Balance != 10_000 {
+		t.Errorf("declined payment must release the funds, status: %v, balance: %v", payment.Status, account.Balance)
+	}
+}
+
+func TestService_ExpireApprovals(t *testing.T) {
+	s, clock, account, users := newApprovalTestService(t)
+
+	first, err := s.Pay(account.ID, 1_000, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	clock.advance(12 * time.Hour)
+	second, err := s.Pay(account.ID, 2_000, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	clock.advance(13 * time.Hour)
+	expired := s.ExpireApprovals()
+	if len(expired) != 1 || expired[0].PaymentID != first.ID {
+		t.Errorf("invalid expired approvals: %+v", expired)
+	}
+	if first.Status != types.PaymentStatusFail || account.Balance != 8_000 {
+		t.Errorf("expired payment must release the funds, status: %v, balance: %v", first.Status, account.Balance)
+	}
+
+	clock.advance(12 * time.Hour)
+	_, err = users["alice"].Approve(second.ID)
+	if !errors.Is(err, ErrApprovalExpired) {
+		t.Errorf("Approve(): must return ErrApprovalExpired, returned %v", err)
+	}
+	if second.Status != types.PaymentStatusPending || account.Balance != 8_000 {
+		t.Errorf("Approve() must leave expiry to ExpireApprovals, status: %v, balance: %v", second.Status, account.Balance)
+	}
+
+	expired = s.ExpireApprovals()
+	if len(expired) != 1 || second.Status != types.PaymentStatusFail || account.Balance != 10_000 {
+		t.Errorf("expired payment must release the funds, status: %v, balance: %v", second.Status, account.Balance)
+	}
+}
+
+func TestService_ExportImport_approvals(t *testing.T) {
+	s, clock, account, users := newApprovalTestService(t)
+
+	payment, err := s.Pay(account.ID, 1_000, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = users["alice"].Approve(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	imported := newTestService()
+	imported.SetClock(clock)
+	err = imported.Import(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	policy, err := imported.FindApprovalPolicy(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want, _ := s.FindApprovalPolicy(account.ID)
+	if !reflect.DeepEqual(want, policy) {
+		t.Errorf("invalid result, expected: %v, actual: %v", want, policy)
+	}
+
+	importedUsers := map[string]*Authorized{}
+	for _, login := range []string{"alice", "bob"} {
+		importedUsers[login], err = imported.Authorize(users[login].token)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	_, err = importedUsers["alice"].Approve(payment.ID)
+	if !errors.Is(err, ErrAlreadyApproved) {
+		t.Errorf("Approve(): must return ErrAlreadyApproved, returned %v", err)
+	}
+	approved, err := importedUsers["bob"].Approve(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if approved.Status != types.PaymentStatusInProgress {
+		t.Errorf("approved payment must go on, status: %v", approved.Status)
+	}
+}
+
+func TestService_SetApprovalPolicy_invalid(t *testing.T) {
+	s := newTestService()
+	account, err := s.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	policies := []types.ApprovalPolicy{
+		{AccountID: account.ID, Threshold: 100, Required: 0},
+		{AccountID: account.ID, Threshold: 100, Required: 3, Approvers: []string{"alice", "bob"}},
+		{AccountID: account.ID, Threshold: 100, Required: 1, Approvers: []string{""}},
+		{AccountID: account.ID, Threshold: -1, Required: 1},
+	}
+	for _, policy := range policies {
+		err = s.SetApprovalPolicy(policy)
+		if !errors.Is(err, ErrInvalidApprovalPolicy) {
+			t.Errorf("SetApprovalPolicy(%+v): must return ErrInvalidApprovalPolicy, returned %v", policy, err)
+		}
+	}
+
+	err = s.RemoveApprovalPolicy(account.ID)
+	if !errors.Is(err, ErrApprovalPolicyNotFound) {
+		t.Errorf("RemoveApprovalPolicy(): must return ErrApprovalPolicyNotFound, returned %v", err)
+	}
+}
+
+func TestService_Approve_hardBudget(t *testing.T) {
+	s, _, account, users := newApprovalTestService(t)
+
+	payment, err := s.Pay(account.ID, 1_000, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	budget, err := s.SetBudget(account.ID, "auto", types.BudgetMonthly, 1_200, types.BudgetHard)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if budget.Spent != 0 {
+		t.Errorf("pending payments must not count as spent, spent: %v", budget.Spent)
+	}
+	_, err = s.Pay(account.ID, 500, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = users["alice"].Approve(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = users["bob"].Approve(payment.ID)
+	if !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("Approve(): must return ErrBudgetExceeded, returned %v", err)
+	}
+	if payment.Status != types.PaymentStatusPending || budget.Spent != 500 {
+		t.Errorf("payment must keep waiting, status: %v, spent: %v", payment.Status, budget.Spent)
+	}
+
+	err = users["carol"].Decline(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if budget.Spent != 500 {
+		t.Errorf("declined payment must not stay spent, spent: %v", budget.Spent)
+	}
+}
@@ -0,0 +1,330 @@
+package wallet
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+var ErrAuditTampered = errors.New("audit log tampered")
+
+// AuditError is returned by VerifyAuditLog for the first record that doesn't
+// fit into the chain. It matches ErrAuditTampered with errors.Is.
+type AuditError struct {
+	Seq int64
+}
+
+func (e *AuditError) Error() string {
+	return fmt.Sprintf("audit log tampered at record %v", e.Seq)
+}
+
+func (e *AuditError) Is(target error) bool {
+	return target == ErrAuditTampered
+}
+
+// AuditQuery selects audit records. Zero fields match everything; AccountID
+// matches records of calls made for the account or changing its balance.
+type AuditQuery struct {
+	AccountID int64
+	Operation string
+	From      time.Time
+	To        time.Time
+}
+
+// auditCall collects what an audited call did until it returns. Calls made
+// from inside an audited call aren't recorded on their own.
+type auditCall struct {
+	s      *Service
+	record *types.AuditRecord
+	deltas map[int64]types.Money
+	nested bool
+}
+
+// SetActor sets who the following calls are recorded on behalf of.
+func (s *Service) SetActor(actor string) {
+	defer s.audit("SetActor", 0, actor).done(nil)
+
+	s.actor = actor
+}
+
+// audit starts recording a mutating call, it must be finished with done:
+//
+//	defer s.audit("Deposit", accountID, amount).done(&err)
+func (s *Service) audit(operation string, accountID int64, args ...interface{}) *auditCall {
+	if s.auditing != nil {
+		return &auditCall{s: s, nested: true}
+	}
+
+	arguments := make([]string, 0, len(args))
+	for _, arg := range args {
+		arguments = append(arguments, fmt.Sprintf("%+v", arg))
+	}
+
+	call := &auditCall{
+		s: s,
+		record: &types.AuditRecord{
+			Time:      s.now(),
+			Actor:     s.actor,
+			Operation: operation,
+			AccountID: accountID,
+			Arguments: arguments,
+		},
+		deltas: make(map[int64]types.Money),
+	}
+	s.auditing = call
+	return call
+}
+
+// setAccount sets the account of a call that only knows it once it's made.
+func (c *auditCall) setAccount(accountID int64) {
+	if !c.nested {
+		c.record.AccountID = accountID
+	}
+}
+
+// done appends the record to the audit log with the balances of the call's
+// account and of every account the call changed. err may be nil for calls
+// that can't fail.
+func (c *auditCall) done(err *error) {
+	if c.nested {
+		return
+	}
+
+	s := c.s
+	s.auditing = nil
+
+	record := c.record
+	if err != nil && *err != nil {
+		record.Error = (*err).Error()
+	}
+
+	if _, ok := c.deltas[record.AccountID]; !ok && record.AccountID != 0 {
+		c.deltas[record.AccountID] = 0
+	}
+	for accountID, delta := range c.deltas {
+		account, err := s.FindAccountByID(accountID)
+		if err != nil {
+			continue
+		}
+		record.Balances = append(record.Balances, types.AuditBalance{
+			AccountID: accountID,
+			Before:    account.Balance - delta,
+			After:     account.Balance,
+		})
+	}
+	sort.Slice(record.Balances, func(i, j int) bool {
+		return record.Balances[i].AccountID < record.Balances[j].AccountID
+	})
+
+	s.appendAudit(record)
+}
+
+// appendAudit chains record to the end of the audit log.
+func (s *Service) appendAudit(record *types.AuditRecord) {
+	if len(s.auditLog) != 0 {
+		last := s.auditLog[len(s.auditLog)-1]
+		record.Seq = last.Seq + 1
+		record.PrevHash = last.Hash
+	} else {
+		record.Seq = 1
+		record.PrevHash = ""
+	}
+	record.Hash = auditHash(record)
+	s.auditLog = append(s.auditLog, record)
+}
+
+// importAuditLog replaces the audit log with the imported one. Records of
+// this service that the import doesn't have, such as calls made since the
+// dump was exported, are chained after the imported records instead of
+// being dropped.
+func (s *Service) importAuditLog(imported []*types.AuditRecord) {
+	known := make(map[string]bool, len(imported))
+	for _, record := range imported {
+		known[record.Hash] = true
+	}
+
+	local := s.auditLog
+	s.auditLog = imported
+	for _, record := range local {
+		if !known[record.Hash] {
+			s.appendAudit(record)
+		}
+	}
+}
+
+// track notes a balance change made by the call being audited.
+func (s *Service) track(accountID int64, amount types.Money) {
+	if s.auditing != nil {
+		s.auditing.deltas[accountID] += amount
+	}
+}
+
+// AuditLog returns the audit records matching the query in order.
+func (s *Service) AuditLog(query AuditQuery) []types.AuditRecord {
+	records := []types.AuditRecord{}
+	for _, record := range s.auditLog {
+		if query.Operation != "" && record.Operation != query.Operation {
+			continue
+		}
+		if !query.From.IsZero() && record.Time.Before(query.From) {
+			continue
+		}
+		if !query.To.IsZero() && !record.Time.Before(query.To) {
+			continue
+		}
+		if query.AccountID != 0 && !auditTouches(record, query.AccountID) {
+			continue
+		}
+		records = append(records, *record)
+	}
+
+	return records
+}
+
+func auditTouches(record *types.AuditRecord, accountID int64) bool {
+	if record.AccountID == accountID {
+		return true
+	}
+	for _, balance := range record.Balances {
+		if balance.AccountID == accountID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// VerifyAuditLog checks that records form an unbroken chain starting from
+// the first record ever written.
+func VerifyAuditLog(records []types.AuditRecord) error {
+	prev := ""
+	for i := range records {
+		record := &records[i]
+		if record.Seq != int64(i+1) || record.PrevHash != prev || record.Hash != auditHash(record) {
+			return &AuditError{Seq: int64(i + 1)}
+		}
+		prev = record.Hash
+	}
+
+	return nil
+}
+
+func auditHash(record *types.AuditRecord) string {
+	fields := auditFields(record)
+	hash := sha256.Sum256([]byte(strings.Join(fields[:len(fields)-1], ";")))
+	return hex.EncodeToString(hash[:])
+}
+
+// auditFields is the dump record of an audit record. Free text is escaped,
+// so it can't break the dump format.
+func auditFields(record *types.AuditRecord) []string {
+	arguments := make([]string, 0, len(record.Arguments))
+	for _, argument := range record.Arguments {
+		arguments = append(arguments, url.QueryEscape(argument))
+	}
+
+	balances := make([]string, 0, len(record.Balances))
+	for _, balance := range record.Balances {
+		balances = append(balances, strconv.FormatInt(balance.AccountID, 10)+":"+
+			strconv.FormatInt(int64(balance.Before), 10)+":"+
+			strconv.FormatInt(int64(balance.After), 10))
+	}
+
+	return []string{
+		strconv.FormatInt(record.Seq, 10),
+		formatTime(record.Time),
+		url.QueryEscape(record.Actor),
+		url.QueryEscape(record.Operation),
+		strconv.FormatInt(record.AccountID, 10),
+		strings.Join(arguments, ","),
+		strings.Join(balances, ","),
+		url.QueryEscape(record.Error),
+		record.PrevHash,
+		record.Hash,
+	}
+}
+
+func (s *Service) auditRecords() [][]string {
+	var records [][]string
+	for _, record := range s.auditLog {
+		records = append(records, auditFields(record))
+	}
+
+	return records
+}
+
+func parseAuditRecord(fields []string) (*types.AuditRecord, error) {
+	if len(fields) < 10 {
+		return nil, ErrInvalidRecord
+	}
+
+	var err error
+	record := &types.AuditRecord{
+		PrevHash: fields[8],
+		Hash:     fields[9],
+	}
+	if record.Seq, err = strconv.ParseInt(fields[0], 10, 64); err != nil {
+		return nil, err
+	}
+	if record.Time, err = parseTime(fields[1]); err != nil {
+		return nil, err
+	}
+	if record.Actor, err = url.QueryUnescape(fields[2]); err != nil {
+		return nil, err
+	}
+	if record.Operation, err = url.QueryUnescape(fields[3]); err != nil {
+		return nil, err
+	}
+	if record.AccountID, err = strconv.ParseInt(fields[4], 10, 64); err != nil {
+		return nil, err
+	}
+	if record.Error, err = url.QueryUnescape(fields[7]); err != nil {
+		return nil, err
+	}
+
+	record.Arguments = []string{}
+	if fields[5] != "" {
+		for _, argument := range strings.Split(fields[5], ",") {
+			value, err := url.QueryUnescape(argument)
+			if err != nil {
+				return nil, err
+			}
+			record.Arguments = append(record.Arguments, value)
+		}
+	}
+
+	if fields[6] != "" {
+		for _, value := range strings.Split(fields[6], ",") {
+			parts := strings.Split(value, ":")
+			if len(parts) != 3 {
+				return nil, ErrInvalidRecord
+			}
+
+			var balance types.AuditBalance
+			if balance.AccountID, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+				return nil, err
+			}
+			before, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			after, err := strconv.ParseInt(parts[2], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			balance.Before = types.Money(before)
+			balance.After = types.Money(after)
+			record.Balances = append(record.Balances, balance)
+		}
+	}
+
+	return record, nil
+}
@@ -0,0 +1,217 @@
+package wallet
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+func TestService_AuditLog(t *testing.T) {
+	s := newTestService()
+	s.SetClock(&testClock{now: time.Date(2021, 1, 1, 9, 0, 0, 0, time.UTC)})
+	s.SetActor("operator")
+
+	account, err := s.addAccountWithBalance("+992000000001", 100)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := s.Pay(account.ID, 40, "food")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Repeat(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(account.ID, 1_000, "food")
+	if !errors.Is(err, ErrNotEnoughBalance) {
+		t.Errorf("Pay(): must return ErrNotEnoughBalance, returned %v", err)
+	}
+
+	var operations []string
+	for _, record := range s.AuditLog(AuditQuery{AccountID: account.ID}) {
+		operations = append(operations, record.Operation)
+	}
+	want := []string{"RegisterAccount", "Deposit", "Pay", "Repeat", "Reject", "Pay"}
+	if !reflect.DeepEqual(want, operations) {
+		t.Errorf("invalid operations, expected: %v, actual: %v", want, operations)
+	}
+
+	rejects := s.AuditLog(AuditQuery{Operation: "Reject"})
+	if len(rejects) != 1 {
+		t.Errorf("invalid number of records, expected: %v, actual: %v", 1, len(rejects))
+		return
+	}
+	wantBalances := []types.AuditBalance{{AccountID: account.ID, Before: 20, After: 60}}
+	if !reflect.DeepEqual(wantBalances, rejects[0].Balances) {
+		t.Errorf("invalid balances, expected: %v, actual: %v", wantBalances, rejects[0].Balances)
+	}
+	if rejects[0].Actor != "operator" || !reflect.DeepEqual([]string{payment.ID}, rejects[0].Arguments) {
+		t.Errorf("invalid record: %v", rejects[0])
+	}
+
+	records := s.AuditLog(AuditQuery{})
+	failed := records[len(records)-1]
+	if !strings.Contains(failed.Error, ErrNotEnoughBalance.Error()) {
+		t.Errorf("failed call must be recorded with its error, record: %v", failed)
+	}
+
+	err = VerifyAuditLog(records)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyAuditLog_tampered(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 0; i < 3; i++ {
+		_, err = s.Pay(account.ID, 10, "food")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	records := s.AuditLog(AuditQuery{})
+	records[2].Arguments = []string{"1", "1", "food"}
+	err = VerifyAuditLog(records)
+	if !errors.Is(err, ErrAuditTampered) {
+		t.Errorf("VerifyAuditLog(): must return ErrAuditTampered, returned %v", err)
+	}
+	var auditErr *AuditError
+	if !errors.As(err, &auditErr) || auditErr.Seq != 3 {
+		t.Errorf("VerifyAuditLog(): must report record 3, returned %v", err)
+	}
+
+	records = s.AuditLog(AuditQuery{})
+	records = append(records[:1], records[2:]...)
+	if !errors.Is(VerifyAuditLog(records), ErrAuditTampered) {
+		t.Errorf("VerifyAuditLog(): must detect a removed record")
+	}
+}
+
+func TestService_ExportImport_audit(t *testing.T) {
+	s := newTestService()
+	s.SetActor("name;with\nseparators")
+	_, err := s.addAccountWithBalance("+992000000001", 100)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	records := imported.AuditLog(AuditQuery{})
+	if len(records) != 4 || records[1].Actor != "name;with\nseparators" || records[3].Operation != "Import" {
+		t.Errorf("invalid result: %v", records)
+	}
+	err = VerifyAuditLog(records)
+	if err != nil {
+		t.Error(err)
+	}
+
+	path := filepath.Join(dir, "audit.dump")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = ioutil.WriteFile(path, []byte(strings.Replace(string(data), "1:0:100;", "1:0:1000;", 1)), 0666)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = newTestService().Import(dir)
+	if !errors.Is(err, ErrAuditTampered) {
+		t.Errorf("Import(): must return ErrAuditTampered, returned %v", err)
+	}
+}
+
+func TestService_AuditLog_settings(t *testing.T) {
+	s := newTestService()
+	s.SetClock(&testClock{now: time.Date(2021, 1, 1, 9, 0, 0, 0, time.UTC)})
+	s.SetActor("operator")
+	s.SetSessionTTL(time.Hour)
+	s.SetBudgetAlertHandler(func(alert types.BudgetAlert) {})
+	s.ExpireSessions()
+
+	var operations []string
+	for _, record := range s.AuditLog(AuditQuery{}) {
+		operations = append(operations, record.Operation)
+	}
+	want := []string{"SetClock", "SetActor", "SetSessionTTL", "SetBudgetAlertHandler", "ExpireSessions"}
+	if !reflect.DeepEqual(want, operations) {
+		t.Errorf("invalid operations, expected: %v, actual: %v", want, operations)
+	}
+}
+
+func TestService_Import_keepsAudit(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(account.ID, 10, "food")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Import(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var operations []string
+	for _, record := range s.AuditLog(AuditQuery{}) {
+		operations = append(operations, record.Operation)
+	}
+	want := []string{"RegisterAccount", "Deposit", "Pay", "Import"}
+	if !reflect.DeepEqual(want, operations) {
+		t.Errorf("invalid operations, expected: %v, actual: %v", want, operations)
+	}
+	err = VerifyAuditLog(s.AuditLog(AuditQuery{}))
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,305 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrBudgetNotFound = errors.New("budget not found")
+var ErrInvalidBudget = errors.New("invalid budget")
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// BudgetExceededError is returned by Pay when a payment doesn't fit into a
+// hard budget. It matches ErrBudgetExceeded with errors.Is.
+type BudgetExceededError struct {
+	BudgetID string
+	Category types.PaymentCategory
+	Limit    types.Money
+	Spent    types.Money
+	Amount   types.Money
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("budget exceeded: %v spent %v of %v, payment %v", e.Category, e.Spent, e.Limit, e.Amount)
+}
+
+func (e *BudgetExceededError) Is(target error) bool {
+	return target == ErrBudgetExceeded
+}
+
+var budgetThresholds = []int{80, 100}
+
+// SetBudgetAlertHandler sets the function called every time a budget
+// crosses 80% or 100% of its limit within a period.
+func (s *Service) SetBudgetAlertHandler(handler func(alert types.BudgetAlert)) {
+	defer s.audit("SetBudgetAlertHandler", 0, handler != nil).done(nil)
+
+	s.budgetAlertHandler = handler
+}
+
+// SetBudget creates a budget for the account and category (including its
+// subcategories) or updates the existing one for the same period. Payments
+// already made in the current period are counted as spent.
+func (s *Service) SetBudget(accountID int64, category types.PaymentCategory, period types.BudgetPeriod, limit types.Money, mode types.BudgetMode) (_ *types.Budget, err error) {
+	defer s.audit("SetBudget", accountID, accountID, category, period, limit, mode).done(&err)
+
+	_, err = s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		return nil, newError("SetBudget", ErrAmountMustBePositive, accountID, "", limit)
+	}
+	if period != types.BudgetWeekly && period != types.BudgetMonthly {
+		return nil, newError("SetBudget", ErrInvalidBudget, accountID, "", limit)
+	}
+	if mode != types.BudgetSoft && mode != types.BudgetHard {
+		return nil, newError("SetBudget", ErrInvalidBudget, accountID, "", limit)
+	}
+
+	if resolved, err := s.ResolveCategory(category); err == nil {
+		category = resolved
+	}
+
+	var budget *types.Budget
+	for _, b := range s.budgets {
+		if b.AccountID == accountID && b.Category == category && b.Period == period {
+			budget = b
+			break
+		}
+	}
+	if budget == nil {
+		budget = &types.Budget{
+			ID:        uuid.New().String(),
+			AccountID: accountID,
+			Category:  category,
+			Period:    period,
+		}
+		s.budgets = append(s.budgets, budget)
+	}
+
+	budget.Mode = mode
+	budget.Limit = limit
+	budget.PeriodStart = periodStart(period, s.now())
+	budget.Spent = 0
+	budget.Alerted = 0
+	for _, payment := range s.payments {
+		if s.budgetCovers(budget, payment) && started(payment) && payment.Status != types.PaymentStatusFail {
+			budget.Spent += payment.Amount
+		}
+	}
+
+	return budget, nil
+}
+
+func (s *Service) FindBudgetByID(budgetID string) (*types.Budget, error) {
+	for _, budget := range s.budgets {
+		if budget.ID == budgetID {
+			s.rollBudget(budget)
+			return budget, nil
+		}
+	}
+
+	return nil, newError("FindBudgetByID", ErrBudgetNotFound, 0, budgetID, 0)
+}
+
+func (s *Service) AccountBudgets(accountID int64) ([]types.Budget, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	budgets := []types.Budget{}
+	for _, budget := range s.budgets {
+		if budget.AccountID == accountID {
+			s.rollBudget(budget)
+			budgets = append(budgets, *budget)
+		}
+	}
+
+	return budgets, nil
+}
+
+func (s *Service) DeleteBudget(budgetID string) (err error) {
+	defer s.audit("DeleteBudget", 0, budgetID).done(&err)
+
+	for i, budget := range s.budgets {
+		if budget.ID == budgetID {
+			s.budgets = append(s.budgets[:i], s.budgets[i+1:]...)
+			return nil
+		}
+	}
+
+	return newError("DeleteBudget", ErrBudgetNotFound, 0, budgetID, 0)
+}
+
+func (s *Service) BudgetAlerts(accountID int64) []types.BudgetAlert {
+	alerts := []types.BudgetAlert{}
+	for _, alert := range s.budgetAlerts {
+		if alert.AccountID == accountID {
+			alerts = append(alerts, *alert)
+		}
+	}
+
+	return alerts
+}
+
+func periodStart(period types.BudgetPeriod, t time.Time) time.Time {
+	if period == types.BudgetWeekly {
+		days := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-days, 0, 0, 0, 0, t.Location())
+	}
+
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+func periodEnd(period types.BudgetPeriod, start time.Time) time.Time {
+	if period == types.BudgetWeekly {
+		return start.AddDate(0, 0, 7)
+	}
+
+	return start.AddDate(0, 1, 0)
+}
+
+// rollBudget starts a new period for the budget once the current one is over.
+func (s *Service) rollBudget(budget *types.Budget) {
+	now := s.now()
+	if now.Before(periodEnd(budget.Period, budget.PeriodStart)) {
+		return
+	}
+
+	budget.PeriodStart = periodStart(budget.Period, now)
+	budget.Spent = 0
+	budget.Alerted = 0
+}
+
+func (s *Service) budgetCovers(budget *types.Budget, payment *types.Payment) bool {
+	if budget.AccountID != payment.AccountID || !s.CategoryWithin(payment.Category, budget.Category) {
+		return false
+	}
+
+	return !payment.Created.Before(budget.PeriodStart) && payment.Created.Before(periodEnd(budget.Period, budget.PeriodStart))
+}
+
+func (s *Service) checkBudgets(accountID int64, amount types.Money, category types.PaymentCategory) error {
+	for _, budget := range s.budgets {
+		if budget.AccountID != accountID || budget.Mode != types.BudgetHard || !s.CategoryWithin(category, budget.Category) {
+			continue
+		}
+
+		s.rollBudget(budget)
+		if budget.Spent+amount > budget.Limit {
+			return &BudgetExceededError{
+				BudgetID: budget.ID,
+				Category: budget.Category,
+				Limit:    budget.Limit,
+				Spent:    budget.Spent,
+				Amount:   amount,
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Service) trackBudgets(payment *types.Payment) {
+	for _, budget := range s.budgets {
+		s.rollBudget(budget)
+		if !s.budgetCovers(budget, payment) {
+			continue
+		}
+
+		budget.Spent += payment.Amount
+		for _, threshold := range budgetThresholds {
+			if budget.Alerted >= threshold || int64(budget.Spent)*100 < int64(budget.Limit)*int64(threshold) {
+				continue
+			}
+
+			budget.Alerted = threshold
+			alert := &types.BudgetAlert{
+				BudgetID:  budget.ID,
+				AccountID: budget.AccountID,
+				Category:  budget.Category,
+				Threshold: threshold,
+				Spent:     budget.Spent,
+				Limit:     budget.Limit,
+				Time:      s.now(),
+			}
+			s.budgetAlerts = append(s.budgetAlerts, alert)
+			if s.budgetAlertHandler != nil {
+				s.budgetAlertHandler(*alert)
+			}
+		}
+	}
+}
+
+// untrackBudgets returns the amount of a rejected payment to the budgets
+// whose current period it was made in.
+func (s *Service) untrackBudgets(payment *types.Payment) {
+	for _, budget := range s.budgets {
+		s.rollBudget(budget)
+		if s.budgetCovers(budget, payment) {
+			budget.Spent -= payment.Amount
+		}
+	}
+}
+
+func (s *Service) budgetRecords() [][]string {
+	var records [][]string
+	for _, budget := range s.budgets {
+		records = append(records, []string{
+			budget.ID,
+			strconv.FormatInt(budget.AccountID, 10),
+			string(budget.Category),
+			string(budget.Period),
+			string(budget.Mode),
+			strconv.FormatInt(int64(budget.Limit), 10),
+			strconv.FormatInt(int64(budget.Spent), 10),
+			formatTime(budget.PeriodStart),
+			strconv.Itoa(budget.Alerted),
+		})
+	}
+
+	return records
+}
+
+func parseBudget(record []string) (*types.Budget, error) {
+	if len(record) < 9 {
+		return nil, ErrInvalidRecord
+	}
+
+	var err error
+	budget := &types.Budget{
+		ID:       record[0],
+		Category: types.PaymentCategory(record[2]),
+		Period:   types.BudgetPeriod(record[3]),
+		Mode:     types.BudgetMode(record[4]),
+	}
+	if budget.AccountID, err = strconv.ParseInt(record[1], 10, 64); err != nil {
+		return nil, err
+	}
+	limit, err := strconv.ParseInt(record[5], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	budget.Limit = types.Money(limit)
+	spent, err := strconv.ParseInt(record[6], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	budget.Spent = types.Money(spent)
+	if budget.PeriodStart, err = parseTime(record[7]); err != nil {
+		return nil, err
+	}
+	if budget.Alerted, err = strconv.Atoi(record[8]); err != nil {
+		return nil, err
+	}
+
+	return budget, nil
+}
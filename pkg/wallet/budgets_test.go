@@ -0,0 +1,177 @@
+package wallet
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+func TestService_SetBudget_softAlerts(t *testing.T) {
+	s := newTestService()
+	clock := &testClock{now: time.Date(2021, 5, 10, 12, 0, 0, 0, time.UTC)}
+	s.SetClock(clock)
+
+	account, err := s.addAccountWithBalance("+992000000001", 10_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var thresholds []int
+	s.SetBudgetAlertHandler(func(alert types.BudgetAlert) {
+		thresholds = append(thresholds, alert.Threshold)
+	})
+
+	budget, err := s.SetBudget(account.ID, "food", types.BudgetMonthly, 1_000_00, types.BudgetSoft)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	amounts := []types.Money{500_00, 300_00, 100_00, 200_00}
+	for _, amount := range amounts {
+		_, err = s.Pay(account.ID, amount, "food")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	_, err = s.Pay(account.ID, 1_000_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if budget.Spent != 1_100_00 {
+		t.Errorf("invalid spent, expected: %v, actual: %v", types.Money(1_100_00), budget.Spent)
+	}
+
+	want := []int{80, 100}
+	if !reflect.DeepEqual(want, thresholds) {
+		t.Errorf("invalid alerts, expected: %v, actual: %v", want, thresholds)
+	}
+	if len(s.BudgetAlerts(account.ID)) != 2 {
+		t.Errorf("invalid alerts: %v", s.BudgetAlerts(account.ID))
+	}
+
+	clock.now = time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	budgets, err := s.AccountBudgets(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(budgets) != 1 || budgets[0].Spent != 0 || budgets[0].Alerted != 0 {
+		t.Errorf("budget must start a new period, actual: %v", budgets)
+	}
+}
+
+func TestService_SetBudget_hard(t *testing.T) {
+	s := newTestService()
+	s.SetClock(&testClock{now: time.Date(2021, 5, 10, 12, 0, 0, 0, time.UTC)})
+
+	account, err := s.addAccountWithBalance("+992000000001", 10_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.addCategories()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	payment, err := s.Pay(account.ID, 600_00, "transport/fuel")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	budget, err := s.SetBudget(account.ID, "transport", types.BudgetWeekly, 1_000_00, types.BudgetHard)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if budget.Spent != 600_00 {
+		t.Errorf("payments of the current period must be counted, spent: %v", budget.Spent)
+	}
+
+	_, err = s.Pay(account.ID, 500_00, "transport/taxi")
+	if !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("Pay(): must return ErrBudgetExceeded, returned %v", err)
+		return
+	}
+
+	var exceeded *BudgetExceededError
+	if !errors.As(err, &exceeded) || exceeded.Spent != 600_00 || exceeded.Amount != 500_00 {
+		t.Errorf("invalid error: %v", err)
+	}
+
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.Pay(account.ID, 500_00, "transport/taxi")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExportImport_budgets(t *testing.T) {
+	s := newTestService()
+	s.SetClock(&testClock{now: time.Date(2021, 5, 10, 12, 0, 0, 0, time.UTC)})
+
+	account, err := s.addAccountWithBalance("+992000000001", 10_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.SetBudget(account.ID, "food", types.BudgetMonthly, 1_000_00, types.BudgetHard)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(account.ID, 100_00, "food")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(imported.budgets) != 1 {
+		t.Errorf("invalid budgets: %v", imported.budgets)
+		return
+	}
+
+	got, want := *imported.budgets[0], *s.budgets[0]
+	if !got.PeriodStart.Equal(want.PeriodStart) {
+		t.Errorf("invalid period, expected: %v, actual: %v", want.PeriodStart, got.PeriodStart)
+	}
+	got.PeriodStart, want.PeriodStart = time.Time{}, time.Time{}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("invalid budget, expected: %v, actual: %v", want, got)
+	}
+
+	if !imported.payments[0].Created.Equal(s.payments[0].Created) {
+		t.Errorf("invalid payment time, expected: %v, actual: %v", s.payments[0].Created, imported.payments[0].Created)
+	}
+}
@@ -0,0 +1,317 @@
+package wallet
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+var ErrUnknownCategory = errors.New("unknown category")
+var ErrCategoryExists = errors.New("category already registered")
+var ErrInvalidCategory = errors.New("invalid category")
+
+// Category is an entry of the category registry. IDs are canonical lower
+// case paths; a category "transport/fuel" is a child of "transport".
+type Category struct {
+	ID     types.PaymentCategory
+	Name   string
+	Parent types.PaymentCategory
+}
+
+func normalizeCategory(raw types.PaymentCategory) types.PaymentCategory {
+	parts := strings.Split(strings.ToLower(string(raw)), "/")
+	for i, part := range parts {
+		parts[i] = strings.TrimSpace(part)
+	}
+
+	return types.PaymentCategory(strings.Join(parts, "/"))
+}
+
+func validCategory(id types.PaymentCategory) bool {
+	if id == "" || strings.ContainsAny(string(id), ";\r\n") {
+		return false
+	}
+
+	for _, part := range strings.Split(string(id), "/") {
+		if part == "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RegisterCategory adds a category to the registry. The parent of a nested
+// category must be registered first.
+func (s *Service) RegisterCategory(id types.PaymentCategory, name string) (_ *Category, err error) {
+	defer s.audit("RegisterCategory", 0, id, name).done(&err)
+
+	id = normalizeCategory(id)
+	if !validCategory(id) {
+		return nil, newError("RegisterCategory", ErrInvalidCategory, 0, string(id), 0)
+	}
+
+	if _, ok := s.categories[id]; ok {
+		return nil, newError("RegisterCategory", ErrCategoryExists, 0, string(id), 0)
+	}
+	if _, ok := s.categoryAliases[id]; ok {
+		return nil, newError("RegisterCategory", ErrCategoryExists, 0, string(id), 0)
+	}
+
+	var parent types.PaymentCategory
+	if i := strings.LastIndex(string(id), "/"); i >= 0 {
+		parent = id[:i]
+		if _, ok := s.categories[parent]; !ok {
+			return nil, newError("RegisterCategory", ErrUnknownCategory, 0, string(parent), 0)
+		}
+	}
+
+	if name == "" {
+		name = string(id)
+	}
+
+	category := &Category{ID: id, Name: name, Parent: parent}
+	if s.categories == nil {
+		s.categories = make(map[types.PaymentCategory]*Category)
+	}
+	s.categories[id] = category
+	return category, nil
+}
+
+// AddCategoryAlias makes alias resolve to the registered category id, e.g.
+// to map legacy values like "cars" found in old dumps onto "auto".
+func (s *Service) AddCategoryAlias(alias types.PaymentCategory, id types.PaymentCategory) (err error) {
+	defer s.audit("AddCategoryAlias", 0, alias, id).done(&err)
+
+	alias = normalizeCategory(alias)
+	if !validCategory(alias) {
+		return newError("AddCategoryAlias", ErrInvalidCategory, 0, string(alias), 0)
+	}
+
+	id = normalizeCategory(id)
+	if _, ok := s.categories[id]; !ok {
+		return newError("AddCategoryAlias", ErrUnknownCategory, 0, string(id), 0)
+	}
+	if _, ok := s.categories[alias]; ok {
+		return newError("AddCategoryAlias", ErrCategoryExists, 0, string(alias), 0)
+	}
+
+	if s.categoryAliases == nil {
+		s.categoryAliases = make(map[types.PaymentCategory]types.PaymentCategory)
+	}
+	s.categoryAliases[alias] = id
+	return nil
+}
+
+// SetStrictCategories makes Pay reject categories that aren't registered.
+func (s *Service) SetStrictCategories(strict bool) {
+	defer s.audit("SetStrictCategories", 0, strict).done(nil)
+
+	s.strictCategories = strict
+}
+
+func (s *Service) ResolveCategory(raw types.PaymentCategory) (types.PaymentCategory, error) {
+	id := normalizeCategory(raw)
+	if _, ok := s.categories[id]; ok {
+		return id, nil
+	}
+	if target, ok := s.categoryAliases[id]; ok {
+		return target, nil
+	}
+
+	return "", newError("ResolveCategory", ErrUnknownCategory, 0, string(raw), 0)
+}
+
+func (s *Service) FindCategory(raw types.PaymentCategory) (*Category, error) {
+	id, err := s.ResolveCategory(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.categories[id], nil
+}
+
+func (s *Service) Categories() []Category {
+	categories := make([]Category, 0, len(s.categories))
+	for _, category := range s.categories {
+		categories = append(categories, *category)
+	}
+
+	sort.Slice(categories, func(i, j int) bool {
+		return categories[i].ID < categories[j].ID
+	})
+	return categories
+}
+
+func (s *Service) SubCategories(raw types.PaymentCategory) ([]Category, error) {
+	id, err := s.ResolveCategory(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	children := []Category{}
+	for _, category := range s.Categories() {
+		if category.Parent == id {
+			children = append(children, category)
+		}
+	}
+
+	return children, nil
+}
+
+// CategoryWithin reports whether category is ancestor itself or one of its
+// descendants.
+func (s *Service) CategoryWithin(category types.PaymentCategory, ancestor types.PaymentCategory) bool {
+	if resolved, err := s.ResolveCategory(category); err == nil {
+		category = resolved
+	}
+	if resolved, err := s.ResolveCategory(ancestor); err == nil {
+		ancestor = resolved
+	}
+
+	return category == ancestor || strings.HasPrefix(string(category), string(ancestor)+"/")
+}
+
+// paymentCategory returns the canonical form of category used by payments
+// and favorites. Categories missing from the registry pass through
+// unchanged unless strict mode is on or they would break the dumps, then
+// the error is reported for op and the account.
+func (s *Service) paymentCategory(op string, accountID int64, category types.PaymentCategory) (types.PaymentCategory, error) {
+	id, err := s.ResolveCategory(category)
+	if err == nil {
+		return id, nil
+	}
+
+	if strings.ContainsAny(string(category), ";\r\n") {
+		return "", newError(op, ErrInvalidCategory, accountID, string(category), 0)
+	}
+	if s.strictCategories {
+		return "", newError(op, ErrUnknownCategory, accountID, string(category), 0)
+	}
+
+	return category, nil
+}
+
+// NormalizeCategories rewrites categories of stored payments and favorites
+// (e.g. loaded from old dumps) to their canonical IDs and returns how many
+// records were changed.
+func (s *Service) NormalizeCategories() int {
+	defer s.audit("NormalizeCategories", 0).done(nil)
+
+	changed := 0
+
+	for _, payment := range s.payments {
+		id, err := s.ResolveCategory(payment.Category)
+		if err == nil && id != payment.Category {
+			payment.Category = id
+			changed++
+		}
+	}
+
+	for _, favorite := range s.favorites {
+		id, err := s.ResolveCategory(favorite.Category)
+		if err == nil && id != favorite.Category {
+			favorite.Category = id
+			changed++
+		}
+	}
+
+	return changed
+}
+
+// Records of categories.dump are "CATEGORY;id;name", "ALIAS;alias;id" and
+// a single "STRICT;bool" with the strict mode.
+const (
+	categoryRecordCategory = "CATEGORY"
+	categoryRecordAlias    = "ALIAS"
+	categoryRecordStrict   = "STRICT"
+)
+
+// categoryRegistry is the category registry read from a dump.
+type categoryRegistry struct {
+	categories []*Category
+	aliases    map[types.PaymentCategory]types.PaymentCategory
+	strict     bool
+}
+
+func (s *Service) categoryRecords() [][]string {
+	var records [][]string
+	for _, category := range s.Categories() {
+		records = append(records, []string{categoryRecordCategory, string(category.ID), url.QueryEscape(category.Name)})
+	}
+
+	aliases := make([]string, 0, len(s.categoryAliases))
+	for alias := range s.categoryAliases {
+		aliases = append(aliases, string(alias))
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		records = append(records, []string{categoryRecordAlias, alias, string(s.categoryAliases[types.PaymentCategory(alias)])})
+	}
+
+	if len(records) != 0 || s.strictCategories {
+		records = append(records, []string{categoryRecordStrict, strconv.FormatBool(s.strictCategories)})
+	}
+
+	return records
+}
+
+func (r *categoryRegistry) parse(record []string) error {
+	switch {
+	case len(record) >= 3 && record[0] == categoryRecordCategory:
+		id := types.PaymentCategory(record[1])
+		if !validCategory(id) {
+			return ErrInvalidCategory
+		}
+		name, err := url.QueryUnescape(record[2])
+		if err != nil {
+			return err
+		}
+
+		var parent types.PaymentCategory
+		if i := strings.LastIndex(string(id), "/"); i >= 0 {
+			parent = id[:i]
+		}
+		r.categories = append(r.categories, &Category{ID: id, Name: name, Parent: parent})
+	case len(record) >= 3 && record[0] == categoryRecordAlias:
+		if r.aliases == nil {
+			r.aliases = make(map[types.PaymentCategory]types.PaymentCategory)
+		}
+		r.aliases[types.PaymentCategory(record[1])] = types.PaymentCategory(record[2])
+	case len(record) >= 2 && record[0] == categoryRecordStrict:
+		strict, err := strconv.ParseBool(record[1])
+		if err != nil {
+			return err
+		}
+		r.strict = strict
+	default:
+		return ErrInvalidRecord
+	}
+
+	return nil
+}
+
+// importCategories adds the categories and aliases of registry to the
+// registry of the service, replacing ones with the same IDs, and takes its
+// strict mode.
+func (s *Service) importCategories(registry *categoryRegistry) {
+	if s.categories == nil {
+		s.categories = make(map[types.PaymentCategory]*Category)
+	}
+	for _, category := range registry.categories {
+		s.categories[category.ID] = category
+	}
+
+	if s.categoryAliases == nil {
+		s.categoryAliases = make(map[types.PaymentCategory]types.PaymentCategory)
+	}
+	for alias, id := range registry.aliases {
+		s.categoryAliases[alias] = id
+	}
+
+	s.strictCategories = registry.strict
+}
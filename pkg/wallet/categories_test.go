@@ -0,0 +1,220 @@
+package wallet
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+func (s *testService) addCategories() error {
+	categories := []struct {
+		id   types.PaymentCategory
+		name string
+	}{
+		{id: "auto", name: "Auto"},
+		{id: "food", name: "Food"},
+		{id: "transport", name: "Transport"},
+		{id: "transport/fuel", name: "Fuel"},
+		{id: "transport/taxi", name: "Taxi"},
+	}
+
+	for _, category := range categories {
+		_, err := s.RegisterCategory(category.id, category.name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.AddCategoryAlias("cars", "auto")
+}
+
+func TestService_RegisterCategory(t *testing.T) {
+	s := newTestService()
+	err := s.addCategories()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.RegisterCategory("Auto", "")
+	if !errors.Is(err, ErrCategoryExists) {
+		t.Errorf("RegisterCategory(): must return ErrCategoryExists, returned %v", err)
+	}
+
+	_, err = s.RegisterCategory("home/rent", "")
+	if !errors.Is(err, ErrUnknownCategory) {
+		t.Errorf("RegisterCategory(): must return ErrUnknownCategory, returned %v", err)
+	}
+
+	for _, id := range []types.PaymentCategory{"transport//bus", "transport/bus\nstop", "transport/bus\rstop"} {
+		_, err = s.RegisterCategory(id, "")
+		if !errors.Is(err, ErrInvalidCategory) {
+			t.Errorf("RegisterCategory(%q): must return ErrInvalidCategory, returned %v", id, err)
+		}
+	}
+	err = s.AddCategoryAlias("ca\nrs", "auto")
+	if !errors.Is(err, ErrInvalidCategory) {
+		t.Errorf("AddCategoryAlias(): must return ErrInvalidCategory, returned %v", err)
+	}
+
+	children, err := s.SubCategories("Transport")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	want := []Category{
+		{ID: "transport/fuel", Name: "Fuel", Parent: "transport"},
+		{ID: "transport/taxi", Name: "Taxi", Parent: "transport"},
+	}
+	if !reflect.DeepEqual(want, children) {
+		t.Errorf("invalid result, expected: %v, actual: %v", want, children)
+	}
+
+	if !s.CategoryWithin("Transport/Fuel", "transport") || s.CategoryWithin("transport", "transport/fuel") {
+		t.Errorf("CategoryWithin(): invalid hierarchy check")
+	}
+}
+
+func TestService_ResolveCategory(t *testing.T) {
+	s := newTestService()
+	err := s.addCategories()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	tests := []struct {
+		raw  types.PaymentCategory
+		want types.PaymentCategory
+		err  error
+	}{
+		{raw: "auto", want: "auto"},
+		{raw: " AUTO ", want: "auto"},
+		{raw: "Cars", want: "auto"},
+		{raw: "transport / fuel", want: "transport/fuel"},
+		{raw: "idkn", err: ErrUnknownCategory},
+	}
+
+	for _, test := range tests {
+		got, err := s.ResolveCategory(test.raw)
+		if !errors.Is(err, test.err) || got != test.want {
+			t.Errorf("ResolveCategory(%q): expected: %v, %v, actual: %v, %v", test.raw, test.want, test.err, got, err)
+		}
+	}
+}
+
+func TestService_Pay_strictCategories(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.addCategories()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	payment, err := s.Pay(account.ID, 1_00, "Cars")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if payment.Category != "auto" {
+		t.Errorf("invalid category, expected: auto, actual: %v", payment.Category)
+	}
+
+	_, err = s.Pay(account.ID, 1_00, "idkn")
+	if err != nil {
+		t.Errorf("Pay(): unknown categories must pass when strict mode is off, returned %v", err)
+	}
+
+	s.SetStrictCategories(true)
+	_, err = s.Pay(account.ID, 1_00, "idkn")
+	var walletErr *WalletError
+	if !errors.Is(err, ErrUnknownCategory) || !errors.As(err, &walletErr) || walletErr.ID != "idkn" {
+		t.Errorf("Pay(): must return ErrUnknownCategory with the category, returned %v", err)
+	}
+}
+
+func TestService_NormalizeCategories(t *testing.T) {
+	s := newTestService()
+	s.payments = append(s.payments,
+		&types.Payment{ID: "a", AccountID: 1, Amount: 1, Category: "cars"},
+		&types.Payment{ID: "b", AccountID: 1, Amount: 1, Category: "Food"},
+		&types.Payment{ID: "c", AccountID: 1, Amount: 1, Category: "food"},
+		&types.Payment{ID: "d", AccountID: 1, Amount: 1, Category: "idkn"},
+	)
+	s.favorites = append(s.favorites, &types.Favorite{ID: "f", AccountID: 1, Category: "Auto"})
+
+	err := s.addCategories()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	changed := s.NormalizeCategories()
+	if changed != 3 {
+		t.Errorf("invalid result, expected: %v, actual: %v", 3, changed)
+	}
+
+	got := []types.PaymentCategory{}
+	for _, payment := range s.payments {
+		got = append(got, payment.Category)
+	}
+	want := []types.PaymentCategory{"auto", "food", "food", "idkn"}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("invalid categories, expected: %v, actual: %v", want, got)
+	}
+}
+
+func TestService_ExportImport_categories(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.addCategories()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.RegisterCategory("home", "Home;\nrent")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetStrictCategories(true)
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !reflect.DeepEqual(s.Categories(), imported.Categories()) {
+		t.Errorf("invalid categories, expected: %v, actual: %v", s.Categories(), imported.Categories())
+	}
+	category, err := imported.ResolveCategory("Cars")
+	if err != nil || category != "auto" {
+		t.Errorf("ResolveCategory(): alias must be imported, returned %v, %v", category, err)
+	}
+	_, err = imported.Pay(account.ID, 1_00, "idkn")
+	if !errors.Is(err, ErrUnknownCategory) {
+		t.Errorf("Pay(): strict mode must be imported, returned %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+package wallet
+
+import (
+	"fmt"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+}
+
+type ClockFunc func() time.Time
+
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// SetClock replaces the clock used by time-dependent operations such as the
+// scheduler. A nil clock restores the system one.
+func (s *Service) SetClock(clock Clock) {
+	defer s.audit("SetClock", 0, fmt.Sprintf("%T", clock)).done(nil)
+
+	s.clock = clock
+}
+
+func (s *Service) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+
+	return s.clock.Now()
+}
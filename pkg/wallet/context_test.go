@@ -0,0 +1,147 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+func TestService_ExportContext_cancelled(t *testing.T) {
+	s := newBenchmarkService(20_000)
+	s.accounts = append(s.accounts, &types.Account{ID: 1, Phone: "+992000000001", Balance: 100})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dir := t.TempDir()
+	err := s.ExportContext(ctx, dir, func(done int, total int) {
+		if done >= total/2 {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ExportContext(): must return context.Canceled, returned %v", err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(files) != 0 {
+		t.Errorf("cancelled export must not leave files, found %v", len(files))
+	}
+}
+
+func TestService_ExportImportContext_progress(t *testing.T) {
+	s := newBenchmarkService(10_000)
+	s.accounts = append(s.accounts, &types.Account{ID: 1, Phone: "+992000000001", Balance: 100})
+
+	var last, total int
+	dir := t.TempDir()
+	err := s.ExportContext(context.Background(), dir, func(d int, t int) {
+		last, total = d, t
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if last != 10_001 || total != 10_001 {
+		t.Errorf("invalid progress, expected: 10001 of 10001, actual: %v of %v", last, total)
+	}
+
+	last, total = 0, 0
+	imported := newTestService()
+	err = imported.ImportContext(context.Background(), dir, func(d int, t int) {
+		last, total = d, t
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if last == 0 || last != total {
+		t.Errorf("invalid progress, actual: %v of %v", last, total)
+	}
+	if len(imported.payments) != 10_000 {
+		t.Errorf("invalid import, expected 10000 payments, actual: %v", len(imported.payments))
+	}
+}
+
+func TestService_ImportContext_cancelled(t *testing.T) {
+	s := newBenchmarkService(10_000)
+	dir := t.TempDir()
+	err := s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	imported := newTestService()
+	err = imported.ImportContext(ctx, dir, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ImportContext(): must return context.Canceled, returned %v", err)
+	}
+	if len(imported.payments) != 0 {
+		t.Errorf("cancelled import must not change the service, got %v payments", len(imported.payments))
+	}
+}
+
+func TestService_HistoryToFilesContext_cancelled(t *testing.T) {
+	s := newBenchmarkService(10_000)
+	payments, err := s.FilterPaymentsByFn(func(payment types.Payment) bool { return true }, 1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dir := t.TempDir()
+	err = s.HistoryToFilesContext(ctx, payments, dir, 1_000, func(done int, total int) {
+		cancel()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("HistoryToFilesContext(): must return context.Canceled, returned %v", err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(files) != 0 {
+		t.Errorf("cancelled call must not leave files, found %v", len(files))
+	}
+}
+
+func TestService_SumPaymentsContext(t *testing.T) {
+	s := newBenchmarkService(100_000)
+
+	var last int
+	sum, err := s.SumPaymentsContext(context.Background(), 4, func(done int, total int) {
+		last = done
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if sum != s.SumPayments(1) || last != 100_000 {
+		t.Errorf("invalid result: sum %v, progress %v", sum, last)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = s.SumPaymentsContext(ctx, 4, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("SumPaymentsContext(): must return context.Canceled, returned %v", err)
+	}
+
+	_, err = s.FilterPaymentsByFnContext(ctx, func(payment types.Payment) bool { return true }, 4, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("FilterPaymentsByFnContext(): must return context.Canceled, returned %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+package wallet
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrDepositNotFound = errors.New("deposit not found")
+var ErrDepositReversed = errors.New("deposit already reversed")
+var ErrInvalidDeposit = errors.New("invalid deposit")
+
+// DepositOptions describe where the money came from. Source and Reference
+// are free text, such as a card mask and a bank transaction ID.
+type DepositOptions struct {
+	Channel   types.DepositChannel
+	Source    string
+	Reference string
+}
+
+// DepositWithOptions tops up the account and records the deposit.
+func (s *Service) DepositWithOptions(accountID int64, amount types.Money, options DepositOptions) (_ *types.Deposit, err error) {
+	defer s.audit("DepositWithOptions", accountID, accountID, amount, options).done(&err)
+
+	if amount <= 0 {
+		return nil, newError("Deposit", ErrAmountMustBePositive, accountID, "", amount)
+	}
+	for _, field := range []string{string(options.Channel), options.Source, options.Reference} {
+		if strings.ContainsAny(field, ";\n") {
+			return nil, newError("Deposit", ErrInvalidDeposit, accountID, "", amount)
+		}
+	}
+
+	account, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	deposit := &types.Deposit{
+		ID:        uuid.New().String(),
+		AccountID: accountID,
+		Amount:    amount,
+		Channel:   options.Channel,
+		Source:    options.Source,
+		Reference: options.Reference,
+		Created:   s.now(),
+	}
+	account.Balance += amount
+	s.deposits = append(s.deposits, deposit)
+	s.record(accountID, types.EntryDeposit, amount, 0, deposit.ID)
+	s.publish(types.EventDeposited, accountID, deposit.ID, amount, "")
+	return deposit, nil
+}
+
+func (s *Service) FindDepositByID(depositID string) (*types.Deposit, error) {
+	for _, deposit := range s.deposits {
+		if deposit.ID == depositID {
+			return deposit, nil
+		}
+	}
+
+	return nil, newError("FindDepositByID", ErrDepositNotFound, 0, depositID, 0)
+}
+
+// ReverseDeposit takes the deposited money back, for example after a
+// chargeback. The account must still have it.
+func (s *Service) ReverseDeposit(depositID string) (err error) {
+	defer s.audit("ReverseDeposit", 0, depositID).done(&err)
+
+	deposit, err := s.FindDepositByID(depositID)
+	if err != nil {
+		return err
+	}
+	if deposit.Reversed {
+		return newError("ReverseDeposit", ErrDepositReversed, deposit.AccountID, depositID, deposit.Amount)
+	}
+
+	account, err := s.FindAccountByID(deposit.AccountID)
+	if err != nil {
+		return err
+	}
+	if account.Balance < deposit.Amount {
+		return newError("ReverseDeposit", ErrNotEnoughBalance, deposit.AccountID, depositID, deposit.Amount)
+	}
+
+	account.Balance -= deposit.Amount
+	deposit.Reversed = true
+	s.record(account.ID, types.EntryReversal, -deposit.Amount, 0, deposit.ID)
+	return nil
+}
+
+// ExportAccountDeposits is ExportAccountHistory for deposits.
+func (s *Service) ExportAccountDeposits(accountID int64) ([]types.Deposit, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	var deposits []types.Deposit
+	for _, deposit := range s.deposits {
+		if deposit.AccountID == accountID {
+			deposits = append(deposits, *deposit)
+		}
+	}
+
+	return deposits, nil
+}
+
+// ExportAccountTransactions returns the payments and deposits of the
+// account, oldest first.
+func (s *Service) ExportAccountTransactions(accountID int64) ([]types.Transaction, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	transactions := []types.Transaction{}
+	for _, deposit := range s.deposits {
+		if deposit.AccountID == accountID {
+			transactions = append(transactions, types.Transaction{
+				ID:        deposit.ID,
+				AccountID: deposit.AccountID,
+				Kind:      types.EntryDeposit,
+				Amount:    deposit.Amount,
+				Channel:   deposit.Channel,
+				Status:    depositStatus(deposit),
+				Created:   deposit.Created,
+			})
+		}
+	}
+	for _, payment := range s.payments {
+		if payment.AccountID == accountID {
+			transactions = append(transactions, types.Transaction{
+				ID:        payment.ID,
+				AccountID: payment.AccountID,
+				Kind:      types.EntryPayment,
+				Amount:    -payment.Amount,
+				Category:  payment.Category,
+				Status:    payment.Status,
+				Created:   payment.Created,
+			})
+		}
+	}
+	sort.SliceStable(transactions, func(i, j int) bool {
+		return transactions[i].Created.Before(transactions[j].Created)
+	})
+
+	return transactions, nil
+}
+
+func depositStatus(deposit *types.Deposit) types.PaymentStatus {
+	if deposit.Reversed {
+		return types.PaymentStatusFail
+	}
+
+	return types.PaymentStatusOk
+}
+
+type DepositGroup struct {
+	Channel types.DepositChannel
+	Count   int
+	Total   types.Money
+}
+
+// DepositTotals sums deposits made in [from, to) by channel, reversed ones
+// excluded. Zero from and to leave the window open.
+func (s *Service) DepositTotals(from time.Time, to time.Time) []DepositGroup {
+	groups := make(map[types.DepositChannel]*DepositGroup)
+	for _, deposit := range s.deposits {
+		if deposit.Reversed {
+			continue
+		}
+		if !from.IsZero() && deposit.Created.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !deposit.Created.Before(to) {
+			continue
+		}
+
+		group, ok := groups[deposit.Channel]
+		if !ok {
+			group = &DepositGroup{Channel: deposit.Channel}
+			groups[deposit.Channel] = group
+		}
+		group.Count++
+		group.Total += deposit.Amount
+	}
+
+	result := make([]DepositGroup, 0, len(groups))
+	for _, group := range groups {
+		result = append(result, *group)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Channel < result[j].Channel
+	})
+
+	return result
+}
+
+func (s *Service) depositRecords() [][]string {
+	var records [][]string
+	for _, deposit := range s.deposits {
+		records = append(records, []string{
+			deposit.ID,
+			strconv.FormatInt(deposit.AccountID, 10),
+			strconv.FormatInt(int64(deposit.Amount), 10),
+			string(deposit.Channel),
+			deposit.Source,
+			deposit.Reference,
+			formatTime(deposit.Created),
+			strconv.FormatBool(deposit.Reversed),
+		})
+	}
+
+	return records
+}
+
+func parseDeposit(record []string) (*types.Deposit, error) {
+	if len(record) < 8 {
+		return nil, ErrInvalidRecord
+	}
+
+	var err error
+	deposit := &types.Deposit{
+		ID:        record[0],
+		Channel:   types.DepositChannel(record[3]),
+		Source:    record[4],
+		Reference: record[5],
+	}
+	if deposit.AccountID, err = strconv.ParseInt(record[1], 10, 64); err != nil {
+		return nil, err
+	}
+	amount, err := strconv.ParseInt(record[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	deposit.Amount = types.Money(amount)
+	if deposit.Created, err = parseTime(record[6]); err != nil {
+		return nil, err
+	}
+	if deposit.Reversed, err = strconv.ParseBool(record[7]); err != nil {
+		return nil, err
+	}
+
+	return deposit, nil
+}
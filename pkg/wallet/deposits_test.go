@@ -0,0 +1,237 @@
+package wallet
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+func TestService_DepositWithOptions(t *testing.T) {
+	s := newTestService()
+	account, err := s.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	deposit, err := s.DepositWithOptions(account.ID, 100, DepositOptions{
+		Channel:   types.DepositCard,
+		Source:    "4111********1111",
+		Reference: "TX-1",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	deposits, err := s.ExportAccountDeposits(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual([]types.Deposit{*deposit}, deposits) {
+		t.Errorf("invalid result, expected: %v, actual: %v", []types.Deposit{*deposit}, deposits)
+	}
+
+	entries, err := s.AccountEntries(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(entries) != 1 || entries[0].Reference != deposit.ID || account.Balance != 100 {
+		t.Errorf("deposit must be recorded in the ledger, entries: %v, balance: %v", entries, account.Balance)
+	}
+
+	_, err = s.DepositWithOptions(account.ID, 100, DepositOptions{Reference: "a;b"})
+	if !errors.Is(err, ErrInvalidDeposit) {
+		t.Errorf("DepositWithOptions(): must return ErrInvalidDeposit, returned %v", err)
+	}
+}
+
+func TestService_ExportAccountTransactions(t *testing.T) {
+	s := newTestService()
+	clock := &testClock{now: time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC)}
+	s.SetClock(clock)
+	account, err := s.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	deposit, err := s.DepositWithOptions(account.ID, 1_000, DepositOptions{Channel: types.DepositCard})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	clock.advance(time.Minute)
+	payment, err := s.Pay(account.ID, 300, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	clock.advance(time.Minute)
+	reversed, err := s.DepositWithOptions(account.ID, 200, DepositOptions{Channel: types.DepositBank})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.ReverseDeposit(reversed.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	transactions, err := s.ExportAccountTransactions(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := []types.Transaction{
+		{ID: deposit.ID, AccountID: account.ID, Kind: types.EntryDeposit, Amount: 1_000, Channel: types.DepositCard, Status: types.PaymentStatusOk, Created: deposit.Created},
+		{ID: payment.ID, AccountID: account.ID, Kind: types.EntryPayment, Amount: -300, Category: "auto", Status: types.PaymentStatusInProgress, Created: payment.Created},
+		{ID: reversed.ID, AccountID: account.ID, Kind: types.EntryDeposit, Amount: 200, Channel: types.DepositBank, Status: types.PaymentStatusFail, Created: reversed.Created},
+	}
+	if !reflect.DeepEqual(want, transactions) {
+		t.Errorf("invalid result, expected: %v, actual: %v", want, transactions)
+	}
+
+	_, err = s.ExportAccountTransactions(999)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("ExportAccountTransactions(): must return ErrAccountNotFound, returned %v", err)
+	}
+}
+
+func TestService_ReverseDeposit(t *testing.T) {
+	s := newTestService()
+	account, err := s.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	deposit, err := s.DepositWithOptions(account.ID, 100, DepositOptions{Channel: types.DepositBank})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(account.ID, 60, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.ReverseDeposit(deposit.ID)
+	if !errors.Is(err, ErrNotEnoughBalance) {
+		t.Errorf("ReverseDeposit(): must return ErrNotEnoughBalance, returned %v", err)
+	}
+
+	err = s.Deposit(account.ID, 60)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.ReverseDeposit(deposit.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account.Balance != 0 || !deposit.Reversed {
+		t.Errorf("invalid result, balance: %v, reversed: %v", account.Balance, deposit.Reversed)
+	}
+
+	err = s.ReverseDeposit(deposit.ID)
+	if !errors.Is(err, ErrDepositReversed) {
+		t.Errorf("ReverseDeposit(): must return ErrDepositReversed, returned %v", err)
+	}
+
+	err = s.ReverseDeposit("unknown")
+	if !errors.Is(err, ErrDepositNotFound) {
+		t.Errorf("ReverseDeposit(): must return ErrDepositNotFound, returned %v", err)
+	}
+}
+
+func TestService_DepositTotals(t *testing.T) {
+	s := newTestService()
+	clock := &testClock{now: time.Date(2021, 1, 1, 9, 0, 0, 0, time.UTC)}
+	s.SetClock(clock)
+
+	account, err := s.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, channel := range []types.DepositChannel{types.DepositCash, types.DepositCard, types.DepositCash} {
+		_, err = s.DepositWithOptions(account.ID, 100, DepositOptions{Channel: channel})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	reversed, err := s.DepositWithOptions(account.ID, 100, DepositOptions{Channel: types.DepositCard})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.ReverseDeposit(reversed.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	clock.advance(time.Hour)
+	_, err = s.DepositWithOptions(account.ID, 100, DepositOptions{Channel: types.DepositBank})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	groups := s.DepositTotals(time.Time{}, clock.now)
+	want := []DepositGroup{
+		{Channel: types.DepositCard, Count: 1, Total: 100},
+		{Channel: types.DepositCash, Count: 2, Total: 200},
+	}
+	if !reflect.DeepEqual(want, groups) {
+		t.Errorf("invalid result, expected: %v, actual: %v", want, groups)
+	}
+}
+
+func TestService_ExportImport_deposits(t *testing.T) {
+	s := newTestService()
+	account, err := s.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.DepositWithOptions(account.ID, 100, DepositOptions{Channel: types.DepositCash, Source: "office 1"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	deposits, err := imported.ExportAccountDeposits(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(deposits) != 1 || deposits[0].ID != s.deposits[0].ID || deposits[0].Source != "office 1" || deposits[0].Amount != 100 {
+		t.Errorf("invalid result, expected: %v, actual: %v", *s.deposits[0], deposits)
+	}
+}
@@ -0,0 +1,312 @@
+package wallet
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidRecord = errors.New("invalid dump record")
+
+// dumpError wraps an error of parsing the i-th record of the named dump.
+func dumpError(op string, name string, i int, err error) error {
+	return &WalletError{
+		Code: CodeInvalidDump,
+		Op:   op,
+		ID:   name,
+		Err:  fmt.Errorf("record %v: %w", i+1, err),
+	}
+}
+
+// dumpFile describes a file written by writeDumps: count records, fields
+// separated by ";" and records by "\n". Legacy dumps (accounts, payments and
+// favorites) are left untouched when there is nothing to write, as Export
+// always did; other dumps are removed so that Import doesn't bring stale
+// records back, unless they are kept: kept dumps are written even when
+// empty.
+type dumpFile struct {
+	name   string
+	count  int
+	line   func(i int) string
+	legacy bool
+	keep   bool
+}
+
+func recordsDump(name string, records [][]string) dumpFile {
+	return dumpFile{
+		name:  name,
+		count: len(records),
+		line: func(i int) string {
+			return strings.Join(records[i], ";")
+		},
+	}
+}
+
+// dumpJournal lists the changes of an export that is being committed.
+const dumpJournal = "export.journal"
+
+// writeDumps writes files into dir as one change. Every file goes to a
+// temporary one first. Once all of them are written, a journal of the files
+// to move into place and to remove is written and renamed to dumpJournal:
+// that single rename commits the export, then commitDumps applies the
+// journal. A call that fails or stops before the commit leaves the old dumps
+// as they were, one that stops after it is finished by the next writeDumps
+// or Import. progress receives the number of records written.
+func writeDumps(ctx context.Context, dir string, files []dumpFile, progress ProgressFunc) error {
+	err := commitDumps(dir)
+	if err != nil {
+		return err
+	}
+
+	total := 0
+	for _, file := range files {
+		total += file.count
+	}
+	tracker := newProgressTracker(progress, total)
+
+	var temporary []string
+	cleanup := func() {
+		for _, path := range temporary {
+			err := os.Remove(path)
+			if err != nil && !os.IsNotExist(err) {
+				log.Print(err)
+			}
+		}
+	}
+
+	var journal []string
+	for _, file := range files {
+		if file.count == 0 && !file.keep {
+			if !file.legacy {
+				journal = append(journal, "remove;"+file.name)
+			}
+			continue
+		}
+
+		path := filepath.Join(dir, file.name+".tmp")
+		temporary = append(temporary, path)
+		err = writeDumpFile(ctx, path, file, tracker)
+		if err != nil {
+			cleanup()
+			return err
+		}
+		journal = append(journal, "write;"+file.name)
+	}
+
+	err = ctx.Err()
+	if err != nil {
+		cleanup()
+		return err
+	}
+
+	path := filepath.Join(dir, dumpJournal)
+	temporary = append(temporary, path+".tmp")
+	err = writeSynced(path+".tmp", strings.Join(journal, "\n"))
+	if err != nil {
+		cleanup()
+		return err
+	}
+	err = os.Rename(path+".tmp", path)
+	if err != nil {
+		cleanup()
+		return err
+	}
+
+	return commitDumps(dir)
+}
+
+// commitDumps applies the journal of a committed export left in dir, if
+// any, and removes it. Applying a journal twice does no harm, so an export
+// that stopped while applying it can be finished later.
+func commitDumps(dir string) error {
+	path := filepath.Join(dir, dumpJournal)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+
+		fields := strings.SplitN(line, ";", 2)
+		if len(fields) != 2 {
+			return fmt.Errorf("%v: %w", dumpJournal, ErrInvalidRecord)
+		}
+		target := filepath.Join(dir, fields[1])
+		switch fields[0] {
+		case "write":
+			err = os.Rename(target+".tmp", target)
+		case "remove":
+			err = os.Remove(target)
+		default:
+			return fmt.Errorf("%v: %w", dumpJournal, ErrInvalidRecord)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return os.Remove(path)
+}
+
+func writeSynced(path string, data string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.WriteString(data)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	cerr := f.Close()
+	if err != nil {
+		return err
+	}
+
+	return cerr
+}
+
+func writeDumpFile(ctx context.Context, path string, file dumpFile, tracker *progressTracker) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(f)
+	for i := 0; i < file.count; i++ {
+		if i%progressStep == 0 {
+			err = ctx.Err()
+			if err != nil {
+				break
+			}
+		}
+
+		line := file.line(i)
+		if i != file.count-1 {
+			line += "\n"
+		}
+
+		_, err = writer.WriteString(line)
+		if err != nil {
+			break
+		}
+		tracker.add(1)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+
+	cerr := f.Close()
+	if err != nil {
+		return err
+	}
+
+	return cerr
+}
+
+// writeDump writes a single dump file, see writeDumps.
+func writeDump(path string, records [][]string) error {
+	dir, name := filepath.Split(path)
+	return writeDumps(context.Background(), dir, []dumpFile{recordsDump(name, records)}, nil)
+}
+
+// readDump reads records written by writeDumps. A missing file is not an
+// error, it just has no records.
+func readDump(path string) ([][]string, error) {
+	return readDumpContext(context.Background(), path, newProgressTracker(nil, 0))
+}
+
+// readDumpContext is readDump that stops when ctx is done and reports the
+// number of bytes read to tracker.
+func readDumpContext(ctx context.Context, path string, tracker *progressTracker) ([][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer func() {
+		cerr := file.Close()
+		if cerr != nil {
+			log.Print(cerr)
+		}
+	}()
+
+	records := [][]string{}
+	reader := bufio.NewReader(file)
+	for i := 0; ; i++ {
+		if i%progressStep == 0 {
+			err = ctx.Err()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		tracker.add(len(line))
+
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			records = append(records, strings.Split(line, ";"))
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	return records, nil
+}
+
+func dumpSize(path string) int {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+
+	return int(info.Size())
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	unix, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(unix, 0), nil
+}
@@ -0,0 +1,276 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+var ErrInvalidRecordsCount = errors.New("there must be at least 1 record")
+
+// ErrorCode is a stable identifier of an error that clients can rely on
+// instead of error messages.
+type ErrorCode string
+
+const (
+	CodeUnknown          ErrorCode = "UNKNOWN"
+	CodeIO               ErrorCode = "IO"
+	CodeCanceled         ErrorCode = "CANCELED"
+	CodeDeadlineExceeded ErrorCode = "DEADLINE_EXCEEDED"
+
+	CodeAccountNotFound  ErrorCode = "ACCOUNT_NOT_FOUND"
+	CodePaymentNotFound  ErrorCode = "PAYMENT_NOT_FOUND"
+	CodeFavoriteNotFound ErrorCode = "FAVORITE_NOT_FOUND"
+	CodeFavoriteNotOwned ErrorCode = "FAVORITE_NOT_OWNED"
+	CodeDepositNotFound  ErrorCode = "DEPOSIT_NOT_FOUND"
+	CodeScheduleNotFound ErrorCode = "SCHEDULE_NOT_FOUND"
+	CodeBudgetNotFound   ErrorCode = "BUDGET_NOT_FOUND"
+	CodeCampaignNotFound ErrorCode = "CAMPAIGN_NOT_FOUND"
+	CodeWebhookNotFound  ErrorCode = "WEBHOOK_NOT_FOUND"
+	CodeReviewNotFound   ErrorCode = "REVIEW_NOT_FOUND"
+	CodePolicyNotFound   ErrorCode = "APPROVAL_POLICY_NOT_FOUND"
+	CodeApprovalNotFound ErrorCode = "APPROVAL_NOT_FOUND"
+	CodeUserNotFound     ErrorCode = "USER_NOT_FOUND"
+	CodeNotLinked        ErrorCode = "NOT_LINKED"
+
+	CodePhoneRegistered   ErrorCode = "PHONE_REGISTERED"
+	CodeFavoriteNameTaken ErrorCode = "FAVORITE_NAME_TAKEN"
+	CodeCategoryExists    ErrorCode = "CATEGORY_EXISTS"
+	CodeDepositReversed   ErrorCode = "DEPOSIT_REVERSED"
+	CodePaymentRejected   ErrorCode = "PAYMENT_REJECTED"
+	CodeApprovalExpired   ErrorCode = "APPROVAL_EXPIRED"
+	CodeAlreadyApproved   ErrorCode = "ALREADY_APPROVED"
+	CodeLoginTaken        ErrorCode = "LOGIN_TAKEN"
+
+	CodeAmountNotPositive     ErrorCode = "AMOUNT_NOT_POSITIVE"
+	CodeFavoriteNameEmpty     ErrorCode = "FAVORITE_NAME_EMPTY"
+	CodeInvalidFavoriteName   ErrorCode = "INVALID_FAVORITE_NAME"
+	CodeUnknownCategory       ErrorCode = "UNKNOWN_CATEGORY"
+	CodeInvalidCategory       ErrorCode = "INVALID_CATEGORY"
+	CodeInvalidBudget         ErrorCode = "INVALID_BUDGET"
+	CodeInvalidCampaign       ErrorCode = "INVALID_CAMPAIGN"
+	CodeInvalidSchedule       ErrorCode = "INVALID_SCHEDULE"
+	CodeInvalidCron           ErrorCode = "INVALID_CRON"
+	CodeInvalidDeposit        ErrorCode = "INVALID_DEPOSIT"
+	CodeInvalidFeeRule        ErrorCode = "INVALID_FEE_RULE"
+	CodeInvalidTemplate       ErrorCode = "INVALID_TEMPLATE"
+	CodeInvalidHistoryOptions ErrorCode = "INVALID_HISTORY_OPTIONS"
+	CodeInvalidRecordsCount   ErrorCode = "INVALID_RECORDS_COUNT"
+	CodeInvalidSettlement     ErrorCode = "INVALID_SETTLEMENT"
+	CodeInvalidWebhook        ErrorCode = "INVALID_WEBHOOK"
+	CodeInvalidApprovalPolicy ErrorCode = "INVALID_APPROVAL_POLICY"
+	CodeInvalidLogin          ErrorCode = "INVALID_LOGIN"
+	CodeWeakCredential        ErrorCode = "WEAK_CREDENTIAL"
+	CodeInvalidRole           ErrorCode = "INVALID_ROLE"
+
+	CodeInvalidCredentials ErrorCode = "INVALID_CREDENTIALS"
+	CodeSessionNotFound    ErrorCode = "SESSION_NOT_FOUND"
+	CodeForbidden          ErrorCode = "FORBIDDEN"
+
+	CodeNotEnoughBalance ErrorCode = "NOT_ENOUGH_BALANCE"
+	CodeBudgetExceeded   ErrorCode = "BUDGET_EXCEEDED"
+	CodeFavoritesLimit   ErrorCode = "FAVORITES_LIMIT"
+	CodePaymentDenied    ErrorCode = "PAYMENT_DENIED"
+	CodeNotApprover      ErrorCode = "NOT_APPROVER"
+
+	CodeInvalidDump      ErrorCode = "INVALID_DUMP"
+	CodeChecksumMismatch ErrorCode = "CHECKSUM_MISMATCH"
+	CodeAuditTampered    ErrorCode = "AUDIT_TAMPERED"
+)
+
+// ErrorKind groups error codes by what a client can do about them, API
+// layers map kinds to their status codes.
+type ErrorKind int
+
+const (
+	KindInternal ErrorKind = iota
+	KindNotFound
+	KindConflict
+	KindInvalid
+	KindRejected
+	KindCanceled
+	KindUnauthenticated
+	KindForbidden
+)
+
+type ErrorMapping struct {
+	Err  error
+	Code ErrorCode
+	Kind ErrorKind
+}
+
+// ErrorMappings lists the errors returned by the service with their codes
+// and kinds. Errors are matched with errors.Is in this order.
+var ErrorMappings = []ErrorMapping{
+	{ErrAccountNotFound, CodeAccountNotFound, KindNotFound},
+	{ErrPaymentNotFound, CodePaymentNotFound, KindNotFound},
+	{ErrFavoriteNotFound, CodeFavoriteNotFound, KindNotFound},
+	{ErrFavoriteNotOwned, CodeFavoriteNotOwned, KindNotFound},
+	{ErrDepositNotFound, CodeDepositNotFound, KindNotFound},
+	{ErrScheduleNotFound, CodeScheduleNotFound, KindNotFound},
+	{ErrBudgetNotFound, CodeBudgetNotFound, KindNotFound},
+	{ErrCampaignNotFound, CodeCampaignNotFound, KindNotFound},
+	{ErrWebhookNotFound, CodeWebhookNotFound, KindNotFound},
+	{ErrReviewNotFound, CodeReviewNotFound, KindNotFound},
+	{ErrApprovalPolicyNotFound, CodePolicyNotFound, KindNotFound},
+	{ErrApprovalNotFound, CodeApprovalNotFound, KindNotFound},
+	{ErrUserNotFound, CodeUserNotFound, KindNotFound},
+	{ErrMembershipNotFound, CodeNotLinked, KindNotFound},
+
+	{ErrPhoneRegistered, CodePhoneRegistered, KindConflict},
+	{ErrFavoriteNameTaken, CodeFavoriteNameTaken, KindConflict},
+	{ErrCategoryExists, CodeCategoryExists, KindConflict},
+	{ErrDepositReversed, CodeDepositReversed, KindConflict},
+	{ErrPaymentRejected, CodePaymentRejected, KindConflict},
+	{ErrApprovalExpired, CodeApprovalExpired, KindConflict},
+	{ErrAlreadyApproved, CodeAlreadyApproved, KindConflict},
+	{ErrLoginTaken, CodeLoginTaken, KindConflict},
+
+	{ErrAmountMustBePositive, CodeAmountNotPositive, KindInvalid},
+	{ErrFavoriteNameEmpty, CodeFavoriteNameEmpty, KindInvalid},
+	{ErrInvalidFavoriteName, CodeInvalidFavoriteName, KindInvalid},
+	{ErrUnknownCategory, CodeUnknownCategory, KindInvalid},
+	{ErrInvalidCategory, CodeInvalidCategory, KindInvalid},
+	{ErrInvalidBudget, CodeInvalidBudget, KindInvalid},
+	{ErrInvalidCampaign, CodeInvalidCampaign, KindInvalid},
+	{ErrInvalidSchedule, CodeInvalidSchedule, KindInvalid},
+	{ErrInvalidCron, CodeInvalidCron, KindInvalid},
+	{ErrInvalidDeposit, CodeInvalidDeposit, KindInvalid},
+	{ErrInvalidFeeRule, CodeInvalidFeeRule, KindInvalid},
+	{ErrInvalidTemplate, CodeInvalidTemplate, KindInvalid},
+	{ErrInvalidHistoryOptions, CodeInvalidHistoryOptions, KindInvalid},
+	{ErrInvalidRecordsCount, CodeInvalidRecordsCount, KindInvalid},
+	{ErrInvalidSettlement, CodeInvalidSettlement, KindInvalid},
+	{ErrInvalidWebhook, CodeInvalidWebhook, KindInvalid},
+	{ErrInvalidApprovalPolicy, CodeInvalidApprovalPolicy, KindInvalid},
+	{ErrInvalidLogin, CodeInvalidLogin, KindInvalid},
+	{ErrWeakCredential, CodeWeakCredential, KindInvalid},
+	{ErrInvalidRole, CodeInvalidRole, KindInvalid},
+
+	{ErrInvalidCredentials, CodeInvalidCredentials, KindUnauthenticated},
+	{ErrSessionNotFound, CodeSessionNotFound, KindUnauthenticated},
+	{ErrForbidden, CodeForbidden, KindForbidden},
+
+	{ErrNotEnoughBalance, CodeNotEnoughBalance, KindRejected},
+	{ErrBudgetExceeded, CodeBudgetExceeded, KindRejected},
+	{ErrFavoritesLimit, CodeFavoritesLimit, KindRejected},
+	{ErrPaymentDenied, CodePaymentDenied, KindRejected},
+	{ErrNotApprover, CodeNotApprover, KindRejected},
+
+	{ErrInvalidRecord, CodeInvalidDump, KindInternal},
+	{ErrChecksumMismatch, CodeChecksumMismatch, KindInternal},
+	{ErrAuditTampered, CodeAuditTampered, KindInternal},
+
+	{context.Canceled, CodeCanceled, KindCanceled},
+	{context.DeadlineExceeded, CodeDeadlineExceeded, KindCanceled},
+}
+
+// WalletError is an error with its code and the IDs and amount it is
+// about. Err is the sentinel error or the underlying I/O or parse error,
+// so errors.Is(err, ErrAccountNotFound) keeps working. ID is the ID of the
+// payment, favorite or other object the error is about, or the phone.
+type WalletError struct {
+	Code      ErrorCode
+	Op        string
+	AccountID int64
+	ID        string
+	Amount    types.Money
+	Err       error
+}
+
+func (e *WalletError) Error() string {
+	var details []string
+	if e.AccountID != 0 {
+		details = append(details, "account "+strconv.FormatInt(e.AccountID, 10))
+	}
+	if e.ID != "" {
+		details = append(details, e.ID)
+	}
+	if e.Amount != 0 {
+		details = append(details, "amount "+strconv.FormatInt(int64(e.Amount), 10))
+	}
+
+	message := e.Op + ": " + e.Err.Error()
+	if len(details) != 0 {
+		message += " (" + strings.Join(details, ", ") + ")"
+	}
+
+	return message
+}
+
+func (e *WalletError) Unwrap() error {
+	return e.Err
+}
+
+// newError wraps err with the operation and the objects it is about. The
+// code comes from ErrorMappings.
+func newError(op string, err error, accountID int64, id string, amount types.Money) error {
+	return &WalletError{
+		Code:      CodeOf(err),
+		Op:        op,
+		AccountID: accountID,
+		ID:        id,
+		Amount:    amount,
+		Err:       err,
+	}
+}
+
+// ioError wraps an error of reading or writing files at path. Errors that
+// already have a code keep it.
+func ioError(op string, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var walletErr *WalletError
+	if errors.As(err, &walletErr) {
+		return err
+	}
+
+	code := CodeOf(err)
+	if code == CodeUnknown {
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			code = CodeIO
+		}
+	}
+
+	return &WalletError{Code: code, Op: op, ID: path, Err: err}
+}
+
+// CodeOf returns the code of err: the code of the first WalletError in the
+// chain or of the first matching ErrorMappings entry.
+func CodeOf(err error) ErrorCode {
+	if err == nil {
+		return ""
+	}
+
+	var walletErr *WalletError
+	if errors.As(err, &walletErr) && walletErr.Code != "" && walletErr.Code != CodeUnknown {
+		return walletErr.Code
+	}
+
+	for _, mapping := range ErrorMappings {
+		if errors.Is(err, mapping.Err) {
+			return mapping.Code
+		}
+	}
+
+	return CodeUnknown
+}
+
+// KindOf returns the kind of err's code, KindInternal for unknown errors.
+func KindOf(err error) ErrorKind {
+	code := CodeOf(err)
+	for _, mapping := range ErrorMappings {
+		if mapping.Code == code {
+			return mapping.Kind
+		}
+	}
+
+	return KindInternal
+}
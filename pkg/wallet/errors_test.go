@@ -0,0 +1,152 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+func TestService_Pay_walletError(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.Pay(account.ID, 1_000, "auto")
+	if !errors.Is(err, ErrNotEnoughBalance) {
+		t.Errorf("Pay(): must return ErrNotEnoughBalance, returned %v", err)
+		return
+	}
+
+	var walletErr *WalletError
+	if !errors.As(err, &walletErr) {
+		t.Errorf("Pay(): must return WalletError, returned %T", err)
+		return
+	}
+	if walletErr.Code != CodeNotEnoughBalance || walletErr.Op != "Pay" || walletErr.AccountID != account.ID || walletErr.Amount != 1_000 {
+		t.Errorf("invalid error: %#v", walletErr)
+	}
+	if KindOf(err) != KindRejected {
+		t.Errorf("invalid kind, expected: %v, actual: %v", KindRejected, KindOf(err))
+	}
+}
+
+func TestService_FindPaymentByID_walletError(t *testing.T) {
+	s := newTestService()
+
+	_, err := s.FindPaymentByID("unknown")
+	if CodeOf(err) != CodePaymentNotFound || KindOf(err) != KindNotFound {
+		t.Errorf("invalid code: %v, kind: %v", CodeOf(err), KindOf(err))
+	}
+
+	var walletErr *WalletError
+	if !errors.As(err, &walletErr) || walletErr.ID != "unknown" {
+		t.Errorf("FindPaymentByID(): must return WalletError with the ID, returned %v", err)
+	}
+}
+
+func TestService_validation_walletError(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, registerErr := s.RegisterCategory("a;b", "")
+	_, resolveErr := s.ResolveCategory("unknown")
+	_, budgetErr := s.SetBudget(account.ID, "auto", "DAILY", 100, types.BudgetSoft)
+	_, depositErr := s.DepositWithOptions(account.ID, 100, DepositOptions{Source: "a;b"})
+	_, campaignErr := s.AddCampaign(Campaign{Percent: 100})
+	s.SetStrictCategories(true)
+	_, payErr := s.Pay(account.ID, 10, "unknown")
+
+	tests := []struct {
+		op   string
+		err  error
+		code ErrorCode
+	}{
+		{"RegisterCategory", registerErr, CodeInvalidCategory},
+		{"AddCategoryAlias", s.AddCategoryAlias("cars", "unknown"), CodeUnknownCategory},
+		{"ResolveCategory", resolveErr, CodeUnknownCategory},
+		{"SetBudget", budgetErr, CodeInvalidBudget},
+		{"Deposit", depositErr, CodeInvalidDeposit},
+		{"AddFeeRule", s.AddFeeRule(FeeRule{Flat: -1}), CodeInvalidFeeRule},
+		{"AddCampaign", campaignErr, CodeInvalidCampaign},
+		{"Pay", payErr, CodeUnknownCategory},
+	}
+
+	for _, test := range tests {
+		var walletErr *WalletError
+		if !errors.As(test.err, &walletErr) || walletErr.Op != test.op || walletErr.Code != test.code {
+			t.Errorf("%v(): must return WalletError with code %v, returned %#v", test.op, test.code, test.err)
+		}
+	}
+}
+
+func TestCodeOf(t *testing.T) {
+	tests := []struct {
+		err  error
+		code ErrorCode
+		kind ErrorKind
+	}{
+		{nil, "", KindInternal},
+		{ErrFavoriteNameTaken, CodeFavoriteNameTaken, KindConflict},
+		{Error("there must be at least 1 record"), CodeUnknown, KindInternal},
+		{newError("Export", context.Canceled, 0, "", 0), CodeCanceled, KindCanceled},
+		{ioError("Export", "dir", &os.PathError{Op: "open", Path: "dir", Err: os.ErrPermission}), CodeIO, KindInternal},
+	}
+
+	for _, test := range tests {
+		if code := CodeOf(test.err); code != test.code {
+			t.Errorf("CodeOf(%v): expected: %v, actual: %v", test.err, test.code, code)
+		}
+		if kind := KindOf(test.err); kind != test.kind {
+			t.Errorf("KindOf(%v): expected: %v, actual: %v", test.err, test.kind, kind)
+		}
+	}
+}
+
+func TestService_Import_invalidDump(t *testing.T) {
+	s := newTestService()
+	_, err := s.addAccountWithBalance("+992000000001", 100)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	path := filepath.Join(dir, "accounts.dump")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = ioutil.WriteFile(path, []byte(strings.Replace(string(data), ";100", ";abc", 1)), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = newTestService().Import(dir)
+	if CodeOf(err) != CodeInvalidDump {
+		t.Errorf("Import(): must return CodeInvalidDump, returned %v", err)
+	}
+	if !strings.Contains(err.Error(), "accounts.dump") || !strings.Contains(err.Error(), "record 1") {
+		t.Errorf("invalid message: %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+package wallet
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/a1ishm/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+// EventHandler handles an event. Errors and panics of handlers are logged
+// and don't affect the call that published the event or other subscribers.
+type EventHandler func(event types.Event) error
+
+// Subscription delivers events of the chosen types to its handler until it
+// is closed.
+type Subscription struct {
+	s       *Service
+	handler EventHandler
+	types   map[types.EventType]bool
+	queues  []*eventQueue
+	wg      sync.WaitGroup
+}
+
+// eventQueue is an unbounded queue of events for one worker of an
+// asynchronous subscription, so publishing never waits for the handler.
+type eventQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	events []types.Event
+	closed bool
+}
+
+// Subscribe calls handler for events of the given types (all types when
+// none are given) right in the call that published them, so it must be
+// fast. Events come in the order they happened.
+func (s *Service) Subscribe(handler EventHandler, eventTypes ...types.EventType) *Subscription {
+	return s.subscribe(handler, 0, eventTypes)
+}
+
+// SubscribeAsync calls handler for events of the given types from workers
+// goroutines. Events of an account are always handled by the same worker in
+// the order they happened, a slow handler only delays its own events.
+func (s *Service) SubscribeAsync(handler EventHandler, workers int, eventTypes ...types.EventType) *Subscription {
+	if workers < 1 {
+		workers = 1
+	}
+
+	return s.subscribe(handler, workers, eventTypes)
+}
+
+func (s *Service) subscribe(handler EventHandler, workers int, eventTypes []types.EventType) *Subscription {
+	sub := &Subscription{s: s, handler: handler}
+	if len(eventTypes) != 0 {
+		sub.types = make(map[types.EventType]bool)
+		for _, eventType := range eventTypes {
+			sub.types[eventType] = true
+		}
+	}
+
+	for i := 0; i < workers; i++ {
+		queue := &eventQueue{}
+		queue.cond = sync.NewCond(&queue.mu)
+		sub.queues = append(sub.queues, queue)
+
+		sub.wg.Add(1)
+		go func() {
+			defer sub.wg.Done()
+			sub.work(queue)
+		}()
+	}
+
+	s.eventsMu.Lock()
+	s.subscriptions = append(s.subscriptions, sub)
+	s.eventsMu.Unlock()
+	return sub
+}
+
+// Close stops delivering new events. For asynchronous subscriptions it
+// waits until the events already queued are handled.
+func (sub *Subscription) Close() {
+	s := sub.s
+	s.eventsMu.Lock()
+	for i, item := range s.subscriptions {
+		if item == sub {
+			s.subscriptions = append(s.subscriptions[:i:i], s.subscriptions[i+1:]...)
+			break
+		}
+	}
+	s.eventsMu.Unlock()
+
+	for _, queue := range sub.queues {
+		queue.mu.Lock()
+		queue.closed = true
+		queue.cond.Signal()
+		queue.mu.Unlock()
+	}
+	sub.wg.Wait()
+}
+
+func (sub *Subscription) deliver(event types.Event) {
+	if sub.types != nil && !sub.types[event.Type] {
+		return
+	}
+
+	if len(sub.queues) == 0 {
+		sub.handle(event)
+		return
+	}
+
+	queue := sub.queues[uint64(event.AccountID)%uint64(len(sub.queues))]
+	queue.mu.Lock()
+	if !queue.closed {
+		queue.events = append(queue.events, event)
+		queue.cond.Signal()
+	}
+	queue.mu.Unlock()
+}
+
+func (sub *Subscription) work(queue *eventQueue) {
+	for {
+		queue.mu.Lock()
+		for len(queue.events) == 0 && !queue.closed {
+			queue.cond.Wait()
+		}
+		if len(queue.events) == 0 {
+			queue.mu.Unlock()
+			return
+		}
+		event := queue.events[0]
+		queue.events[0] = types.Event{}
+		queue.events = queue.events[1:]
+		queue.mu.Unlock()
+
+		sub.handle(event)
+	}
+}
+
+func (sub *Subscription) handle(event types.Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Print(fmt.Errorf("event %v %v: handler panicked: %v", event.Seq, event.Type, r))
+		}
+	}()
+
+	err := sub.handler(event)
+	if err != nil {
+		log.Print(fmt.Errorf("event %v %v: %w", event.Seq, event.Type, err))
+	}
+}
+
+// publish sends an event about the account to the subscribers.
+func (s *Service) publish(eventType types.EventType, accountID int64, objectID string, amount types.Money, category types.PaymentCategory) {
+	s.eventsMu.Lock()
+	s.eventSeq++
+	event := types.Event{
+		ID:        uuid.New().String(),
+		Seq:       s.eventSeq,
+		Type:      eventType,
+		AccountID: accountID,
+		ObjectID:  objectID,
+		Amount:    amount,
+		Category:  category,
+		Time:      s.now(),
+	}
+	if s.outboxEnabled {
+		s.outbox = append(s.outbox, &types.OutboxMessage{Event: event})
+	}
+	subscriptions := append([]*Subscription(nil), s.subscriptions...)
+	s.eventsMu.Unlock()
+
+	for _, sub := range subscriptions {
+		sub.deliver(event)
+	}
+}
+
+func eventFields(event types.Event) []string {
+	return []string{
+		event.ID,
+		strconv.FormatInt(event.Seq, 10),
+		string(event.Type),
+		strconv.FormatInt(event.AccountID, 10),
+		url.QueryEscape(event.ObjectID),
+		strconv.FormatInt(int64(event.Amount), 10),
+		string(event.Category),
+		formatTime(event.Time),
+	}
+}
+
+const eventFieldsCount = 8
+
+func parseEventFields(fields []string) (types.Event, error) {
+	if len(fields) < eventFieldsCount {
+		return types.Event{}, ErrInvalidRecord
+	}
+
+	var err error
+	event := types.Event{
+		ID:       fields[0],
+		Type:     types.EventType(fields[2]),
+		Category: types.PaymentCategory(fields[6]),
+	}
+	if event.Seq, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+		return types.Event{}, err
+	}
+	if event.AccountID, err = strconv.ParseInt(fields[3], 10, 64); err != nil {
+		return types.Event{}, err
+	}
+	if event.ObjectID, err = url.QueryUnescape(fields[4]); err != nil {
+		return types.Event{}, err
+	}
+	amount, err := strconv.ParseInt(fields[5], 10, 64)
+	if err != nil {
+		return types.Event{}, err
+	}
+	event.Amount = types.Money(amount)
+	if event.Time, err = parseTime(fields[7]); err != nil {
+		return types.Event{}, err
+	}
+
+	return event, nil
+}
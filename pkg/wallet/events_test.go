@@ -0,0 +1,246 @@
+package wallet
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+func TestService_Subscribe(t *testing.T) {
+	s := newTestService()
+
+	var events []types.Event
+	sub := s.Subscribe(func(event types.Event) error {
+		events = append(events, event)
+		return nil
+	})
+
+	account, err := s.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Deposit(account.ID, 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := s.Pay(account.ID, 100, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.FavoritePayment(payment.ID, "car")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(account.ID, 10_000, "auto")
+	if !errors.Is(err, ErrNotEnoughBalance) {
+		t.Errorf("Pay(): must return ErrNotEnoughBalance, returned %v", err)
+	}
+	sub.Close()
+
+	err = s.Deposit(account.ID, 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var kinds []types.EventType
+	for i, event := range events {
+		kinds = append(kinds, event.Type)
+		if event.Seq != int64(i+1) || event.AccountID != account.ID {
+			t.Errorf("invalid event: %v", event)
+		}
+	}
+	want := []types.EventType{
+		types.EventAccountRegistered,
+		types.EventDeposited,
+		types.EventPaymentCreated,
+		types.EventFavoriteCreated,
+		types.EventPaymentRejected,
+	}
+	if !reflect.DeepEqual(want, kinds) {
+		t.Errorf("invalid result, expected: %v, actual: %v", want, kinds)
+	}
+	if events[2].ObjectID != payment.ID || events[2].Amount != 100 || events[2].Category != "auto" {
+		t.Errorf("invalid event: %v", events[2])
+	}
+}
+
+func TestService_Subscribe_statusChanges(t *testing.T) {
+	s, clock, account, users := newApprovalTestService(t)
+
+	var events []string
+	s.Subscribe(func(event types.Event) error {
+		events = append(events, string(event.Type)+" "+event.ObjectID)
+		return nil
+	},
+		types.EventPaymentCreated,
+		types.EventPaymentHeld,
+		types.EventPaymentReleased,
+		types.EventPaymentPending,
+		types.EventPaymentApproved,
+		types.EventPaymentExpired,
+		types.EventPaymentConfirmed,
+	)
+
+	approved, err := s.Pay(account.ID, 1_000, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, approver := range []string{"alice", "bob"} {
+		_, err = users[approver].Approve(approved.ID)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	_, err = s.ReconcileReader(strings.NewReader(approved.ID + ",1000,OK\n"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.AddRiskRule(BlockedCategoryRule{Categories: []types.PaymentCategory{"games"}, Action: RiskHold})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	held, err := s.Pay(account.ID, 100, "games")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.ApproveReview(held.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expired, err := s.Pay(account.ID, 1_000, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	clock.advance(25 * time.Hour)
+	s.ExpireApprovals()
+
+	want := []string{
+		"PAYMENT_PENDING " + approved.ID,
+		"PAYMENT_APPROVED " + approved.ID,
+		"PAYMENT_CONFIRMED " + approved.ID,
+		"PAYMENT_HELD " + held.ID,
+		"PAYMENT_RELEASED " + held.ID,
+		"PAYMENT_PENDING " + expired.ID,
+		"PAYMENT_EXPIRED " + expired.ID,
+	}
+	if !reflect.DeepEqual(want, events) {
+		t.Errorf("invalid result, expected: %v, actual: %v", want, events)
+	}
+}
+
+func TestService_Subscribe_failureIsolation(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	s.Subscribe(func(event types.Event) error {
+		panic("broken subscriber")
+	})
+	s.Subscribe(func(event types.Event) error {
+		return errors.New("failed")
+	})
+	var payments []string
+	s.Subscribe(func(event types.Event) error {
+		payments = append(payments, event.ObjectID)
+		return nil
+	}, types.EventPaymentCreated)
+
+	payment, err := s.Pay(account.ID, 100, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	want := []string{payment.ID}
+	if !reflect.DeepEqual(want, payments) {
+		t.Errorf("invalid result, expected: %v, actual: %v", want, payments)
+	}
+}
+
+func TestService_SubscribeAsync(t *testing.T) {
+	s := newTestService()
+	var accounts []*types.Account
+	for _, phone := range []types.Phone{"+992000000001", "+992000000002", "+992000000003"} {
+		account, err := s.addAccountWithBalance(phone, 1_000_000)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		accounts = append(accounts, account)
+	}
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	amounts := make(map[int64][]types.Money)
+	sub := s.SubscribeAsync(func(event types.Event) error {
+		<-release
+		mu.Lock()
+		amounts[event.AccountID] = append(amounts[event.AccountID], event.Amount)
+		mu.Unlock()
+		return nil
+	}, 2, types.EventPaymentCreated)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= 50; i++ {
+			for _, account := range accounts {
+				_, err := s.Pay(account.ID, types.Money(i), "auto")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("Pay() must not wait for asynchronous subscribers")
+		return
+	}
+
+	close(release)
+	sub.Close()
+
+	for _, account := range accounts {
+		if len(amounts[account.ID]) != 50 {
+			t.Errorf("invalid number of events, expected: %v, actual: %v", 50, len(amounts[account.ID]))
+			continue
+		}
+		for i, amount := range amounts[account.ID] {
+			if amount != types.Money(i+1) {
+				t.Errorf("events of account %v must be in order, got %v", account.ID, amounts[account.ID])
+				break
+			}
+		}
+	}
+}
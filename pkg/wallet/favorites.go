@@ -0,0 +1,197 @@
+package wallet
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+var ErrFavoriteNotOwned = errors.New("favorite belongs to another account")
+var ErrFavoriteNameTaken = errors.New("favorite name already taken")
+var ErrFavoriteNameEmpty = errors.New("favorite name must not be empty")
+var ErrInvalidFavoriteName = errors.New("favorite name must not contain ';' or line breaks")
+var ErrFavoritesLimit = errors.New("favorites limit reached")
+
+const DefaultFavoritesLimit = 50
+
+// SetFavoritesLimit sets how many favorites an account may have. Zero
+// restores DefaultFavoritesLimit.
+func (s *Service) SetFavoritesLimit(limit int) {
+	defer s.audit("SetFavoritesLimit", 0, limit).done(nil)
+
+	s.favoritesLimit = limit
+}
+
+func (s *Service) checkFavoriteName(op string, accountID int64, favoriteID string, name string) error {
+	if strings.TrimSpace(name) == "" {
+		return newError(op, ErrFavoriteNameEmpty, accountID, favoriteID, 0)
+	}
+	if strings.ContainsAny(name, ";\r\n") {
+		return newError(op, ErrInvalidFavoriteName, accountID, favoriteID, 0)
+	}
+
+	for _, favorite := range s.favorites {
+		if favorite.AccountID == accountID && favorite.ID != favoriteID && strings.EqualFold(favorite.Name, name) {
+			return newError(op, ErrFavoriteNameTaken, accountID, name, 0)
+		}
+	}
+
+	return nil
+}
+
+func (s *Service) checkFavoritesLimit(op string, accountID int64) error {
+	limit := s.favoritesLimit
+	if limit <= 0 {
+		limit = DefaultFavoritesLimit
+	}
+
+	count := 0
+	for _, favorite := range s.favorites {
+		if favorite.AccountID == accountID {
+			count++
+		}
+	}
+	if count >= limit {
+		return newError(op, ErrFavoritesLimit, accountID, "", 0)
+	}
+
+	return nil
+}
+
+func (s *Service) findOwnedFavorite(accountID int64, favoriteID string) (*types.Favorite, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	favorite, err := s.FindFavoriteByID(favoriteID)
+	if err != nil {
+		return nil, err
+	}
+
+	if favorite.AccountID != accountID {
+		return nil, newError("FindFavoriteByID", ErrFavoriteNotOwned, accountID, favoriteID, 0)
+	}
+
+	return favorite, nil
+}
+
+// AccountFavorites returns favorites of the account in their display order.
+func (s *Service) AccountFavorites(accountID int64) ([]types.Favorite, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	favorites := []types.Favorite{}
+	for _, favorite := range s.favorites {
+		if favorite.AccountID == accountID {
+			favorites = append(favorites, *favorite)
+		}
+	}
+
+	return favorites, nil
+}
+
+func (s *Service) UpdateFavorite(accountID int64, favoriteID string, name string, amount types.Money, category types.PaymentCategory) (_ *types.Favorite, err error) {
+	defer s.audit("UpdateFavorite", accountID, accountID, favoriteID, name, amount, category).done(&err)
+
+	favorite, err := s.findOwnedFavorite(accountID, favoriteID)
+	if err != nil {
+		return nil, err
+	}
+
+	if amount <= 0 {
+		return nil, newError("UpdateFavorite", ErrAmountMustBePositive, accountID, favoriteID, amount)
+	}
+
+	err = s.checkFavoriteName("UpdateFavorite", accountID, favoriteID, name)
+	if err != nil {
+		return nil, err
+	}
+	category, err = s.paymentCategory("UpdateFavorite", accountID, category)
+	if err != nil {
+		return nil, err
+	}
+
+	favorite.Name = name
+	favorite.Amount = amount
+	favorite.Category = category
+	return favorite, nil
+}
+
+// DeleteFavorite removes the favorite and cancels the schedules paying it.
+func (s *Service) DeleteFavorite(accountID int64, favoriteID string) (err error) {
+	defer s.audit("DeleteFavorite", accountID, accountID, favoriteID).done(&err)
+
+	_, err = s.findOwnedFavorite(accountID, favoriteID)
+	if err != nil {
+		return err
+	}
+
+	for i, favorite := range s.favorites {
+		if favorite.ID == favoriteID {
+			s.favorites = append(s.favorites[:i], s.favorites[i+1:]...)
+			break
+		}
+	}
+
+	for _, schedule := range s.schedules {
+		if schedule.FavoriteID == favoriteID {
+			schedule.Active = false
+		}
+	}
+
+	return nil
+}
+
+// MoveFavorite moves the favorite to position (starting from zero) among
+// the favorites of its account.
+func (s *Service) MoveFavorite(accountID int64, favoriteID string, position int) (err error) {
+	defer s.audit("MoveFavorite", accountID, accountID, favoriteID, position).done(&err)
+
+	favorite, err := s.findOwnedFavorite(accountID, favoriteID)
+	if err != nil {
+		return err
+	}
+
+	var indexes []int
+	var owned []*types.Favorite
+	for i, fav := range s.favorites {
+		if fav.AccountID != accountID {
+			continue
+		}
+		indexes = append(indexes, i)
+		if fav != favorite {
+			owned = append(owned, fav)
+		}
+	}
+
+	if position < 0 {
+		position = 0
+	}
+	if position > len(owned) {
+		position = len(owned)
+	}
+
+	owned = append(owned[:position], append([]*types.Favorite{favorite}, owned[position:]...)...)
+	for i, index := range indexes {
+		s.favorites[index] = owned[i]
+	}
+
+	return nil
+}
+
+// PayFromAccountFavorite is PayFromFavorite on behalf of accountID: it fails
+// with ErrFavoriteNotOwned if the favorite belongs to another account.
+func (s *Service) PayFromAccountFavorite(accountID int64, favoriteID string) (_ *types.Payment, err error) {
+	defer s.audit("PayFromAccountFavorite", accountID, accountID, favoriteID).done(&err)
+
+	_, err = s.findOwnedFavorite(accountID, favoriteID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.PayFromFavorite(favoriteID)
+}
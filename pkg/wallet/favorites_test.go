@@ -0,0 +1,267 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+func (s *testService) addFavorites(account *types.Account, names ...string) ([]*types.Favorite, error) {
+	payment, err := s.Pay(account.ID, 1_00, "auto")
+	if err != nil {
+		return nil, err
+	}
+
+	var favorites []*types.Favorite
+	for _, name := range names {
+		favorite, err := s.FavoritePayment(payment.ID, name)
+		if err != nil {
+			return nil, err
+		}
+		favorites = append(favorites, favorite)
+	}
+
+	return favorites, nil
+}
+
+func favoriteNames(favorites []types.Favorite) []string {
+	names := []string{}
+	for _, favorite := range favorites {
+		names = append(names, favorite.Name)
+	}
+
+	return names
+}
+
+func TestService_FavoritePayment_nameTaken(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.addFavorites(account, "Home", "home")
+	if !errors.Is(err, ErrFavoriteNameTaken) {
+		t.Errorf("FavoritePayment(): must return ErrFavoriteNameTaken, returned %v", err)
+	}
+}
+
+func TestService_FavoritePayment_invalidName(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, name := range []string{"rent;food", "rent\nfood"} {
+		_, err = s.addFavorites(account, name)
+		if !errors.Is(err, ErrInvalidFavoriteName) {
+			t.Errorf("FavoritePayment(%q): must return ErrInvalidFavoriteName, returned %v", name, err)
+		}
+	}
+
+	favorites, err := s.addFavorites(account, "rent")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.UpdateFavorite(account.ID, favorites[0].ID, "rent;food", 5_00, "food")
+	var walletErr *WalletError
+	if !errors.As(err, &walletErr) || !errors.Is(err, ErrInvalidFavoriteName) || walletErr.Op != "UpdateFavorite" {
+		t.Errorf("UpdateFavorite(): must return ErrInvalidFavoriteName, returned %v", err)
+	}
+}
+
+func TestService_FavoritePayment_limit(t *testing.T) {
+	s := newTestService()
+	s.SetFavoritesLimit(2)
+	account, err := s.addAccountWithBalance("+992000000001", 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.addFavorites(account, "a", "b", "c")
+	if !errors.Is(err, ErrFavoritesLimit) {
+		t.Errorf("FavoritePayment(): must return ErrFavoritesLimit, returned %v", err)
+	}
+}
+
+func TestService_UpdateFavorite(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	favorites, err := s.addFavorites(account, "a", "b")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.UpdateFavorite(account.ID, favorites[0].ID, "B", 5_00, "food")
+	if !errors.Is(err, ErrFavoriteNameTaken) {
+		t.Errorf("UpdateFavorite(): must return ErrFavoriteNameTaken, returned %v", err)
+	}
+
+	favorite, err := s.UpdateFavorite(account.ID, favorites[0].ID, "c", 5_00, "food")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if favorite.Name != "c" || favorite.Amount != 5_00 || favorite.Category != "food" {
+		t.Errorf("invalid result, actual: %v", favorite)
+	}
+
+	_, err = s.UpdateFavorite(account.ID, favorites[0].ID, "c", 5_00, "food;\nauto")
+	if !errors.Is(err, ErrInvalidCategory) {
+		t.Errorf("UpdateFavorite(): must return ErrInvalidCategory, returned %v", err)
+	}
+	err = s.addCategories()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	favorite, err = s.UpdateFavorite(account.ID, favorites[0].ID, "c", 5_00, "Cars")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if favorite.Category != "auto" {
+		t.Errorf("alias must be resolved, category: %v", favorite.Category)
+	}
+}
+
+func TestService_DeleteFavorite(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	favorites, err := s.addFavorites(account, "a", "b")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	schedule, err := s.SchedulePayment(favorites[0].ID, ScheduleOptions{Frequency: types.ScheduleDaily})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.DeleteFavorite(account.ID, favorites[0].ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.FindFavoriteByID(favorites[0].ID)
+	if !errors.Is(err, ErrFavoriteNotFound) {
+		t.Errorf("FindFavoriteByID(): must return ErrFavoriteNotFound, returned %v", err)
+	}
+
+	if schedule.Active {
+		t.Errorf("schedules of a deleted favorite must be cancelled")
+	}
+}
+
+func TestService_MoveFavorite(t *testing.T) {
+	s := newTestService()
+	first, err := s.addAccountWithBalance("+992000000001", 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	second, err := s.addAccountWithBalance("+992000000002", 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	favorites, err := s.addFavorites(first, "a", "b")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.addFavorites(second, "x")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	more, err := s.addFavorites(first, "c")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.MoveFavorite(first.ID, more[0].ID, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.MoveFavorite(first.ID, favorites[0].ID, 10)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	list, err := s.AccountFavorites(first.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	want := []string{"c", "b", "a"}
+	got := favoriteNames(list)
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("invalid order, expected: %v, actual: %v", want, got)
+	}
+
+	list, err = s.AccountFavorites(second.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(list) != 1 || list[0].Name != "x" {
+		t.Errorf("favorites of other accounts must be kept, actual: %v", list)
+	}
+}
+
+func TestService_PayFromAccountFavorite_notOwned(t *testing.T) {
+	s := newTestService()
+	first, err := s.addAccountWithBalance("+992000000001", 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	second, err := s.addAccountWithBalance("+992000000002", 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	favorites, err := s.addFavorites(first, "a")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.PayFromAccountFavorite(second.ID, favorites[0].ID)
+	if !errors.Is(err, ErrFavoriteNotOwned) {
+		t.Errorf("PayFromAccountFavorite(): must return ErrFavoriteNotOwned, returned %v", err)
+	}
+
+	_, err = s.PayFromAccountFavorite(first.ID, favorites[0].ID)
+	if err != nil {
+		t.Error(err)
+	}
+}
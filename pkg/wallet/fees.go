@@ -0,0 +1,141 @@
+package wallet
+
+import (
+	"errors"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+var ErrInvalidFeeRule = errors.New("invalid fee rule")
+
+// FeeRule describes the fee charged on top of a payment. Empty Category or
+// Tier match any value, MaxAmount and MaxFee equal to zero mean "no limit".
+// Percent is expressed in basis points: 150 is 1.5%.
+type FeeRule struct {
+	Category  types.PaymentCategory
+	Tier      types.AccountTier
+	MinAmount types.Money
+	MaxAmount types.Money
+	Flat      types.Money
+	Percent   int64
+	MinFee    types.Money
+	MaxFee    types.Money
+}
+
+func (r FeeRule) matches(tier types.AccountTier, amount types.Money, category types.PaymentCategory) bool {
+	if r.Category != "" && r.Category != category {
+		return false
+	}
+	if r.Tier != "" && r.Tier != tier {
+		return false
+	}
+	if amount < r.MinAmount {
+		return false
+	}
+	if r.MaxAmount != 0 && amount > r.MaxAmount {
+		return false
+	}
+
+	return true
+}
+
+func (r FeeRule) fee(amount types.Money) types.Money {
+	fee := r.Flat + types.Money(int64(amount)*r.Percent/10_000)
+	if fee < r.MinFee {
+		fee = r.MinFee
+	}
+	if r.MaxFee != 0 && fee > r.MaxFee {
+		fee = r.MaxFee
+	}
+
+	return fee
+}
+
+// AddFeeRule appends rule to the fee table. Rules are evaluated in the order
+// they were added and the first matching one is applied.
+func (s *Service) AddFeeRule(rule FeeRule) (err error) {
+	defer s.audit("AddFeeRule", 0, rule).done(&err)
+
+	if rule.MinAmount < 0 || rule.MaxAmount < 0 || rule.Flat < 0 || rule.Percent < 0 || rule.MinFee < 0 || rule.MaxFee < 0 {
+		return newError("AddFeeRule", ErrInvalidFeeRule, 0, "", 0)
+	}
+	if rule.MaxAmount != 0 && rule.MaxAmount < rule.MinAmount {
+		return newError("AddFeeRule", ErrInvalidFeeRule, 0, "", 0)
+	}
+	if rule.MaxFee != 0 && rule.MaxFee < rule.MinFee {
+		return newError("AddFeeRule", ErrInvalidFeeRule, 0, "", 0)
+	}
+
+	s.feeRules = append(s.feeRules, rule)
+	return nil
+}
+
+func (s *Service) FeeRules() []FeeRule {
+	return append([]FeeRule{}, s.feeRules...)
+}
+
+func (s *Service) ClearFeeRules() {
+	defer s.audit("ClearFeeRules", 0).done(nil)
+
+	s.feeRules = nil
+}
+
+func (s *Service) SetAccountTier(accountID int64, tier types.AccountTier) (err error) {
+	defer s.audit("SetAccountTier", accountID, accountID, tier).done(&err)
+
+	account, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+
+	account.Tier = tier
+	return nil
+}
+
+func (s *Service) CalculateFee(accountID int64, amount types.Money, category types.PaymentCategory) (types.Money, error) {
+	if amount <= 0 {
+		return 0, newError("CalculateFee", ErrAmountMustBePositive, accountID, "", amount)
+	}
+
+	account, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.feeFor(account, amount, category), nil
+}
+
+func (s *Service) feeFor(account *types.Account, amount types.Money, category types.PaymentCategory) types.Money {
+	for _, rule := range s.feeRules {
+		if rule.matches(account.Tier, amount, category) {
+			return rule.fee(amount)
+		}
+	}
+
+	return 0
+}
+
+// FeesCollected returns the sum of fees of all payments that weren't rejected.
+func (s *Service) FeesCollected() types.Money {
+	sum := types.Money(0)
+	for _, payment := range s.payments {
+		if payment.Status == types.PaymentStatusFail {
+			continue
+		}
+		sum += payment.Fee
+	}
+
+	return sum
+}
+
+func (s *Service) FeesByCategory() map[types.PaymentCategory]types.Money {
+	fees := make(map[types.PaymentCategory]types.Money)
+	for _, payment := range s.payments {
+		if payment.Status == types.PaymentStatusFail || payment.Fee == 0 {
+			continue
+		}
+		fees[payment.Category] += payment.Fee
+	}
+
+	return fees
+}
@@ -0,0 +1,183 @@
+package wallet
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+func TestService_CalculateFee(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.SetAccountTier(account.ID, "gold")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	rules := []FeeRule{
+		{Tier: "gold", Category: "auto"},
+		{Category: "auto", MaxAmount: 1_000_00, Flat: 10_00},
+		{Category: "auto", MinAmount: 1_000_01, Percent: 150, MinFee: 20_00, MaxFee: 100_00},
+		{Percent: 100},
+	}
+	for _, rule := range rules {
+		err = s.AddFeeRule(rule)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	tests := []struct {
+		tier     types.AccountTier
+		amount   types.Money
+		category types.PaymentCategory
+		want     types.Money
+	}{
+		{tier: "gold", amount: 5_000_00, category: "auto", want: 0},
+		{amount: 500_00, category: "auto", want: 10_00},
+		{amount: 1_100_00, category: "auto", want: 20_00},
+		{amount: 2_000_00, category: "auto", want: 30_00},
+		{amount: 50_000_00, category: "auto", want: 100_00},
+		{amount: 1_000_00, category: "food", want: 10_00},
+	}
+
+	for _, test := range tests {
+		account.Tier = test.tier
+		got, err := s.CalculateFee(account.ID, test.amount, test.category)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if got != test.want {
+			t.Errorf("CalculateFee(%v, %v, %v): invalid result, expected: %v, actual: %v", test.tier, test.amount, test.category, test.want, got)
+		}
+	}
+}
+
+func TestService_AddFeeRule_invalid(t *testing.T) {
+	s := newTestService()
+
+	err := s.AddFeeRule(FeeRule{MinFee: 10, MaxFee: 5})
+	if !errors.Is(err, ErrInvalidFeeRule) {
+		t.Errorf("AddFeeRule(): must return ErrInvalidFeeRule, returned %v", err)
+	}
+}
+
+func TestService_Pay_withFee(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 1_010_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.AddFeeRule(FeeRule{Category: "auto", Percent: 100})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	payment, err := s.Pay(account.ID, 1_000_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if payment.Amount != 1_000_00 || payment.Fee != 10_00 {
+		t.Errorf("invalid payment, expected amount 100000 and fee 1000, actual: %v", payment)
+	}
+
+	if account.Balance != 0 {
+		t.Errorf("invalid balance, expected: %v, actual: %v", 0, account.Balance)
+	}
+
+	_, err = s.Pay(account.ID, 1_000_00, "auto")
+	if !errors.Is(err, ErrNotEnoughBalance) {
+		t.Errorf("Pay(): must return ErrNotEnoughBalance, returned %v", err)
+	}
+}
+
+func TestService_Reject_refundsFee(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 10_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.AddFeeRule(FeeRule{Flat: 5_00})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	first, err := s.Pay(account.ID, 1_000_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(account.ID, 2_000_00, "food")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.Reject(first.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if account.Balance != 7_995_00 {
+		t.Errorf("invalid balance, expected: %v, actual: %v", types.Money(7_995_00), account.Balance)
+	}
+
+	if got := s.FeesCollected(); got != 5_00 {
+		t.Errorf("FeesCollected(): expected: %v, actual: %v", types.Money(5_00), got)
+	}
+
+	want := map[types.PaymentCategory]types.Money{"food": 5_00}
+	if got := s.FeesByCategory(); !reflect.DeepEqual(want, got) {
+		t.Errorf("FeesByCategory(): expected: %v, actual: %v", want, got)
+	}
+}
+
+func TestExportImport_fees(t *testing.T) {
+	s := newTestService()
+	s.accounts = append(s.accounts, &types.Account{ID: 1, Phone: "+992000000001", Balance: 100, Tier: "gold"})
+	s.payments = append(s.payments,
+		&types.Payment{ID: "a", AccountID: 1, Amount: 1_000, Category: "auto", Status: types.PaymentStatusOk, Fee: 15},
+		&types.Payment{ID: "b", AccountID: 1, Amount: 2_000, Category: "food", Status: types.PaymentStatusOk},
+	)
+
+	dir := t.TempDir()
+	err := s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !reflect.DeepEqual(s.accounts, imported.accounts) {
+		t.Errorf("invalid accounts, expected: %v, actual: %v", s.accounts, imported.accounts)
+	}
+	if !reflect.DeepEqual(s.payments, imported.payments) {
+		t.Errorf("invalid payments, expected: %v, actual: %v", s.payments, imported.payments)
+	}
+}
@@ -0,0 +1,469 @@
+package wallet
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+var ErrInvalidTemplate = errors.New("file name template must contain {seq}")
+var ErrChecksumMismatch = errors.New("history file checksum mismatch")
+var ErrInvalidHistoryOptions = errors.New("invalid history options")
+
+const DefaultHistoryTemplate = "payments{seq}.dump"
+const DefaultHistoryIndex = "index.dump"
+
+// HistoryOptions controls how WriteHistory splits payments into files. A
+// file is rotated when it reaches Records payments or MaxBytes bytes
+// (before compression), zero means no limit. Template may use {account},
+// {date} (Date as YYYYMMDD) and {seq} (file number starting from 1, must be
+// present). With Gzip files are compressed and get a ".gz" suffix. The
+// index file lists every file with its record range, count and SHA-256.
+type HistoryOptions struct {
+	Records   int
+	MaxBytes  int64
+	Template  string
+	AccountID int64
+	Date      time.Time
+	Gzip      bool
+	Index     string
+}
+
+type historyChunk struct {
+	temporary string
+	name      string
+	first     int
+	last      int
+	checksum  string
+}
+
+// historyWriter writes payments into dump files, keeping only the current
+// file open. Files are written under temporary names and get their final
+// ones from commit.
+type historyWriter struct {
+	dir     string
+	options HistoryOptions
+	legacy  bool
+	file    *os.File
+	hash    hash.Hash
+	gzip    *gzip.Writer
+	writer  *bufio.Writer
+	count   int
+	bytes   int64
+	written int
+	chunks  []*historyChunk
+}
+
+func (w *historyWriter) full(line string) bool {
+	if w.file == nil {
+		return true
+	}
+	if w.options.Records > 0 && w.count >= w.options.Records {
+		return true
+	}
+	if w.options.MaxBytes > 0 && w.count > 0 && w.bytes+int64(len(line))+1 > w.options.MaxBytes {
+		return true
+	}
+
+	return false
+}
+
+func (w *historyWriter) write(payment *types.Payment) error {
+	line := paymentRecord(payment)
+	if w.full(line) {
+		err := w.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	if w.count != 0 {
+		line = "\n" + line
+	}
+
+	_, err := w.writer.WriteString(line)
+	if err != nil {
+		return err
+	}
+
+	w.count++
+	w.written++
+	w.bytes += int64(len(line))
+	w.chunks[len(w.chunks)-1].last = w.written
+	return nil
+}
+
+func (w *historyWriter) rotate() error {
+	err := w.close()
+	if err != nil {
+		return err
+	}
+
+	chunk := &historyChunk{
+		temporary: filepath.Join(w.dir, "payments"+strconv.Itoa(len(w.chunks)+1)+".dump.tmp"),
+		first:     w.written + 1,
+	}
+	w.chunks = append(w.chunks, chunk)
+
+	w.file, err = os.Create(chunk.temporary)
+	if err != nil {
+		return err
+	}
+
+	w.hash = sha256.New()
+	var out io.Writer = io.MultiWriter(w.file, w.hash)
+	if w.options.Gzip {
+		w.gzip = gzip.NewWriter(out)
+		out = w.gzip
+	}
+
+	w.writer = bufio.NewWriter(out)
+	w.count = 0
+	w.bytes = 0
+	return nil
+}
+
+func (w *historyWriter) close() error {
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.writer.Flush()
+	if err == nil && w.gzip != nil {
+		err = w.gzip.Close()
+	}
+	cerr := w.file.Close()
+	w.file = nil
+	w.gzip = nil
+	if err != nil {
+		return err
+	}
+	if cerr != nil {
+		return cerr
+	}
+
+	w.chunks[len(w.chunks)-1].checksum = hex.EncodeToString(w.hash.Sum(nil))
+	return nil
+}
+
+func (w *historyWriter) name(seq int) string {
+	if w.legacy {
+		if len(w.chunks) == 1 {
+			return "payments.dump"
+		}
+		return "payments" + strconv.Itoa(seq) + ".dump"
+	}
+
+	template := w.options.Template
+	if template == "" {
+		template = DefaultHistoryTemplate
+	}
+
+	name := strings.NewReplacer(
+		"{account}", strconv.FormatInt(w.options.AccountID, 10),
+		"{date}", w.options.Date.Format("20060102"),
+		"{seq}", strconv.Itoa(seq),
+	).Replace(template)
+	if w.options.Gzip {
+		name += ".gz"
+	}
+
+	return name
+}
+
+// commit gives the written files their final names and writes the index.
+// Legacy histories are named payments.dump for a single file and
+// payments1.dump, payments2.dump, ... otherwise and have no index.
+func (w *historyWriter) commit() error {
+	err := w.close()
+	if err != nil {
+		return err
+	}
+
+	var records [][]string
+	for i, chunk := range w.chunks {
+		chunk.name = w.name(i + 1)
+		records = append(records, []string{
+			chunk.name,
+			strconv.Itoa(chunk.first),
+			strconv.Itoa(chunk.last),
+			strconv.Itoa(chunk.last - chunk.first + 1),
+			chunk.checksum,
+		})
+	}
+
+	for _, chunk := range w.chunks {
+		err = os.Rename(chunk.temporary, filepath.Join(w.dir, chunk.name))
+		if err != nil {
+			return err
+		}
+	}
+
+	if w.legacy {
+		return nil
+	}
+
+	index := w.options.Index
+	if index == "" {
+		index = DefaultHistoryIndex
+	}
+
+	// The index is written even without payments, so that ReadHistory
+	// tells an empty history from a missing one.
+	file := recordsDump(index, records)
+	file.keep = true
+	return writeDumps(context.Background(), w.dir, []dumpFile{file}, nil)
+}
+
+func (w *historyWriter) abort() {
+	err := w.close()
+	if err != nil {
+		log.Print(err)
+	}
+
+	for _, chunk := range w.chunks {
+		err = os.Remove(chunk.temporary)
+		if err != nil && !os.IsNotExist(err) {
+			log.Print(err)
+		}
+	}
+}
+
+func writeHistory(ctx context.Context, next func() (*types.Payment, bool), total int, dir string, writer *historyWriter, progress ProgressFunc) error {
+	return ioError("WriteHistory", dir, writeHistoryFiles(ctx, next, total, dir, writer, progress))
+}
+
+func writeHistoryFiles(ctx context.Context, next func() (*types.Payment, bool), total int, dir string, writer *historyWriter, progress ProgressFunc) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	writer.dir = abs
+
+	tracker := newProgressTracker(progress, total)
+	for i := 0; ; i++ {
+		if i%progressStep == 0 {
+			err = ctx.Err()
+			if err != nil {
+				writer.abort()
+				return err
+			}
+		}
+
+		payment, ok := next()
+		if !ok {
+			break
+		}
+
+		err = writer.write(payment)
+		if err != nil {
+			writer.abort()
+			return err
+		}
+		tracker.add(1)
+	}
+
+	err = ctx.Err()
+	if err != nil {
+		writer.abort()
+		return err
+	}
+
+	err = writer.commit()
+	if err != nil {
+		writer.abort()
+		return err
+	}
+
+	return nil
+}
+
+func iteratorSource(it *PaymentIterator) func() (*types.Payment, bool) {
+	return func() (*types.Payment, bool) {
+		if !it.Next() {
+			return nil, false
+		}
+		payment := it.Payment()
+		return &payment, true
+	}
+}
+
+// StreamHistoryToFiles is HistoryToFilesContext for payments coming from an
+// iterator: memory use doesn't depend on the number of payments.
+func (s *Service) StreamHistoryToFiles(ctx context.Context, it *PaymentIterator, dir string, records int, progress ProgressFunc) error {
+	if records < 1 {
+		return newError("StreamHistoryToFiles", ErrInvalidRecordsCount, 0, "", 0)
+	}
+
+	total := 0
+	if progress != nil {
+		total = it.Count()
+	}
+
+	writer := &historyWriter{options: HistoryOptions{Records: records}, legacy: true}
+	return writeHistory(ctx, iteratorSource(it), total, dir, writer, progress)
+}
+
+// AccountHistoryToFiles writes the account's payments to files like
+// HistoryToFiles without collecting them into a slice first.
+func (s *Service) AccountHistoryToFiles(accountID int64, dir string, records int) error {
+	it, err := s.AccountPayments(accountID)
+	if err != nil {
+		return err
+	}
+
+	return s.StreamHistoryToFiles(context.Background(), it, dir, records, nil)
+}
+
+// WriteHistory writes payments from the iterator to files in dir split and
+// named as options say, plus an index file that ReadHistory uses to put the
+// history back together.
+func (s *Service) WriteHistory(ctx context.Context, it *PaymentIterator, dir string, options HistoryOptions, progress ProgressFunc) error {
+	if options.Records < 0 || options.MaxBytes < 0 {
+		return newError("WriteHistory", ErrInvalidHistoryOptions, options.AccountID, "", 0)
+	}
+	if options.Template != "" && !strings.Contains(options.Template, "{seq}") {
+		return newError("WriteHistory", ErrInvalidTemplate, options.AccountID, options.Template, 0)
+	}
+	if options.Date.IsZero() {
+		options.Date = s.now()
+	}
+
+	total := 0
+	if progress != nil {
+		total = it.Count()
+	}
+
+	writer := &historyWriter{options: options}
+	return writeHistory(ctx, iteratorSource(it), total, dir, writer, progress)
+}
+
+// ReadHistory reads files listed in the index written by WriteHistory and
+// calls fn for every payment in order until fn returns false. Checksums and
+// record counts are verified.
+func ReadHistory(index string, fn func(payment types.Payment) bool) error {
+	return ioError("ReadHistory", index, readHistory(index, fn))
+}
+
+func readHistory(index string, fn func(payment types.Payment) bool) error {
+	records, err := readDump(index)
+	if err != nil {
+		return err
+	}
+	if records == nil {
+		return os.ErrNotExist
+	}
+
+	dir := filepath.Dir(index)
+	for i, record := range records {
+		if len(record) < 5 {
+			return dumpError("ReadHistory", index, i, ErrInvalidRecord)
+		}
+
+		count, err := strconv.Atoi(record[3])
+		if err != nil {
+			return dumpError("ReadHistory", index, i, err)
+		}
+
+		next, err := readHistoryFile(filepath.Join(dir, record[0]), count, record[4], fn)
+		if err != nil {
+			return err
+		}
+		if !next {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func readHistoryFile(path string, count int, checksum string, fn func(payment types.Payment) bool) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		cerr := file.Close()
+		if cerr != nil {
+			log.Print(cerr)
+		}
+	}()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return false, err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != checksum {
+		return false, newError("ReadHistory", ErrChecksumMismatch, 0, path, 0)
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return false, err
+	}
+
+	var in io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return false, err
+		}
+		defer gz.Close()
+		in = gz
+	}
+
+	read := 0
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		if scanner.Text() == "" {
+			continue
+		}
+
+		payment, err := parsePayment(strings.Split(scanner.Text(), ";"))
+		if err != nil {
+			return false, dumpError("ReadHistory", path, read, err)
+		}
+
+		read++
+		if !fn(*payment) {
+			return false, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+
+	if read != count {
+		return false, newError("ReadHistory", ErrInvalidRecord, 0, path, 0)
+	}
+
+	return true, nil
+}
+
+// ReadHistoryFiles reads the whole history listed in the index into a slice.
+func ReadHistoryFiles(index string) ([]types.Payment, error) {
+	payments := []types.Payment{}
+	err := ReadHistory(index, func(payment types.Payment) bool {
+		payments = append(payments, payment)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return payments, nil
+}
@@ -0,0 +1,165 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+func TestService_WriteHistory(t *testing.T) {
+	s := newBenchmarkService(100)
+	s.accounts = append(s.accounts, &types.Account{ID: 3, Phone: "+992000000003"})
+
+	dir := t.TempDir()
+	it, err := s.AccountPayments(3)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.WriteHistory(context.Background(), it, dir, HistoryOptions{
+		MaxBytes:  50,
+		Template:  "history-{account}-{date}-{seq}.dump",
+		AccountID: 3,
+		Date:      time.Date(2021, 3, 15, 0, 0, 0, 0, time.UTC),
+		Gzip:      true,
+	}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, name := range []string{"history-3-20210315-1.dump.gz", "history-3-20210315-4.dump.gz", DefaultHistoryIndex} {
+		_, err = os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	_, err = os.Stat(filepath.Join(dir, "history-3-20210315-5.dump.gz"))
+	if !os.IsNotExist(err) {
+		t.Errorf("file beyond the last chunk must not exist, stat returned %v", err)
+	}
+
+	payments, err := ReadHistoryFiles(filepath.Join(dir, DefaultHistoryIndex))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	want := []types.Payment{}
+	it, _ = s.AccountPayments(3)
+	for it.Next() {
+		want = append(want, it.Payment())
+	}
+	if !reflect.DeepEqual(want, payments) {
+		t.Errorf("invalid result, expected: %v, actual: %v", want, payments)
+	}
+}
+
+func TestService_WriteHistory_empty(t *testing.T) {
+	s := newTestService()
+	account, err := s.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	it, err := s.AccountPayments(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.WriteHistory(context.Background(), it, dir, HistoryOptions{Records: 10}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	payments, err := ReadHistoryFiles(filepath.Join(dir, DefaultHistoryIndex))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(payments) != 0 {
+		t.Errorf("invalid result, expected no payments, actual: %v", payments)
+	}
+
+	_, err = ReadHistoryFiles(filepath.Join(t.TempDir(), DefaultHistoryIndex))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ReadHistoryFiles(): must return os.ErrNotExist for a missing index, returned %v", err)
+	}
+}
+
+func TestService_WriteHistory_records(t *testing.T) {
+	s := newBenchmarkService(10)
+
+	dir := t.TempDir()
+	err := s.WriteHistory(context.Background(), s.Payments(), dir, HistoryOptions{Records: 4}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	records, err := readDump(filepath.Join(dir, DefaultHistoryIndex))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var ranges [][]string
+	for _, record := range records {
+		ranges = append(ranges, record[:4])
+	}
+	want := [][]string{
+		{"payments1.dump", "1", "4", "4"},
+		{"payments2.dump", "5", "8", "4"},
+		{"payments3.dump", "9", "10", "2"},
+	}
+	if !reflect.DeepEqual(want, ranges) {
+		t.Errorf("invalid index, expected: %v, actual: %v", want, ranges)
+	}
+}
+
+func TestReadHistory_checksumMismatch(t *testing.T) {
+	s := newBenchmarkService(10)
+
+	dir := t.TempDir()
+	err := s.WriteHistory(context.Background(), s.Payments(), dir, HistoryOptions{Records: 5}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "payments2.dump"), []byte("5;1;6;fun;OK"), 0666)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = ReadHistoryFiles(filepath.Join(dir, DefaultHistoryIndex))
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("ReadHistoryFiles(): must return ErrChecksumMismatch, returned %v", err)
+	}
+}
+
+func TestService_WriteHistory_invalidTemplate(t *testing.T) {
+	s := newBenchmarkService(10)
+
+	err := s.WriteHistory(context.Background(), s.Payments(), t.TempDir(), HistoryOptions{Template: "payments.dump"}, nil)
+	if !errors.Is(err, ErrInvalidTemplate) {
+		t.Errorf("WriteHistory(): must return ErrInvalidTemplate, returned %v", err)
+	}
+
+	err = s.WriteHistory(context.Background(), s.Payments(), t.TempDir(), HistoryOptions{MaxBytes: -1}, nil)
+	if !errors.Is(err, ErrInvalidHistoryOptions) {
+		t.Errorf("WriteHistory(): must return ErrInvalidHistoryOptions, returned %v", err)
+	}
+}
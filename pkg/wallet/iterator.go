@@ -0,0 +1,133 @@
+package wallet
+
+import (
+	"context"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+type PaymentFilter func(payment *types.Payment) bool
+
+func ByAccount(accountID int64) PaymentFilter {
+	return func(payment *types.Payment) bool {
+		return payment.AccountID == accountID
+	}
+}
+
+func ByCategory(category types.PaymentCategory) PaymentFilter {
+	return func(payment *types.Payment) bool {
+		return payment.Category == category
+	}
+}
+
+func ByStatus(status types.PaymentStatus) PaymentFilter {
+	return func(payment *types.Payment) bool {
+		return payment.Status == status
+	}
+}
+
+// PaymentIterator walks over the payments that existed when it was created
+// and match all of its filters, without copying them into a slice:
+//
+//	it := s.Payments(wallet.ByCategory("food"))
+//	for it.Next() {
+//		payment := it.Payment()
+//		...
+//	}
+type PaymentIterator struct {
+	payments []*types.Payment
+	filters  []PaymentFilter
+	index    int
+	current  types.Payment
+}
+
+func (s *Service) Payments(filters ...PaymentFilter) *PaymentIterator {
+	return &PaymentIterator{payments: s.payments, filters: filters, index: -1}
+}
+
+func (s *Service) AccountPayments(accountID int64, filters ...PaymentFilter) (*PaymentIterator, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Payments(append([]PaymentFilter{ByAccount(accountID)}, filters...)...), nil
+}
+
+// Next advances the iterator to the next matching payment and reports
+// whether there was one.
+func (it *PaymentIterator) Next() bool {
+	for it.index+1 < len(it.payments) {
+		it.index++
+		payment := it.payments[it.index]
+		if it.matches(payment) {
+			it.current = *payment
+			return true
+		}
+	}
+
+	it.index = len(it.payments)
+	return false
+}
+
+func (it *PaymentIterator) matches(payment *types.Payment) bool {
+	for _, filter := range it.filters {
+		if !filter(payment) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Payment returns the payment the iterator is at.
+func (it *PaymentIterator) Payment() types.Payment {
+	return it.current
+}
+
+// Count returns how many payments the iterator would yield from the start,
+// without moving it.
+func (it *PaymentIterator) Count() int {
+	count := 0
+	for _, payment := range it.payments {
+		if it.matches(payment) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// ForEachPayment calls fn for every matching payment until fn returns false.
+func (s *Service) ForEachPayment(fn func(payment types.Payment) bool, filters ...PaymentFilter) {
+	it := s.Payments(filters...)
+	for it.Next() {
+		if !fn(it.Payment()) {
+			return
+		}
+	}
+}
+
+// StreamPayments sends matching payments to the returned channel and closes
+// it when they are over or ctx is done. The service must not be changed
+// until the channel is closed.
+func (s *Service) StreamPayments(ctx context.Context, buffer int, filters ...PaymentFilter) <-chan types.Payment {
+	if buffer < 0 {
+		buffer = 0
+	}
+
+	stream := make(chan types.Payment, buffer)
+	it := s.Payments(filters...)
+	go func() {
+		defer close(stream)
+		for it.Next() {
+			select {
+			case stream <- it.Payment():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return stream
+}
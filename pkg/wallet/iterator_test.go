@@ -0,0 +1,111 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+func TestService_Payments(t *testing.T) {
+	s := newTestService()
+	s.payments = append(s.payments, analyticsTestPayments()...)
+
+	var ids []string
+	it := s.Payments(ByAccount(1), ByCategory("food"))
+	for it.Next() {
+		ids = append(ids, it.Payment().ID)
+	}
+
+	want := []string{"C", "D", "F"}
+	if !reflect.DeepEqual(want, ids) {
+		t.Errorf("invalid result, expected: %v, actual: %v", want, ids)
+	}
+
+	if it.Next() {
+		t.Errorf("exhausted iterator must stay exhausted")
+	}
+	if count := s.Payments(ByStatus(types.PaymentStatusOk)).Count(); count != 5 {
+		t.Errorf("invalid count, expected: %v, actual: %v", 5, count)
+	}
+}
+
+func TestService_AccountPayments_notFound(t *testing.T) {
+	s := newTestService()
+
+	_, err := s.AccountPayments(1)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("AccountPayments(): must return ErrAccountNotFound, returned %v", err)
+	}
+}
+
+func TestService_ForEachPayment(t *testing.T) {
+	s := newTestService()
+	s.payments = append(s.payments, analyticsTestPayments()...)
+
+	var ids []string
+	s.ForEachPayment(func(payment types.Payment) bool {
+		ids = append(ids, payment.ID)
+		return len(ids) < 2
+	}, ByCategory("auto"))
+
+	want := []string{"A", "B"}
+	if !reflect.DeepEqual(want, ids) {
+		t.Errorf("invalid result, expected: %v, actual: %v", want, ids)
+	}
+}
+
+func TestService_StreamPayments(t *testing.T) {
+	s := newBenchmarkService(1_000)
+
+	count := 0
+	for range s.StreamPayments(context.Background(), 16, ByCategory("food")) {
+		count++
+	}
+	if count != 333 {
+		t.Errorf("invalid count, expected: %v, actual: %v", 333, count)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := s.StreamPayments(ctx, 0)
+	<-stream
+	cancel()
+	for range stream {
+	}
+}
+
+func TestService_AccountHistoryToFiles(t *testing.T) {
+	s := newBenchmarkService(100)
+	s.accounts = append(s.accounts, &types.Account{ID: 3, Phone: "+992000000003"})
+
+	dir := t.TempDir()
+	err := s.AccountHistoryToFiles(3, dir, 4)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(files) != 3 {
+		t.Errorf("invalid number of files, expected: %v, actual: %v", 3, len(files))
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "payments3.dump"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	want := "82;3;83;food;OK\n92;3;93;fun;OK"
+	if string(data) != want {
+		t.Errorf("invalid content, expected: %q, actual: %q", want, data)
+	}
+}
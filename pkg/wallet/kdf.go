@@ -0,0 +1,94 @@
+package wallet
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+const kdfName = "pbkdf2-sha256"
+const kdfSaltSize = 16
+const kdfKeySize = 32
+
+// kdfIterations is the PBKDF2 work factor for new credentials, stored
+// credentials keep the one they were hashed with.
+var kdfIterations = 310_000
+
+// pbkdf2 derives a key of keySize bytes from secret and salt as RFC 8018
+// describes, with HMAC-SHA256 as the pseudorandom function.
+func pbkdf2(secret []byte, salt []byte, iterations int, keySize int) []byte {
+	prf := hmac.New(sha256.New, secret)
+	size := prf.Size()
+	blocks := (keySize + size - 1) / size
+
+	var index [4]byte
+	key := make([]byte, 0, blocks*size)
+	u := make([]byte, size)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(index[:], uint32(block))
+		prf.Write(index[:])
+		key = prf.Sum(key)
+
+		t := key[len(key)-size:]
+		copy(u, t)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range u {
+				t[j] ^= u[j]
+			}
+		}
+	}
+
+	return key[:keySize]
+}
+
+// hashCredential returns the encoded hash of a secret with a random salt:
+// "pbkdf2-sha256$iterations$salt$key" with the salt and key in hex.
+func hashCredential(secret string) (string, error) {
+	salt := make([]byte, kdfSaltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := pbkdf2([]byte(secret), salt, kdfIterations, kdfKeySize)
+	return strings.Join([]string{
+		kdfName,
+		strconv.Itoa(kdfIterations),
+		hex.EncodeToString(salt),
+		hex.EncodeToString(key),
+	}, "$"), nil
+}
+
+// verifyCredential reports whether secret matches the encoded hash.
+func verifyCredential(credential string, secret string) bool {
+	parts := strings.Split(credential, "$")
+	if len(parts) != 4 || parts[0] != kdfName {
+		return false
+	}
+
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	salt, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	expected, err := hex.DecodeString(parts[3])
+	if err != nil || len(expected) == 0 {
+		return false
+	}
+
+	key := pbkdf2([]byte(secret), salt, iterations, len(expected))
+	return subtle.ConstantTimeCompare(key, expected) == 1
+}
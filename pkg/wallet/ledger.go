@@ -0,0 +1,87 @@
+package wallet
+
+import (
+	"strconv"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+// record adds an entry to the ledger. Every change of an account balance
+// must go through it, statements are built from the ledger.
+func (s *Service) record(accountID int64, kind types.EntryKind, amount types.Money, fee types.Money, reference string) {
+	s.ledger = append(s.ledger, &types.Entry{
+		AccountID: accountID,
+		Kind:      kind,
+		Amount:    amount,
+		Fee:       fee,
+		Reference: reference,
+		Time:      s.now(),
+	})
+	s.track(accountID, amount)
+}
+
+// AccountEntries returns the account's ledger entries in the order they
+// were recorded.
+func (s *Service) AccountEntries(accountID int64) ([]types.Entry, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []types.Entry{}
+	for _, entry := range s.ledger {
+		if entry.AccountID == accountID {
+			entries = append(entries, *entry)
+		}
+	}
+
+	return entries, nil
+}
+
+func (s *Service) ledgerRecords() [][]string {
+	var records [][]string
+	for _, entry := range s.ledger {
+		records = append(records, []string{
+			strconv.FormatInt(entry.AccountID, 10),
+			string(entry.Kind),
+			strconv.FormatInt(int64(entry.Amount), 10),
+			strconv.FormatInt(int64(entry.Fee), 10),
+			entry.Reference,
+			formatTime(entry.Time),
+		})
+	}
+
+	return records
+}
+
+func parseEntry(record []string) (*types.Entry, error) {
+	if len(record) < 6 {
+		return nil, ErrInvalidRecord
+	}
+
+	accountID, err := strconv.ParseInt(record[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := strconv.ParseInt(record[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	fee, err := strconv.ParseInt(record[3], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	created, err := parseTime(record[5])
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.Entry{
+		AccountID: accountID,
+		Kind:      types.EntryKind(record[1]),
+		Amount:    types.Money(amount),
+		Fee:       types.Money(fee),
+		Reference: record[4],
+		Time:      created,
+	}, nil
+}
@@ -0,0 +1,103 @@
+package wallet
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+func TestService_AccountEntries(t *testing.T) {
+	s := newTestService()
+	s.SetClock(&testClock{now: time.Date(2021, 1, 1, 9, 0, 0, 0, time.UTC)})
+
+	account, err := s.addAccountWithBalance("+992000000001", 100)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	payment, err := s.Pay(account.ID, 40, "food")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	entries, err := s.AccountEntries(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var amounts []types.Money
+	var balance types.Money
+	for _, entry := range entries {
+		amounts = append(amounts, entry.Amount)
+		balance += entry.Amount
+	}
+
+	want := []types.Money{100, -40, 40}
+	if !reflect.DeepEqual(want, amounts) {
+		t.Errorf("invalid result, expected: %v, actual: %v", want, amounts)
+	}
+	if balance != account.Balance {
+		t.Errorf("ledger doesn't match balance, expected: %v, actual: %v", account.Balance, balance)
+	}
+
+	_, err = s.AccountEntries(account.ID + 1)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("AccountEntries(): must return ErrAccountNotFound, returned %v", err)
+	}
+}
+
+func TestService_ExportImport_ledger(t *testing.T) {
+	s := newTestService()
+	s.SetClock(&testClock{now: time.Date(2021, 1, 1, 9, 0, 0, 0, time.UTC)})
+
+	account, err := s.addAccountWithBalance("+992000000001", 100)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(account.ID, 40, "food")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(imported.ledger) != len(s.ledger) {
+		t.Errorf("invalid number of entries, expected: %v, actual: %v", len(s.ledger), len(imported.ledger))
+		return
+	}
+	for i, entry := range imported.ledger {
+		want := *s.ledger[i]
+		if !entry.Time.Equal(want.Time) {
+			t.Errorf("invalid time, expected: %v, actual: %v", want.Time, entry.Time)
+		}
+		entry.Time = want.Time
+		if !reflect.DeepEqual(want, *entry) {
+			t.Errorf("invalid result, expected: %v, actual: %v", want, *entry)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package wallet
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+// Publisher publishes events to a broker or another system. An event may be
+// published more than once, for example when the process stops after
+// publishing it but before the outbox is exported, so consumers must drop
+// events whose ID they have already seen.
+type Publisher interface {
+	Publish(ctx context.Context, event types.Event) error
+}
+
+// PublisherFunc is a function used as a Publisher.
+type PublisherFunc func(ctx context.Context, event types.Event) error
+
+func (f PublisherFunc) Publish(ctx context.Context, event types.Event) error {
+	return f(ctx, event)
+}
+
+// OutboxRelay publishes outbox messages in the background.
+type OutboxRelay struct {
+	s         *Service
+	publisher Publisher
+	interval  time.Duration
+	sub       *Subscription
+	wake      chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	closed    sync.Once
+}
+
+// EnableOutbox makes the service save every event to the outbox. Messages
+// are part of the service state and an export commits all dumps at once, so
+// a change is saved together with its events. Messages stay there until
+// RelayOutbox publishes them.
+func (s *Service) EnableOutbox() {
+	s.eventsMu.Lock()
+	s.outboxEnabled = true
+	s.eventsMu.Unlock()
+}
+
+// Outbox returns the messages waiting to be published, oldest first.
+func (s *Service) Outbox() []types.OutboxMessage {
+	s.eventsMu.Lock()
+	defer s.eventsMu.Unlock()
+
+	messages := make([]types.OutboxMessage, 0, len(s.outbox))
+	for _, message := range s.outbox {
+		messages = append(messages, *message)
+	}
+
+	return messages
+}
+
+// RelayOutbox publishes outbox messages in order and removes the published
+// ones. It stops at the first failure, so that events are published in the
+// order they happened, and returns the number of published messages.
+func (s *Service) RelayOutbox(ctx context.Context, publisher Publisher) (int, error) {
+	published := 0
+	for _, message := range s.Outbox() {
+		err := ctx.Err()
+		if err != nil {
+			return published, err
+		}
+
+		err = publisher.Publish(ctx, message.Event)
+
+		s.eventsMu.Lock()
+		for i, item := range s.outbox {
+			if item.Event.ID != message.Event.ID {
+				continue
+			}
+			if err == nil {
+				s.outbox = append(s.outbox[:i], s.outbox[i+1:]...)
+			} else {
+				item.Attempts++
+				item.Error = err.Error()
+			}
+			break
+		}
+		s.eventsMu.Unlock()
+
+		if err != nil {
+			return published, newError("RelayOutbox", err, message.Event.AccountID, message.Event.ID, 0)
+		}
+		published++
+	}
+
+	return published, nil
+}
+
+// StartOutboxRelay enables the outbox and relays it to publisher right
+// after new events and every interval, which is also how long it waits
+// after a failure.
+func (s *Service) StartOutboxRelay(publisher Publisher, interval time.Duration) *OutboxRelay {
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	s.EnableOutbox()
+	relay := &OutboxRelay{
+		s:         s,
+		publisher: publisher,
+		interval:  interval,
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	relay.sub = s.Subscribe(func(event types.Event) error {
+		select {
+		case relay.wake <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+
+	go relay.run()
+	return relay
+}
+
+func (r *OutboxRelay) run() {
+	defer close(r.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-r.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		_, err := r.s.RelayOutbox(ctx, r.publisher)
+		if err != nil && ctx.Err() == nil {
+			log.Print(err)
+		}
+
+		select {
+		case <-r.stop:
+			return
+		case <-r.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stops the relay. Messages that weren't published stay in the
+// outbox. Calls after the first one only wait for it.
+func (r *OutboxRelay) Close() {
+	r.closed.Do(func() {
+		r.sub.Close()
+		close(r.stop)
+	})
+	<-r.done
+}
+
+// importOutbox adds the imported messages to the outbox, replacing ones of
+// the same events. Local messages that weren't published yet are kept, so
+// an import can't lose them. s.eventsMu must be held.
+func (s *Service) importOutbox(imported []*types.OutboxMessage) {
+	for _, message := range imported {
+		found := false
+		for i, item := range s.outbox {
+			if item.Event.ID == message.Event.ID {
+				s.outbox[i] = message
+				found = true
+				break
+			}
+		}
+		if !found {
+			s.outbox = append(s.outbox, message)
+		}
+	}
+	sort.SliceStable(s.outbox, func(i, j int) bool {
+		return s.outbox[i].Event.Seq < s.outbox[j].Event.Seq
+	})
+}
+
+func (s *Service) outboxRecords() [][]string {
+	s.eventsMu.Lock()
+	defer s.eventsMu.Unlock()
+
+	var records [][]string
+	for _, message := range s.outbox {
+		records = append(records, append(eventFields(message.Event),
+			strconv.Itoa(message.Attempts),
+			url.QueryEscape(message.Error),
+		))
+	}
+
+	return records
+}
+
+func parseOutboxMessage(record []string) (*types.OutboxMessage, error) {
+	if len(record) < eventFieldsCount+2 {
+		return nil, ErrInvalidRecord
+	}
+
+	var err error
+	message := &types.OutboxMessage{}
+	if message.Event, err = parseEventFields(record); err != nil {
+		return nil, err
+	}
+	if message.Attempts, err = strconv.Atoi(record[eventFieldsCount]); err != nil {
+		return nil, err
+	}
+	if message.Error, err = url.QueryUnescape(record[eventFieldsCount+1]); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
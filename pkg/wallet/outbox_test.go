@@ -0,0 +1,343 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+func TestService_Outbox(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(s.Outbox()) != 0 {
+		t.Errorf("outbox must be disabled by default: %v", s.Outbox())
+	}
+
+	s.EnableOutbox()
+	payment, err := s.Pay(account.ID, 100, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var ids []string
+	for _, message := range s.Outbox() {
+		ids = append(ids, message.Event.ID)
+	}
+	var importedIDs []string
+	for _, message := range imported.Outbox() {
+		importedIDs = append(importedIDs, message.Event.ID)
+	}
+	if len(ids) != 2 || !reflect.DeepEqual(ids, importedIDs) {
+		t.Errorf("invalid result, expected: %v, actual: %v", ids, importedIDs)
+	}
+
+	err = imported.Deposit(account.ID, 100)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	outbox := imported.Outbox()
+	if len(outbox) != 3 || outbox[2].Event.Seq != outbox[1].Event.Seq+1 {
+		t.Errorf("imported service must keep adding events to the outbox: %v", outbox)
+	}
+}
+
+func TestService_Import_keepsOutbox(t *testing.T) {
+	s := newTestService()
+	s.EnableOutbox()
+	account, err := s.addAccountWithBalance("+992000000001", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	exported := len(s.Outbox())
+	err = s.Deposit(account.ID, 100)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Import(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	outbox := s.Outbox()
+	if len(outbox) != exported+1 || outbox[exported].Event.Type != types.EventDeposited {
+		t.Errorf("unpublished local messages must be kept: %v", outbox)
+	}
+
+	_, err = s.Pay(account.ID, 100, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	outbox = s.Outbox()
+	if outbox[len(outbox)-1].Event.Seq != outbox[len(outbox)-2].Event.Seq+1 {
+		t.Errorf("events must continue the sequence: %v", outbox)
+	}
+}
+
+func TestService_Export_interrupted(t *testing.T) {
+	s := newTestService()
+	s.EnableOutbox()
+	account, err := s.addAccountWithBalance("+992000000001", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	payment, err := s.Pay(account.ID, 100, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	staged := t.TempDir()
+	err = s.Export(staged)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Stop the second export after the commit, with payments.dump moved
+	// into place but the outbox not yet.
+	files, err := ioutil.ReadDir(staged)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var journal []string
+	for _, file := range files {
+		err = os.Rename(filepath.Join(staged, file.Name()), filepath.Join(dir, file.Name()+".tmp"))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		journal = append(journal, "write;"+file.Name())
+	}
+	err = os.Rename(filepath.Join(dir, "payments.dump.tmp"), filepath.Join(dir, "payments.dump"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, dumpJournal), []byte(strings.Join(journal, "\n")), 0666)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = imported.FindPaymentByID(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	outbox := imported.Outbox()
+	if len(outbox) == 0 || outbox[len(outbox)-1].Event.ObjectID != payment.ID {
+		t.Errorf("outbox must be saved together with the payment: %v", outbox)
+	}
+	_, err = os.Stat(filepath.Join(dir, dumpJournal))
+	if !os.IsNotExist(err) {
+		t.Errorf("journal must be removed once applied, stat returned %v", err)
+	}
+}
+
+func TestService_RelayOutbox(t *testing.T) {
+	s := newTestService()
+	s.EnableOutbox()
+	account, err := s.addAccountWithBalance("+992000000001", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, amount := range []types.Money{100, 200} {
+		_, err = s.Pay(account.ID, amount, "auto")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	var published []types.Event
+	failing := PublisherFunc(func(ctx context.Context, event types.Event) error {
+		if event.Type == types.EventPaymentCreated && event.Amount == 200 {
+			return errors.New("broker is down")
+		}
+		published = append(published, event)
+		return nil
+	})
+
+	count, err := s.RelayOutbox(context.Background(), failing)
+	if err == nil || !strings.Contains(err.Error(), "broker is down") {
+		t.Errorf("RelayOutbox(): must return the publisher error, returned %v", err)
+	}
+	outbox := s.Outbox()
+	if count != 3 || len(outbox) != 1 || outbox[0].Attempts != 1 || outbox[0].Error != "broker is down" {
+		t.Errorf("invalid outbox after failure: %v published, %+v", count, outbox)
+	}
+
+	count, err = s.RelayOutbox(context.Background(), PublisherFunc(func(ctx context.Context, event types.Event) error {
+		published = append(published, event)
+		return nil
+	}))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if count != 1 || len(s.Outbox()) != 0 {
+		t.Errorf("invalid relay: %v published, %v left", count, len(s.Outbox()))
+	}
+
+	var kinds []types.EventType
+	for _, event := range published {
+		kinds = append(kinds, event.Type)
+	}
+	want := []types.EventType{
+		types.EventAccountRegistered,
+		types.EventDeposited,
+		types.EventPaymentCreated,
+		types.EventPaymentCreated,
+	}
+	if !reflect.DeepEqual(want, kinds) {
+		t.Errorf("invalid result, expected: %v, actual: %v", want, kinds)
+	}
+}
+
+func TestService_RelayOutbox_atLeastOnce(t *testing.T) {
+	s := newTestService()
+	s.EnableOutbox()
+	account, err := s.addAccountWithBalance("+992000000001", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(account.ID, 100, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	seen := make(map[string]bool)
+	deliveries := 0
+	publisher := PublisherFunc(func(ctx context.Context, event types.Event) error {
+		deliveries++
+		seen[event.ID] = true
+		return nil
+	})
+
+	_, err = s.RelayOutbox(context.Background(), publisher)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// the process stops before exporting the relayed outbox
+	restarted := newTestService()
+	err = restarted.Import(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = restarted.RelayOutbox(context.Background(), publisher)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if deliveries != 6 || len(seen) != 3 {
+		t.Errorf("events must be redelivered with the same IDs: %v deliveries, %v unique", deliveries, len(seen))
+	}
+}
+
+func TestService_StartOutboxRelay(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	events := make(chan types.Event, 10)
+	relay := s.StartOutboxRelay(PublisherFunc(func(ctx context.Context, event types.Event) error {
+		events <- event
+		return nil
+	}), time.Hour)
+
+	payment, err := s.Pay(account.ID, 100, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	select {
+	case event := <-events:
+		if event.ObjectID != payment.ID {
+			t.Errorf("invalid event: %v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("relay must publish new events")
+	}
+
+	relay.Close()
+	relay.Close()
+	if len(s.Outbox()) != 0 {
+		t.Errorf("published events must leave the outbox: %v", s.Outbox())
+	}
+}
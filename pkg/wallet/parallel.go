@@ -0,0 +1,147 @@
+package wallet
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"sync"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+type span struct {
+	start int
+	end   int
+}
+
+// partition splits length items into at most parts consecutive spans. All
+// spans but the last get ceil(length/parts) items, the last one gets the
+// rest. There is always at least one (possibly empty) span.
+func partition(length int, parts int) []span {
+	if parts > length {
+		parts = length
+	}
+	if parts < 1 {
+		return []span{{start: 0, end: length}}
+	}
+
+	size := int(math.Ceil(float64(length) / float64(parts)))
+	spans := make([]span, 0, parts)
+	for start := 0; start < length; start += size {
+		end := start + size
+		if end > length {
+			end = length
+		}
+		spans = append(spans, span{start: start, end: end})
+	}
+
+	return spans
+}
+
+// parallel calls fn for every span using a pool of at most workers
+// goroutines, bounded by GOMAXPROCS. With a single span or worker fn runs
+// on the calling goroutine.
+func parallel(spans []span, workers int, fn func(i int, part span)) {
+	if max := runtime.GOMAXPROCS(0); workers > max {
+		workers = max
+	}
+	if workers > len(spans) {
+		workers = len(spans)
+	}
+
+	if workers <= 1 {
+		for i, part := range spans {
+			fn(i, part)
+		}
+		return
+	}
+
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i, spans[i])
+			}
+		}()
+	}
+
+	for i := range spans {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// mapReducePayments splits payments into goroutines parts, maps every part in
+// parallel and then reduces the partial results in the order of the parts,
+// so the result doesn't depend on scheduling. Reducer must be associative:
+// parts are mapped in blocks of progressStep payments.
+func (s *Service) mapReducePayments(goroutines int, mapper func(payments []*types.Payment) interface{}, reducer func(result interface{}, partial interface{}) interface{}, initial interface{}) interface{} {
+	result, _ := s.mapReducePaymentsContext(context.Background(), goroutines, nil, mapper, reducer, initial)
+	return result
+}
+
+// mapReducePaymentsContext is mapReducePayments that stops when ctx is done
+// and reports the number of mapped payments to progress.
+func (s *Service) mapReducePaymentsContext(ctx context.Context, goroutines int, progress ProgressFunc, mapper func(payments []*types.Payment) interface{}, reducer func(result interface{}, partial interface{}) interface{}, initial interface{}) (interface{}, error) {
+	payments := s.payments
+	spans := partition(len(payments), goroutines)
+	partials := make([][]interface{}, len(spans))
+	tracker := newProgressTracker(progress, len(payments))
+
+	parallel(spans, goroutines, func(i int, part span) {
+		for start := part.start; start < part.end; start += progressStep {
+			if ctx.Err() != nil {
+				return
+			}
+
+			end := start + progressStep
+			if end > part.end {
+				end = part.end
+			}
+			partials[i] = append(partials[i], mapper(payments[start:end]))
+			tracker.add(end - start)
+		}
+	})
+
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	result := initial
+	for _, blocks := range partials {
+		for _, partial := range blocks {
+			result = reducer(result, partial)
+		}
+	}
+
+	return result, nil
+}
+
+func (s *Service) filterPayments(filter func(payment *types.Payment) bool, goroutines int) []types.Payment {
+	filtered, _ := s.filterPaymentsContext(context.Background(), filter, goroutines, nil)
+	return filtered
+}
+
+func (s *Service) filterPaymentsContext(ctx context.Context, filter func(payment *types.Payment) bool, goroutines int, progress ProgressFunc) ([]types.Payment, error) {
+	result, err := s.mapReducePaymentsContext(ctx, goroutines, progress, func(payments []*types.Payment) interface{} {
+		var filtered []types.Payment
+		for _, payment := range payments {
+			if filter(payment) {
+				filtered = append(filtered, *payment)
+			}
+		}
+		return filtered
+	}, func(result interface{}, partial interface{}) interface{} {
+		return append(result.([]types.Payment), partial.([]types.Payment)...)
+	}, []types.Payment{})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.([]types.Payment), nil
+}
@@ -0,0 +1,120 @@
+package wallet
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+func TestPartition(t *testing.T) {
+	tests := []struct {
+		length int
+		parts  int
+		want   []span
+	}{
+		{length: 0, parts: 3, want: []span{{0, 0}}},
+		{length: 7, parts: 0, want: []span{{0, 7}}},
+		{length: 7, parts: 1, want: []span{{0, 7}}},
+		{length: 7, parts: 3, want: []span{{0, 3}, {3, 6}, {6, 7}}},
+		{length: 9, parts: 4, want: []span{{0, 3}, {3, 6}, {6, 9}}},
+		{length: 2, parts: 5, want: []span{{0, 1}, {1, 2}}},
+	}
+
+	for _, test := range tests {
+		got := partition(test.length, test.parts)
+		if !reflect.DeepEqual(test.want, got) {
+			t.Errorf("partition(%v, %v): expected: %v, actual: %v", test.length, test.parts, test.want, got)
+		}
+	}
+}
+
+func newBenchmarkService(count int) *testService {
+	s := newTestService()
+	categories := []types.PaymentCategory{"auto", "food", "fun"}
+	for i := 0; i < count; i++ {
+		s.payments = append(s.payments, &types.Payment{
+			ID:        strconv.Itoa(i),
+			AccountID: int64(i%10 + 1),
+			Amount:    types.Money(i%1000 + 1),
+			Category:  categories[i%len(categories)],
+			Status:    types.PaymentStatusOk,
+		})
+	}
+
+	return s
+}
+
+func TestService_FilterPaymentsByFn_order(t *testing.T) {
+	s := newBenchmarkService(1_000)
+	filter := func(payment types.Payment) bool { return payment.Category == "food" }
+
+	want, err := s.FilterPaymentsByFn(filter, 1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, goroutines := range []int{2, 3, 7, 64, 5_000} {
+		got, err := s.FilterPaymentsByFn(filter, goroutines)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("goroutines %v: result differs from sequential one", goroutines)
+		}
+	}
+}
+
+func TestService_SumPayments(t *testing.T) {
+	s := newBenchmarkService(1_000)
+	want := s.SumPayments(1)
+
+	for _, goroutines := range []int{0, 2, 3, 7, 64, 5_000} {
+		got := s.SumPayments(goroutines)
+		if got != want {
+			t.Errorf("goroutines %v: invalid result, expected: %v, actual: %v", goroutines, want, got)
+		}
+	}
+}
+
+func benchmarkSumPayments(b *testing.B, goroutines int) {
+	s := newBenchmarkService(1_000_000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.SumPayments(goroutines)
+	}
+}
+
+func BenchmarkSumPayments_sequential(b *testing.B) {
+	benchmarkSumPayments(b, 1)
+}
+
+func BenchmarkSumPayments_parallel(b *testing.B) {
+	benchmarkSumPayments(b, 8)
+}
+
+func benchmarkFilterPaymentsByFn(b *testing.B, goroutines int) {
+	s := newBenchmarkService(1_000_000)
+	filter := func(payment types.Payment) bool { return payment.Category == "food" && payment.Amount > 500 }
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := s.FilterPaymentsByFn(filter, goroutines)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFilterPaymentsByFn_sequential(b *testing.B) {
+	benchmarkFilterPaymentsByFn(b, 1)
+}
+
+func BenchmarkFilterPaymentsByFn_parallel(b *testing.B) {
+	benchmarkFilterPaymentsByFn(b, 8)
+}
@@ -0,0 +1,40 @@
+package wallet
+
+import "sync"
+
+// ProgressFunc receives progress of a long-running operation: done grows up
+// to total. Units depend on the operation and are documented on it.
+type ProgressFunc func(done int, total int)
+
+// progressStep is how often long-running operations check their context
+// and report progress.
+const progressStep = 4096
+
+type progressTracker struct {
+	mu       sync.Mutex
+	fn       ProgressFunc
+	done     int
+	total    int
+	reported int
+}
+
+func newProgressTracker(fn ProgressFunc, total int) *progressTracker {
+	return &progressTracker{fn: fn, total: total}
+}
+
+// add counts n more units as done. The callback is invoked at most once per
+// progressStep units and once more when the operation completes.
+func (p *progressTracker) add(n int) {
+	if p.fn == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done += n
+	if p.done-p.reported >= progressStep || (p.done >= p.total && p.reported != p.done) {
+		p.reported = p.done
+		p.fn(p.done, p.total)
+	}
+}
@@ -0,0 +1,202 @@
+package wallet
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+var ErrInvalidSettlement = errors.New("invalid settlement record")
+
+type DiscrepancyKind string
+
+const (
+	DiscrepancyAmount    DiscrepancyKind = "AMOUNT_MISMATCH"
+	DiscrepancyUnknown   DiscrepancyKind = "UNKNOWN_PAYMENT"
+	DiscrepancyMissing   DiscrepancyKind = "MISSING_FROM_FILE"
+	DiscrepancyStatus    DiscrepancyKind = "STATUS_CONFLICT"
+	DiscrepancyDuplicate DiscrepancyKind = "DUPLICATE"
+	DiscrepancyFailed    DiscrepancyKind = "REJECT_FAILED"
+)
+
+// Discrepancy is a settlement record or a payment that couldn't be matched.
+// Expected and Status come from the wallet, Settled and SettledStatus from
+// the file. Error is why a payment the file reports as failed couldn't be
+// rejected.
+type Discrepancy struct {
+	Kind          DiscrepancyKind
+	PaymentID     string
+	Expected      types.Money
+	Settled       types.Money
+	Status        types.PaymentStatus
+	SettledStatus types.PaymentStatus
+	Error         string
+}
+
+type ReconciliationReport struct {
+	Matched       int
+	Confirmed     []string
+	Rejected      []string
+	Discrepancies []Discrepancy
+}
+
+type settlement struct {
+	paymentID string
+	amount    types.Money
+	status    types.PaymentStatus
+}
+
+// Reconcile matches the settlement file at path against payments, see
+// ReconcileReader.
+func (s *Service) Reconcile(path string) (_ *ReconciliationReport, err error) {
+	defer s.audit("Reconcile", 0, path).done(&err)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, ioError("Reconcile", path, err)
+	}
+	defer func() {
+		cerr := file.Close()
+		if cerr != nil {
+			log.Print(cerr)
+		}
+	}()
+
+	return s.ReconcileReader(file)
+}
+
+// ReconcileReader reads a settlement CSV of payment ID, amount and status
+// (OK or FAIL), with an optional header line. Matching INPROGRESS payments
+// are confirmed or rejected as the file says. Amount mismatches, unknown
+// IDs, duplicates, status conflicts and INPROGRESS payments missing from
+// the file are reported and left as they are. The file is parsed before
+// any payment is changed, and payments that can't be rejected are reported
+// too, so a report is returned for every file that parses.
+func (s *Service) ReconcileReader(r io.Reader) (_ *ReconciliationReport, err error) {
+	defer s.audit("ReconcileReader", 0).done(&err)
+
+	settlements, err := readSettlements(r)
+	if err != nil {
+		return nil, ioError("Reconcile", "", err)
+	}
+
+	report := &ReconciliationReport{}
+	seen := make(map[string]bool)
+	for _, settled := range settlements {
+		if seen[settled.paymentID] {
+			report.Discrepancies = append(report.Discrepancies, Discrepancy{
+				Kind:          DiscrepancyDuplicate,
+				PaymentID:     settled.paymentID,
+				Settled:       settled.amount,
+				SettledStatus: settled.status,
+			})
+			continue
+		}
+		seen[settled.paymentID] = true
+
+		payment, err := s.FindPaymentByID(settled.paymentID)
+		if err != nil {
+			report.Discrepancies = append(report.Discrepancies, Discrepancy{
+				Kind:          DiscrepancyUnknown,
+				PaymentID:     settled.paymentID,
+				Settled:       settled.amount,
+				SettledStatus: settled.status,
+			})
+			continue
+		}
+
+		discrepancy := Discrepancy{
+			PaymentID:     payment.ID,
+			Expected:      payment.Amount,
+			Settled:       settled.amount,
+			Status:        payment.Status,
+			SettledStatus: settled.status,
+		}
+		if payment.Amount != settled.amount {
+			discrepancy.Kind = DiscrepancyAmount
+			report.Discrepancies = append(report.Discrepancies, discrepancy)
+			continue
+		}
+
+		switch {
+		case payment.Status == settled.status:
+			report.Matched++
+		case payment.Status != types.PaymentStatusInProgress:
+			discrepancy.Kind = DiscrepancyStatus
+			report.Discrepancies = append(report.Discrepancies, discrepancy)
+		case settled.status == types.PaymentStatusOk:
+			payment.Status = types.PaymentStatusOk
+			s.publish(types.EventPaymentConfirmed, payment.AccountID, payment.ID, payment.Amount, payment.Category)
+			report.Matched++
+			report.Confirmed = append(report.Confirmed, payment.ID)
+		default:
+			err = s.Reject(payment.ID)
+			if err != nil {
+				discrepancy.Kind = DiscrepancyFailed
+				discrepancy.Error = err.Error()
+				report.Discrepancies = append(report.Discrepancies, discrepancy)
+				continue
+			}
+			report.Matched++
+			report.Rejected = append(report.Rejected, payment.ID)
+		}
+	}
+
+	for _, payment := range s.payments {
+		if payment.Status == types.PaymentStatusInProgress && !seen[payment.ID] {
+			report.Discrepancies = append(report.Discrepancies, Discrepancy{
+				Kind:      DiscrepancyMissing,
+				PaymentID: payment.ID,
+				Expected:  payment.Amount,
+				Status:    payment.Status,
+			})
+		}
+	}
+
+	return report, nil
+}
+
+func readSettlements(r io.Reader) ([]settlement, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	var settlements []settlement
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		amount, err := strconv.ParseInt(record[1], 10, 64)
+		if err != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("line %v: %w", line, ErrInvalidSettlement)
+		}
+
+		status := types.PaymentStatus(strings.ToUpper(record[2]))
+		if record[0] == "" || (status != types.PaymentStatusOk && status != types.PaymentStatusFail) {
+			return nil, fmt.Errorf("line %v: %w", line, ErrInvalidSettlement)
+		}
+
+		settlements = append(settlements, settlement{
+			paymentID: record[0],
+			amount:    types.Money(amount),
+			status:    status,
+		})
+	}
+
+	return settlements, nil
+}
@@ -0,0 +1,131 @@
+package wallet
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+func TestService_Reconcile(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 2_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var payments []*types.Payment
+	for _, amount := range []types.Money{100, 200, 300, 400, 500} {
+		payment, err := s.Pay(account.ID, amount, "auto")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		payments = append(payments, payment)
+	}
+	payments[4].Status = types.PaymentStatusOk
+
+	settlement := "payment_id,amount,status\n" +
+		payments[0].ID + ",100,OK\n" +
+		payments[1].ID + ",200,fail\n" +
+		payments[2].ID + ",333,OK\n" +
+		payments[4].ID + ",500,FAIL\n" +
+		payments[0].ID + ",100,OK\n" +
+		"unknown,50,OK\n"
+	path := filepath.Join(t.TempDir(), "settlement.csv")
+	err = ioutil.WriteFile(path, []byte(settlement), 0666)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	report, err := s.Reconcile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	want := &ReconciliationReport{
+		Matched:   2,
+		Confirmed: []string{payments[0].ID},
+		Rejected:  []string{payments[1].ID},
+		Discrepancies: []Discrepancy{
+			{Kind: DiscrepancyAmount, PaymentID: payments[2].ID, Expected: 300, Settled: 333, Status: types.PaymentStatusInProgress, SettledStatus: types.PaymentStatusOk},
+			{Kind: DiscrepancyStatus, PaymentID: payments[4].ID, Expected: 500, Settled: 500, Status: types.PaymentStatusOk, SettledStatus: types.PaymentStatusFail},
+			{Kind: DiscrepancyDuplicate, PaymentID: payments[0].ID, Settled: 100, SettledStatus: types.PaymentStatusOk},
+			{Kind: DiscrepancyUnknown, PaymentID: "unknown", Settled: 50, SettledStatus: types.PaymentStatusOk},
+			{Kind: DiscrepancyMissing, PaymentID: payments[3].ID, Expected: 400, Status: types.PaymentStatusInProgress},
+		},
+	}
+	if !reflect.DeepEqual(want, report) {
+		t.Errorf("invalid result, expected: %v, actual: %v", want, report)
+	}
+
+	if payments[0].Status != types.PaymentStatusOk || payments[1].Status != types.PaymentStatusFail {
+		t.Errorf("invalid statuses: %v, %v", payments[0].Status, payments[1].Status)
+	}
+	if account.Balance != 2_000-100-300-400-500 {
+		t.Errorf("rejected payment must be refunded, balance: %v", account.Balance)
+	}
+}
+
+func TestService_ReconcileReader_rejectFailed(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := s.Pay(account.ID, 100, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	orphan := &types.Payment{ID: "orphan", AccountID: 999, Amount: 50, Category: "auto", Status: types.PaymentStatusInProgress}
+	s.payments = append(s.payments, orphan)
+
+	report, err := s.ReconcileReader(strings.NewReader("orphan,50,FAIL\n" + payment.ID + ",100,OK\n"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if report.Matched != 1 || !reflect.DeepEqual([]string{payment.ID}, report.Confirmed) || len(report.Discrepancies) != 1 {
+		t.Errorf("invalid report: %+v", report)
+		return
+	}
+	failed := report.Discrepancies[0]
+	if failed.Kind != DiscrepancyFailed || failed.PaymentID != "orphan" || !strings.Contains(failed.Error, ErrAccountNotFound.Error()) {
+		t.Errorf("invalid discrepancy: %+v", failed)
+	}
+	if orphan.Status != types.PaymentStatusInProgress {
+		t.Errorf("payment that couldn't be rejected must be left as it was, status: %v", orphan.Status)
+	}
+}
+
+func TestService_ReconcileReader_invalid(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := s.Pay(account.ID, 100, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.ReconcileReader(strings.NewReader(payment.ID + ",100,FAIL\n" + payment.ID + ",100,LOST\n"))
+	if !errors.Is(err, ErrInvalidSettlement) {
+		t.Errorf("ReconcileReader(): must return ErrInvalidSettlement, returned %v", err)
+	}
+	if payment.Status != types.PaymentStatusInProgress {
+		t.Errorf("invalid file must not change payments, status: %v", payment.Status)
+	}
+}
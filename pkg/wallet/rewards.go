@@ -0,0 +1,306 @@
+package wallet
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/a1ishm/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrCampaignNotFound = errors.New("campaign not found")
+var ErrInvalidCampaign = errors.New("invalid campaign")
+
+// Campaign is a cashback promotion. Every successful payment matching the
+// campaign earns Percent (in basis points) of its amount until the campaign
+// Budget is spent. With Bonus set cashback goes to the account's bonus
+// balance instead of the main one.
+type Campaign struct {
+	ID          string
+	Name        string
+	Category    types.PaymentCategory
+	Percent     int64
+	MinAmount   types.Money
+	MaxCashback types.Money
+	Budget      types.Money
+	Spent       types.Money
+	Bonus       bool
+	Stopped     bool
+}
+
+func (c *Campaign) Active() bool {
+	return !c.Stopped && c.Spent < c.Budget
+}
+
+func (c *Campaign) cashback(payment *types.Payment) types.Money {
+	if !c.Active() {
+		return 0
+	}
+	if c.Category != "" && c.Category != payment.Category {
+		return 0
+	}
+	if payment.Amount < c.MinAmount {
+		return 0
+	}
+
+	cashback := types.Money(int64(payment.Amount) * c.Percent / 10_000)
+	if c.MaxCashback != 0 && cashback > c.MaxCashback {
+		cashback = c.MaxCashback
+	}
+	if left := c.Budget - c.Spent; cashback > left {
+		cashback = left
+	}
+
+	return cashback
+}
+
+func (s *Service) AddCampaign(campaign Campaign) (_ *Campaign, err error) {
+	defer s.audit("AddCampaign", 0, campaign).done(&err)
+
+	if campaign.Percent <= 0 || campaign.Budget <= 0 || campaign.MinAmount < 0 || campaign.MaxCashback < 0 {
+		return nil, newError("AddCampaign", ErrInvalidCampaign, 0, campaign.Name, 0)
+	}
+
+	campaign.ID = uuid.New().String()
+	campaign.Spent = 0
+	campaign.Stopped = false
+
+	s.campaigns = append(s.campaigns, &campaign)
+	return &campaign, nil
+}
+
+func (s *Service) FindCampaignByID(campaignID string) (*Campaign, error) {
+	for _, campaign := range s.campaigns {
+		if campaign.ID == campaignID {
+			return campaign, nil
+		}
+	}
+
+	return nil, newError("FindCampaignByID", ErrCampaignNotFound, 0, campaignID, 0)
+}
+
+func (s *Service) StopCampaign(campaignID string) (err error) {
+	defer s.audit("StopCampaign", 0, campaignID).done(&err)
+
+	campaign, err := s.FindCampaignByID(campaignID)
+	if err != nil {
+		return err
+	}
+
+	campaign.Stopped = true
+	return nil
+}
+
+func (s *Service) BonusBalance(accountID int64) (types.Money, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.bonuses[accountID], nil
+}
+
+func (s *Service) RewardsByPayment(paymentID string) []types.Reward {
+	var rewards []types.Reward
+	for _, reward := range s.rewards {
+		if reward.PaymentID == paymentID {
+			rewards = append(rewards, *reward)
+		}
+	}
+
+	return rewards
+}
+
+func (s *Service) applyRewards(account *types.Account, payment *types.Payment) {
+	for _, campaign := range s.campaigns {
+		cashback := campaign.cashback(payment)
+		if cashback <= 0 {
+			continue
+		}
+
+		campaign.Spent += cashback
+		if campaign.Bonus {
+			if s.bonuses == nil {
+				s.bonuses = make(map[int64]types.Money)
+			}
+			s.bonuses[account.ID] += cashback
+		} else {
+			account.Balance += cashback
+			s.record(account.ID, types.EntryCashback, cashback, 0, payment.ID)
+		}
+
+		s.rewards = append(s.rewards, &types.Reward{
+			ID:         uuid.New().String(),
+			CampaignID: campaign.ID,
+			PaymentID:  payment.ID,
+			AccountID:  account.ID,
+			Amount:     cashback,
+			Bonus:      campaign.Bonus,
+		})
+	}
+}
+
+// clawbackRewards takes back the cashback earned by a rejected payment and
+// returns it to the campaign budgets.
+func (s *Service) clawbackRewards(account *types.Account, payment *types.Payment) {
+	for _, reward := range s.rewards {
+		if reward.PaymentID != payment.ID || reward.Reversed {
+			continue
+		}
+
+		if reward.Bonus {
+			s.bonuses[account.ID] -= reward.Amount
+		} else {
+			account.Balance -= reward.Amount
+			s.record(account.ID, types.EntryClawback, -reward.Amount, 0, payment.ID)
+		}
+
+		campaign, err := s.FindCampaignByID(reward.CampaignID)
+		if err == nil {
+			campaign.Spent -= reward.Amount
+		}
+
+		reward.Reversed = true
+	}
+}
+
+func (s *Service) campaignRecords() [][]string {
+	var records [][]string
+	for _, campaign := range s.campaigns {
+		records = append(records, []string{
+			campaign.ID,
+			url.QueryEscape(campaign.Name),
+			url.QueryEscape(string(campaign.Category)),
+			strconv.FormatInt(campaign.Percent, 10),
+			strconv.FormatInt(int64(campaign.MinAmount), 10),
+			strconv.FormatInt(int64(campaign.MaxCashback), 10),
+			strconv.FormatInt(int64(campaign.Budget), 10),
+			strconv.FormatInt(int64(campaign.Spent), 10),
+			strconv.FormatBool(campaign.Bonus),
+			strconv.FormatBool(campaign.Stopped),
+		})
+	}
+
+	return records
+}
+
+func (s *Service) rewardRecords() [][]string {
+	var records [][]string
+	for _, reward := range s.rewards {
+		records = append(records, []string{
+			reward.ID,
+			reward.CampaignID,
+			reward.PaymentID,
+			strconv.FormatInt(reward.AccountID, 10),
+			strconv.FormatInt(int64(reward.Amount), 10),
+			strconv.FormatBool(reward.Bonus),
+			strconv.FormatBool(reward.Reversed),
+		})
+	}
+
+	return records
+}
+
+func (s *Service) bonusRecords() [][]string {
+	accountIDs := make([]int64, 0, len(s.bonuses))
+	for accountID := range s.bonuses {
+		accountIDs = append(accountIDs, accountID)
+	}
+	sort.Slice(accountIDs, func(i, j int) bool {
+		return accountIDs[i] < accountIDs[j]
+	})
+
+	var records [][]string
+	for _, accountID := range accountIDs {
+		records = append(records, []string{
+			strconv.FormatInt(accountID, 10),
+			strconv.FormatInt(int64(s.bonuses[accountID]), 10),
+		})
+	}
+
+	return records
+}
+
+func parseCampaign(record []string) (*Campaign, error) {
+	if len(record) < 10 {
+		return nil, ErrInvalidRecord
+	}
+
+	var err error
+	campaign := &Campaign{ID: record[0]}
+	if campaign.Name, err = url.QueryUnescape(record[1]); err != nil {
+		return nil, err
+	}
+	category, err := url.QueryUnescape(record[2])
+	if err != nil {
+		return nil, err
+	}
+	campaign.Category = types.PaymentCategory(category)
+	if campaign.Percent, err = strconv.ParseInt(record[3], 10, 64); err != nil {
+		return nil, err
+	}
+	amounts := []*types.Money{&campaign.MinAmount, &campaign.MaxCashback, &campaign.Budget, &campaign.Spent}
+	for i, amount := range amounts {
+		value, err := strconv.ParseInt(record[4+i], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		*amount = types.Money(value)
+	}
+	if campaign.Bonus, err = strconv.ParseBool(record[8]); err != nil {
+		return nil, err
+	}
+	if campaign.Stopped, err = strconv.ParseBool(record[9]); err != nil {
+		return nil, err
+	}
+
+	return campaign, nil
+}
+
+func parseReward(record []string) (*types.Reward, error) {
+	if len(record) < 7 {
+		return nil, ErrInvalidRecord
+	}
+
+	var err error
+	reward := &types.Reward{
+		ID:         record[0],
+		CampaignID: record[1],
+		PaymentID:  record[2],
+	}
+	if reward.AccountID, err = strconv.ParseInt(record[3], 10, 64); err != nil {
+		return nil, err
+	}
+	amount, err := strconv.ParseInt(record[4], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	reward.Amount = types.Money(amount)
+	if reward.Bonus, err = strconv.ParseBool(record[5]); err != nil {
+		return nil, err
+	}
+	if reward.Reversed, err = strconv.ParseBool(record[6]); err != nil {
+		return nil, err
+	}
+
+	return reward, nil
+}
+
+func parseBonus(record []string) (int64, types.Money, error) {
+	if len(record) < 2 {
+		return 0, 0, ErrInvalidRecord
+	}
+
+	accountID, err := strconv.ParseInt(record[0], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	amount, err := strconv.ParseInt(record[1], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return accountID, types.Money(amount), nil
+}
@@ -0,0 +1,180 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+func TestService_Pay_cashback(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 10_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	campaign, err := s.AddCampaign(Campaign{Name: "food 2%", Category: "food", Percent: 200, Budget: 30_00})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.Pay(account.ID, 1_000_00, "food")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(account.ID, 1_000_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if account.Balance != 8_020_00 {
+		t.Errorf("invalid balance, expected: %v, actual: %v", types.Money(8_020_00), account.Balance)
+	}
+
+	payment, err := s.Pay(account.ID, 1_000_00, "food")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	rewards := s.RewardsByPayment(payment.ID)
+	if len(rewards) != 1 || rewards[0].Amount != 10_00 {
+		t.Errorf("invalid rewards, expected a single 1000 cashback, actual: %v", rewards)
+	}
+
+	if campaign.Active() {
+		t.Errorf("campaign must stop when its budget is exhausted")
+	}
+
+	_, err = s.Pay(account.ID, 1_000_00, "food")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if account.Balance != 6_030_00 {
+		t.Errorf("invalid balance, expected: %v, actual: %v", types.Money(6_030_00), account.Balance)
+	}
+}
+
+func TestService_Reject_clawsBackCashback(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 10_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	campaign, err := s.AddCampaign(Campaign{Percent: 500, Budget: 1_000_00, Bonus: true})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	payment, err := s.Pay(account.ID, 2_000_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	bonus, err := s.BonusBalance(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if bonus != 100_00 || account.Balance != 8_000_00 {
+		t.Errorf("invalid balances, expected bonus 10000 and balance 800000, actual: %v and %v", bonus, account.Balance)
+	}
+
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	bonus, err = s.BonusBalance(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if bonus != 0 || account.Balance != 10_000_00 {
+		t.Errorf("invalid balances, expected bonus 0 and balance 1000000, actual: %v and %v", bonus, account.Balance)
+	}
+
+	if campaign.Spent != 0 {
+		t.Errorf("campaign budget must be returned, spent: %v", campaign.Spent)
+	}
+}
+
+func TestService_AddCampaign_invalid(t *testing.T) {
+	s := newTestService()
+
+	_, err := s.AddCampaign(Campaign{Percent: 100})
+	if !errors.Is(err, ErrInvalidCampaign) {
+		t.Errorf("AddCampaign(): must return ErrInvalidCampaign, returned %v", err)
+	}
+}
+
+func TestService_ExportImport_rewards(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 10_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	campaign, err := s.AddCampaign(Campaign{Name: "food; 2%", Category: "food", Percent: 200, Budget: 100_00, Bonus: true})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := s.Pay(account.ID, 1_000_00, "food")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	bonus, err := imported.BonusBalance(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if bonus != 20_00 {
+		t.Errorf("invalid bonus balance, expected: %v, actual: %v", types.Money(20_00), bonus)
+	}
+	importedCampaign, err := imported.FindCampaignByID(campaign.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if *importedCampaign != *campaign {
+		t.Errorf("invalid campaign, expected: %v, actual: %v", campaign, importedCampaign)
+	}
+
+	err = imported.Reject(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	bonus, _ = imported.BonusBalance(account.ID)
+	if bonus != 0 || importedCampaign.Spent != 0 {
+		t.Errorf("cashback must be clawed back after import, bonus: %v, spent: %v", bonus, importedCampaign.Spent)
+	}
+}
@@ -0,0 +1,298 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+var ErrPaymentDenied = errors.New("payment denied by risk checks")
+var ErrReviewNotFound = errors.New("payment is not held for review")
+
+type RiskAction string
+
+const (
+	RiskAllow RiskAction = "ALLOW"
+	RiskHold  RiskAction = "HOLD"
+	RiskDeny  RiskAction = "DENY"
+)
+
+func (a RiskAction) severity() int {
+	switch a {
+	case RiskHold:
+		return 1
+	case RiskDeny:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// RiskCheck is a payment about to be made. History holds the earlier
+// payments of the account.
+type RiskCheck struct {
+	Account  types.Account
+	Amount   types.Money
+	Category types.PaymentCategory
+	Time     time.Time
+	History  []types.Payment
+}
+
+// RiskRule decides what to do with a payment. Rules that don't object to
+// it return RiskAllow, others return the action and the reason.
+type RiskRule interface {
+	Check(check RiskCheck) (RiskAction, string)
+}
+
+// VelocityRule objects to more than Payments payments of an account within
+// Window. Action is RiskDeny when empty, the same goes for the other rules.
+type VelocityRule struct {
+	Payments int
+	Window   time.Duration
+	Action   RiskAction
+}
+
+func (r VelocityRule) Check(check RiskCheck) (RiskAction, string) {
+	count := 1
+	for _, payment := range check.History {
+		if !payment.Created.IsZero() && check.Time.Sub(payment.Created) < r.Window {
+			count++
+		}
+	}
+	if count <= r.Payments {
+		return RiskAllow, ""
+	}
+
+	return ruleAction(r.Action), fmt.Sprintf("more than %v payments in %v", r.Payments, r.Window)
+}
+
+// AmountAnomalyRule objects to payments more than Factor times larger than
+// the average payment of the account. Accounts with less than MinHistory
+// successful payments aren't checked.
+type AmountAnomalyRule struct {
+	Factor     int64
+	MinHistory int
+	Action     RiskAction
+}
+
+func (r AmountAnomalyRule) Check(check RiskCheck) (RiskAction, string) {
+	count := 0
+	var total types.Money
+	for _, payment := range check.History {
+		if payment.Status != types.PaymentStatusFail {
+			count++
+			total += payment.Amount
+		}
+	}
+	if count == 0 || count < r.MinHistory {
+		return RiskAllow, ""
+	}
+
+	average := total / types.Money(count)
+	if int64(check.Amount) <= int64(average)*r.Factor {
+		return RiskAllow, ""
+	}
+
+	return ruleAction(r.Action), fmt.Sprintf("amount %v is more than %v times the average %v", check.Amount, r.Factor, average)
+}
+
+// NewAccountRule limits payments of accounts registered less than Age ago
+// to MaxAmount. Accounts without a registration time aren't checked.
+type NewAccountRule struct {
+	Age       time.Duration
+	MaxAmount types.Money
+	Action    RiskAction
+}
+
+func (r NewAccountRule) Check(check RiskCheck) (RiskAction, string) {
+	if check.Account.Created.IsZero() || check.Time.Sub(check.Account.Created) >= r.Age || check.Amount <= r.MaxAmount {
+		return RiskAllow, ""
+	}
+
+	return ruleAction(r.Action), fmt.Sprintf("account is younger than %v", r.Age)
+}
+
+// BlockedCategoryRule objects to payments in Categories and their
+// subcategories.
+type BlockedCategoryRule struct {
+	Categories []types.PaymentCategory
+	Action     RiskAction
+}
+
+func (r BlockedCategoryRule) Check(check RiskCheck) (RiskAction, string) {
+	for _, category := range r.Categories {
+		if check.Category == category || strings.HasPrefix(string(check.Category), string(category)+"/") {
+			return ruleAction(r.Action), fmt.Sprintf("category %v is blocked", category)
+		}
+	}
+
+	return RiskAllow, ""
+}
+
+func ruleAction(action RiskAction) RiskAction {
+	if action == "" {
+		return RiskDeny
+	}
+
+	return action
+}
+
+// AddRiskRule adds a rule consulted by Pay and so by every call that makes
+// payments. All rules are checked and the strictest action wins: denied
+// payments aren't made, held ones are made with status REVIEW, keeping the
+// funds, and wait in the review queue. Held payments count for budgets and
+// earn cashback only once they are let through.
+func (s *Service) AddRiskRule(rule RiskRule) (err error) {
+	defer s.audit("AddRiskRule", 0, rule).done(&err)
+
+	s.riskRules = append(s.riskRules, rule)
+	return nil
+}
+
+func (s *Service) RiskRules() []RiskRule {
+	return append([]RiskRule{}, s.riskRules...)
+}
+
+func (s *Service) ClearRiskRules() {
+	defer s.audit("ClearRiskRules", 0).done(nil)
+
+	s.riskRules = nil
+}
+
+func (s *Service) checkRisk(account *types.Account, amount types.Money, category types.PaymentCategory) (RiskAction, []string) {
+	if len(s.riskRules) == 0 {
+		return RiskAllow, nil
+	}
+
+	check := RiskCheck{
+		Account:  *account,
+		Amount:   amount,
+		Category: category,
+		Time:     s.now(),
+	}
+	for _, payment := range s.payments {
+		if payment.AccountID == account.ID {
+			check.History = append(check.History, *payment)
+		}
+	}
+
+	action := RiskAllow
+	var reasons []string
+	for _, rule := range s.riskRules {
+		result, reason := rule.Check(check)
+		if result.severity() == 0 {
+			continue
+		}
+		if result.severity() > action.severity() {
+			action = result
+		}
+		reasons = append(reasons, reason)
+	}
+
+	return action, reasons
+}
+
+// ReviewQueue returns the payments held for review, oldest first.
+func (s *Service) ReviewQueue() []types.RiskReview {
+	var reviews []types.RiskReview
+	for _, review := range s.reviews {
+		payment, err := s.FindPaymentByID(review.PaymentID)
+		if err == nil && payment.Status == types.PaymentStatusReview {
+			reviews = append(reviews, *review)
+		}
+	}
+
+	return reviews
+}
+
+func (s *Service) findReview(op string, paymentID string) (*types.Payment, error) {
+	payment, err := s.FindPaymentByID(paymentID)
+	if err != nil {
+		return nil, err
+	}
+	if payment.Status != types.PaymentStatusReview {
+		return nil, newError(op, ErrReviewNotFound, payment.AccountID, paymentID, 0)
+	}
+
+	return payment, nil
+}
+
+// ApproveReview lets a held payment through, to the approvers if it needs
+// approval.
+func (s *Service) ApproveReview(paymentID string) (err error) {
+	defer s.audit("ApproveReview", 0, paymentID).done(&err)
+
+	payment, err := s.findReview("ApproveReview", paymentID)
+	if err != nil {
+		return err
+	}
+
+	if s.findApproval(paymentID) != nil {
+		payment.Status = types.PaymentStatusPending
+		s.publish(types.EventPaymentPending, payment.AccountID, payment.ID, payment.Amount, payment.Category)
+		return nil
+	}
+
+	account, err := s.FindAccountByID(payment.AccountID)
+	if err != nil {
+		return err
+	}
+
+	return s.releasePayment("ApproveReview", account, payment, types.EventPaymentReleased)
+}
+
+// DeclineReview rejects a held payment and returns its funds.
+func (s *Service) DeclineReview(paymentID string) (err error) {
+	defer s.audit("DeclineReview", 0, paymentID).done(&err)
+
+	_, err = s.findReview("DeclineReview", paymentID)
+	if err != nil {
+		return err
+	}
+
+	return s.Reject(paymentID)
+}
+
+func (s *Service) reviewRecords() [][]string {
+	var records [][]string
+	for _, review := range s.reviews {
+		records = append(records, []string{
+			review.PaymentID,
+			strconv.FormatInt(review.AccountID, 10),
+			strconv.FormatInt(int64(review.Amount), 10),
+			joinEscaped(review.Reasons),
+			formatTime(review.Created),
+		})
+	}
+
+	return records
+}
+
+func parseReview(record []string) (*types.RiskReview, error) {
+	if len(record) < 5 {
+		return nil, ErrInvalidRecord
+	}
+
+	var err error
+	review := &types.RiskReview{PaymentID: record[0]}
+	if review.AccountID, err = strconv.ParseInt(record[1], 10, 64); err != nil {
+		return nil, err
+	}
+	amount, err := strconv.ParseInt(record[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	review.Amount = types.Money(amount)
+	if review.Reasons, err = splitEscaped(record[3]); err != nil {
+		return nil, err
+	}
+	if review.Created, err = parseTime(record[4]); err != nil {
+		return nil, err
+	}
+
+	return review, nil
+}
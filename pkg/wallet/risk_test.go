@@ -0,0 +1,244 @@
+package wallet
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+func newRiskTestService(t *testing.T, balance types.Money) (*testService, *testClock, *types.Account) {
+	s := newTestService()
+	clock := &testClock{now: time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC)}
+	s.SetClock(clock)
+
+	account, err := s.addAccountWithBalance("+992000000001", balance)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, clock, account
+}
+
+func TestService_Pay_velocityRule(t *testing.T) {
+	s, clock, account := newRiskTestService(t, 1_000)
+	err := s.AddRiskRule(VelocityRule{Payments: 2, Window: time.Minute})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = s.Pay(account.ID, 100, "auto")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		clock.advance(10 * time.Second)
+	}
+
+	_, err = s.Pay(account.ID, 100, "auto")
+	if !errors.Is(err, ErrPaymentDenied) {
+		t.Errorf("Pay(): must return ErrPaymentDenied, returned %v", err)
+	}
+	if account.Balance != 800 {
+		t.Errorf("denied payment must not change the balance, balance: %v", account.Balance)
+	}
+
+	clock.advance(time.Minute)
+	_, err = s.Pay(account.ID, 100, "auto")
+	if err != nil {
+		t.Errorf("Pay(): must allow payments after the window, returned %v", err)
+	}
+}
+
+func TestService_Pay_newAccountRule(t *testing.T) {
+	s, clock, account := newRiskTestService(t, 1_000)
+	err := s.AddRiskRule(NewAccountRule{Age: 24 * time.Hour, MaxAmount: 100})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.Pay(account.ID, 100, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(account.ID, 200, "auto")
+	if !errors.Is(err, ErrPaymentDenied) {
+		t.Errorf("Pay(): must return ErrPaymentDenied, returned %v", err)
+	}
+
+	clock.advance(25 * time.Hour)
+	_, err = s.Pay(account.ID, 200, "auto")
+	if err != nil {
+		t.Errorf("Pay(): must allow payments of old accounts, returned %v", err)
+	}
+}
+
+func TestService_Repeat_blockedCategoryRule(t *testing.T) {
+	s, _, account := newRiskTestService(t, 1_000)
+	payment, err := s.Pay(account.ID, 100, "games/casino")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	favorite, err := s.FavoritePayment(payment.ID, "casino")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.AddRiskRule(BlockedCategoryRule{Categories: []types.PaymentCategory{"games"}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.Repeat(payment.ID)
+	if !errors.Is(err, ErrPaymentDenied) {
+		t.Errorf("Repeat(): must return ErrPaymentDenied, returned %v", err)
+	}
+	_, err = s.PayFromFavorite(favorite.ID)
+	if !errors.Is(err, ErrPaymentDenied) {
+		t.Errorf("PayFromFavorite(): must return ErrPaymentDenied, returned %v", err)
+	}
+	_, err = s.Pay(account.ID, 100, "gamesx")
+	if err != nil {
+		t.Errorf("Pay(): must allow other categories, returned %v", err)
+	}
+}
+
+func TestService_Pay_hold(t *testing.T) {
+	s, _, account := newRiskTestService(t, 10_000)
+	for i := 0; i < 3; i++ {
+		_, err := s.Pay(account.ID, 100, "auto")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	err := s.AddRiskRule(AmountAnomalyRule{Factor: 5, MinHistory: 3, Action: RiskHold})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.AddRiskRule(BlockedCategoryRule{Categories: []types.PaymentCategory{"games"}, Action: RiskHold})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	held, err := s.Pay(account.ID, 1_000, "games")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if held.Status != types.PaymentStatusReview || account.Balance != 8_700 {
+		t.Errorf("held payment must keep the funds, status: %v, balance: %v", held.Status, account.Balance)
+	}
+
+	queue := s.ReviewQueue()
+	want := []string{"amount 1000 is more than 5 times the average 100", "category games is blocked"}
+	if len(queue) != 1 || queue[0].PaymentID != held.ID || !reflect.DeepEqual(want, queue[0].Reasons) {
+		t.Errorf("invalid review queue: %+v", queue)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if queue := imported.ReviewQueue(); len(queue) != 1 || !reflect.DeepEqual(want, queue[0].Reasons) {
+		t.Errorf("invalid imported review queue: %+v", queue)
+	}
+
+	err = s.ApproveReview(held.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if held.Status != types.PaymentStatusInProgress || len(s.ReviewQueue()) != 0 {
+		t.Errorf("approved payment must leave the queue, status: %v", held.Status)
+	}
+	err = s.ApproveReview(held.ID)
+	if !errors.Is(err, ErrReviewNotFound) {
+		t.Errorf("ApproveReview(): must return ErrReviewNotFound, returned %v", err)
+	}
+
+	declined, err := s.Pay(account.ID, 1_000, "games")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.DeclineReview(declined.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if declined.Status != types.PaymentStatusFail || account.Balance != 8_700 {
+		t.Errorf("declined payment must return the funds, status: %v, balance: %v", declined.Status, account.Balance)
+	}
+}
+
+func TestService_Pay_holdSideEffects(t *testing.T) {
+	s, _, account := newRiskTestService(t, 10_000)
+	_, err := s.AddCampaign(Campaign{Percent: 1_000, Budget: 1_000})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	budget, err := s.SetBudget(account.ID, "games", types.BudgetMonthly, 5_000, types.BudgetSoft)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.AddRiskRule(BlockedCategoryRule{Categories: []types.PaymentCategory{"games"}, Action: RiskHold})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	declined, err := s.Pay(account.ID, 1_000, "games")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account.Balance != 9_000 || budget.Spent != 0 {
+		t.Errorf("held payment must not earn cashback or count for budgets, balance: %v, spent: %v", account.Balance, budget.Spent)
+	}
+	err = s.DeclineReview(declined.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account.Balance != 10_000 || budget.Spent != 0 {
+		t.Errorf("declined payment must leave budgets as they were, balance: %v, spent: %v", account.Balance, budget.Spent)
+	}
+
+	released, err := s.Pay(account.ID, 1_000, "games")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.ApproveReview(released.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account.Balance != 9_100 || budget.Spent != 1_000 {
+		t.Errorf("released payment must earn cashback and count for budgets, balance: %v, spent: %v", account.Balance, budget.Spent)
+	}
+}
@@ -0,0 +1,359 @@
+package wallet
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrScheduleNotFound = errors.New("schedule not found")
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// ScheduleOptions describes when a favorite is paid. The first payment is
+// made at Start (or at the first cron match after it); the schedule ends
+// after End or after Count successful payments, whichever comes first.
+type ScheduleOptions struct {
+	Frequency types.ScheduleFrequency
+	Cron      string
+	Start     time.Time
+	End       time.Time
+	Count     int
+}
+
+// RetryPolicy controls what happens when a scheduled payment fails with
+// ErrNotEnoughBalance: it is attempted up to Attempts times, Delay apart,
+// before the occurrence is skipped.
+type RetryPolicy struct {
+	Attempts int
+	Delay    time.Duration
+}
+
+var defaultRetryPolicy = RetryPolicy{Attempts: 3, Delay: time.Hour}
+
+func (s *Service) SetRetryPolicy(policy RetryPolicy) {
+	defer s.audit("SetRetryPolicy", 0, policy).done(nil)
+
+	s.retryPolicy = policy
+}
+
+func (s *Service) retry() RetryPolicy {
+	if s.retryPolicy.Attempts < 1 {
+		return defaultRetryPolicy
+	}
+
+	return s.retryPolicy
+}
+
+func (s *Service) SchedulePayment(favoriteID string, options ScheduleOptions) (_ *types.Schedule, err error) {
+	defer s.audit("SchedulePayment", 0, favoriteID, options).done(&err)
+
+	_, err = s.FindFavoriteByID(favoriteID)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Count < 0 {
+		return nil, newError("SchedulePayment", ErrInvalidSchedule, 0, favoriteID, 0)
+	}
+	if options.Start.IsZero() {
+		options.Start = s.now()
+	}
+	if !options.End.IsZero() && options.End.Before(options.Start) {
+		return nil, newError("SchedulePayment", ErrInvalidSchedule, 0, favoriteID, 0)
+	}
+
+	schedule := &types.Schedule{
+		ID:         uuid.New().String(),
+		FavoriteID: favoriteID,
+		Frequency:  options.Frequency,
+		Cron:       options.Cron,
+		Start:      options.Start,
+		End:        options.End,
+		Count:      options.Count,
+		Next:       options.Start,
+		Active:     true,
+	}
+
+	switch options.Frequency {
+	case types.ScheduleDaily, types.ScheduleWeekly, types.ScheduleMonthly:
+	case types.ScheduleCron:
+		c, err := parseCron(options.Cron)
+		if err != nil {
+			return nil, newError("SchedulePayment", err, 0, favoriteID, 0)
+		}
+		next, ok := c.next(options.Start.Add(-time.Minute))
+		if !ok {
+			return nil, newError("SchedulePayment", ErrInvalidSchedule, 0, favoriteID, 0)
+		}
+		schedule.Next = next
+	default:
+		return nil, newError("SchedulePayment", ErrInvalidSchedule, 0, favoriteID, 0)
+	}
+
+	s.schedules = append(s.schedules, schedule)
+	return schedule, nil
+}
+
+func (s *Service) FindScheduleByID(scheduleID string) (*types.Schedule, error) {
+	for _, schedule := range s.schedules {
+		if schedule.ID == scheduleID {
+			return schedule, nil
+		}
+	}
+
+	return nil, newError("FindScheduleByID", ErrScheduleNotFound, 0, scheduleID, 0)
+}
+
+func (s *Service) CancelSchedule(scheduleID string) (err error) {
+	defer s.audit("CancelSchedule", 0, scheduleID).done(&err)
+
+	schedule, err := s.FindScheduleByID(scheduleID)
+	if err != nil {
+		return err
+	}
+
+	schedule.Active = false
+	return nil
+}
+
+func (s *Service) ScheduleRuns(scheduleID string) ([]types.ScheduleRun, error) {
+	_, err := s.FindScheduleByID(scheduleID)
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []types.ScheduleRun
+	for _, run := range s.scheduleRuns {
+		if run.ScheduleID == scheduleID {
+			runs = append(runs, *run)
+		}
+	}
+
+	return runs, nil
+}
+
+// RunDueSchedules pays every active schedule that is due by the service
+// clock and returns the runs it made. Occurrences missed while the scheduler
+// wasn't running are coalesced into a single payment.
+func (s *Service) RunDueSchedules() []types.ScheduleRun {
+	defer s.audit("RunDueSchedules", 0).done(nil)
+
+	now := s.now()
+
+	var runs []types.ScheduleRun
+	for _, schedule := range s.schedules {
+		if !schedule.Active {
+			continue
+		}
+
+		due := schedule.Next
+		if !schedule.RetryAt.IsZero() {
+			due = schedule.RetryAt
+		}
+		if due.After(now) {
+			continue
+		}
+
+		if !schedule.End.IsZero() && schedule.Next.After(schedule.End) {
+			schedule.Active = false
+			continue
+		}
+
+		run := s.runSchedule(schedule, now)
+		s.scheduleRuns = append(s.scheduleRuns, &run)
+		runs = append(runs, run)
+	}
+
+	return runs
+}
+
+func (s *Service) runSchedule(schedule *types.Schedule, now time.Time) types.ScheduleRun {
+	run := types.ScheduleRun{
+		ScheduleID: schedule.ID,
+		Time:       now,
+		Status:     types.ScheduleRunOk,
+	}
+
+	payment, err := s.PayFromFavorite(schedule.FavoriteID)
+	if err == nil {
+		run.PaymentID = payment.ID
+		schedule.Runs++
+		s.advanceSchedule(schedule, now)
+		return run
+	}
+
+	run.Error = err.Error()
+	schedule.Attempts++
+	if errors.Is(err, ErrNotEnoughBalance) && schedule.Attempts < s.retry().Attempts {
+		run.Status = types.ScheduleRunRetry
+		schedule.RetryAt = now.Add(s.retry().Delay)
+		return run
+	}
+
+	run.Status = types.ScheduleRunFail
+	if errors.Is(err, ErrFavoriteNotFound) || errors.Is(err, ErrAccountNotFound) {
+		schedule.Active = false
+		return run
+	}
+
+	s.advanceSchedule(schedule, now)
+	return run
+}
+
+func (s *Service) advanceSchedule(schedule *types.Schedule, now time.Time) {
+	schedule.Attempts = 0
+	schedule.RetryAt = time.Time{}
+
+	for !schedule.Next.After(now) {
+		next, ok := followingOccurrence(schedule)
+		if !ok {
+			schedule.Active = false
+			return
+		}
+		schedule.Next = next
+	}
+
+	if schedule.Count > 0 && schedule.Runs >= schedule.Count {
+		schedule.Active = false
+	}
+	if !schedule.End.IsZero() && schedule.Next.After(schedule.End) {
+		schedule.Active = false
+	}
+}
+
+func followingOccurrence(schedule *types.Schedule) (time.Time, bool) {
+	switch schedule.Frequency {
+	case types.ScheduleDaily:
+		return schedule.Next.AddDate(0, 0, 1), true
+	case types.ScheduleWeekly:
+		return schedule.Next.AddDate(0, 0, 7), true
+	case types.ScheduleMonthly:
+		start := schedule.Start
+		months := (schedule.Next.Year()-start.Year())*12 + int(schedule.Next.Month()-start.Month())
+		return addMonths(start, months+1), true
+	case types.ScheduleCron:
+		c, err := parseCron(schedule.Cron)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return c.next(schedule.Next)
+	}
+
+	return time.Time{}, false
+}
+
+// addMonths adds months to t, clamping the day to the length of the target
+// month: January 31 plus one month is February 28 (or 29).
+func addMonths(t time.Time, months int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	last := first.AddDate(0, 1, -1).Day()
+
+	day := t.Day()
+	if day > last {
+		day = last
+	}
+
+	return first.AddDate(0, 0, day-1)
+}
+
+func (s *Service) scheduleRecords() [][]string {
+	var records [][]string
+	for _, schedule := range s.schedules {
+		records = append(records, []string{
+			schedule.ID,
+			schedule.FavoriteID,
+			string(schedule.Frequency),
+			schedule.Cron,
+			formatTime(schedule.Start),
+			formatTime(schedule.End),
+			strconv.Itoa(schedule.Count),
+			formatTime(schedule.Next),
+			formatTime(schedule.RetryAt),
+			strconv.Itoa(schedule.Attempts),
+			strconv.Itoa(schedule.Runs),
+			strconv.FormatBool(schedule.Active),
+		})
+	}
+
+	return records
+}
+
+func (s *Service) scheduleRunRecords() [][]string {
+	var records [][]string
+	for _, run := range s.scheduleRuns {
+		records = append(records, []string{
+			run.ScheduleID,
+			formatTime(run.Time),
+			run.PaymentID,
+			string(run.Status),
+			url.QueryEscape(run.Error),
+		})
+	}
+
+	return records
+}
+
+func parseSchedule(record []string) (*types.Schedule, error) {
+	if len(record) < 12 {
+		return nil, ErrInvalidRecord
+	}
+
+	var err error
+	schedule := &types.Schedule{
+		ID:         record[0],
+		FavoriteID: record[1],
+		Frequency:  types.ScheduleFrequency(record[2]),
+		Cron:       record[3],
+	}
+	if schedule.Start, err = parseTime(record[4]); err != nil {
+		return nil, err
+	}
+	if schedule.End, err = parseTime(record[5]); err != nil {
+		return nil, err
+	}
+	if schedule.Count, err = strconv.Atoi(record[6]); err != nil {
+		return nil, err
+	}
+	if schedule.Next, err = parseTime(record[7]); err != nil {
+		return nil, err
+	}
+	if schedule.RetryAt, err = parseTime(record[8]); err != nil {
+		return nil, err
+	}
+	if schedule.Attempts, err = strconv.Atoi(record[9]); err != nil {
+		return nil, err
+	}
+	if schedule.Runs, err = strconv.Atoi(record[10]); err != nil {
+		return nil, err
+	}
+	if schedule.Active, err = strconv.ParseBool(record[11]); err != nil {
+		return nil, err
+	}
+
+	return schedule, nil
+}
+
+func parseScheduleRun(record []string) (*types.ScheduleRun, error) {
+	if len(record) < 5 {
+		return nil, ErrInvalidRecord
+	}
+
+	var err error
+	run := &types.ScheduleRun{
+		ScheduleID: record[0],
+		PaymentID:  record[2],
+		Status:     types.ScheduleRunStatus(record[3]),
+	}
+	if run.Time, err = parseTime(record[1]); err != nil {
+		return nil, err
+	}
+	if run.Error, err = url.QueryUnescape(record[4]); err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
@@ -0,0 +1,296 @@
+package wallet
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func (s *testService) addFavorite(balance types.Money, amount types.Money) (*types.Account, *types.Favorite, error) {
+	account, err := s.addAccountWithBalance("+992000000001", balance+amount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	payment, err := s.Pay(account.ID, amount, "auto")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	favorite, err := s.FavoritePayment(payment.ID, "fav")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return account, favorite, nil
+}
+
+func TestService_RunDueSchedules(t *testing.T) {
+	s := newTestService()
+	clock := &testClock{now: time.Date(2021, 1, 1, 9, 0, 0, 0, time.UTC)}
+	s.SetClock(clock)
+
+	account, favorite, err := s.addFavorite(10_000_00, 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	schedule, err := s.SchedulePayment(favorite.ID, ScheduleOptions{Frequency: types.ScheduleDaily, Count: 2})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 0; i < 4; i++ {
+		s.RunDueSchedules()
+		clock.advance(12 * time.Hour)
+	}
+
+	if account.Balance != 8_000_00 {
+		t.Errorf("invalid balance, expected: %v, actual: %v", types.Money(8_000_00), account.Balance)
+	}
+
+	if schedule.Active || schedule.Runs != 2 {
+		t.Errorf("schedule must finish after 2 runs, actual: %v", schedule)
+	}
+
+	runs, err := s.ScheduleRuns(schedule.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(runs) != 2 || runs[1].Status != types.ScheduleRunOk {
+		t.Errorf("invalid runs: %v", runs)
+	}
+}
+
+func TestService_RunDueSchedules_retry(t *testing.T) {
+	s := newTestService()
+	clock := &testClock{now: time.Date(2021, 1, 1, 9, 0, 0, 0, time.UTC)}
+	s.SetClock(clock)
+	s.SetRetryPolicy(RetryPolicy{Attempts: 2, Delay: time.Hour})
+
+	account, favorite, err := s.addFavorite(0, 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	schedule, err := s.SchedulePayment(favorite.ID, ScheduleOptions{Frequency: types.ScheduleWeekly})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	runs := s.RunDueSchedules()
+	if len(runs) != 1 || runs[0].Status != types.ScheduleRunRetry {
+		t.Errorf("invalid runs: %v", runs)
+		return
+	}
+
+	clock.advance(30 * time.Minute)
+	if runs := s.RunDueSchedules(); len(runs) != 0 {
+		t.Errorf("retry must wait for the delay, got runs: %v", runs)
+	}
+
+	clock.advance(30 * time.Minute)
+	runs = s.RunDueSchedules()
+	if len(runs) != 1 || runs[0].Status != types.ScheduleRunFail {
+		t.Errorf("invalid runs: %v", runs)
+		return
+	}
+
+	want := time.Date(2021, 1, 8, 9, 0, 0, 0, time.UTC)
+	if !schedule.Next.Equal(want) || !schedule.Active {
+		t.Errorf("occurrence must be skipped, expected next: %v, actual: %v", want, schedule.Next)
+	}
+
+	err = s.Deposit(account.ID, 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	clock.now = want
+	runs = s.RunDueSchedules()
+	if len(runs) != 1 || runs[0].Status != types.ScheduleRunOk {
+		t.Errorf("invalid runs: %v", runs)
+	}
+}
+
+func TestService_SchedulePayment_cron(t *testing.T) {
+	s := newTestService()
+	clock := &testClock{now: time.Date(2021, 3, 3, 12, 0, 0, 0, time.UTC)}
+	s.SetClock(clock)
+
+	_, favorite, err := s.addFavorite(10_000_00, 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	schedule, err := s.SchedulePayment(favorite.ID, ScheduleOptions{Frequency: types.ScheduleCron, Cron: "30 8 * * 1-5"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	want := time.Date(2021, 3, 4, 8, 30, 0, 0, time.UTC)
+	if !schedule.Next.Equal(want) {
+		t.Errorf("invalid next run, expected: %v, actual: %v", want, schedule.Next)
+	}
+
+	clock.now = want
+	s.RunDueSchedules()
+
+	want = time.Date(2021, 3, 5, 8, 30, 0, 0, time.UTC)
+	if !schedule.Next.Equal(want) {
+		t.Errorf("invalid next run, expected: %v, actual: %v", want, schedule.Next)
+	}
+
+	_, err = s.SchedulePayment(favorite.ID, ScheduleOptions{Frequency: types.ScheduleCron, Cron: "61 * * * *"})
+	if !errors.Is(err, ErrInvalidCron) {
+		t.Errorf("SchedulePayment(): must return ErrInvalidCron, returned %v", err)
+	}
+}
+
+func TestService_RunDueSchedules_afterEnd(t *testing.T) {
+	s := newTestService()
+	clock := &testClock{now: time.Date(2021, 3, 5, 12, 0, 0, 0, time.UTC)}
+	s.SetClock(clock)
+
+	_, favorite, err := s.addFavorite(10_000_00, 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	schedule, err := s.SchedulePayment(favorite.ID, ScheduleOptions{
+		Frequency: types.ScheduleCron,
+		Cron:      "30 8 * * 1",
+		End:       time.Date(2021, 3, 7, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	clock.now = time.Date(2021, 3, 8, 9, 0, 0, 0, time.UTC)
+	runs := s.RunDueSchedules()
+	if len(runs) != 0 || schedule.Active {
+		t.Errorf("schedule must end without paying, runs: %v, active: %v", runs, schedule.Active)
+	}
+}
+
+func TestAddMonths(t *testing.T) {
+	start := time.Date(2021, 1, 31, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		months int
+		want   time.Time
+	}{
+		{months: 1, want: time.Date(2021, 2, 28, 10, 0, 0, 0, time.UTC)},
+		{months: 2, want: time.Date(2021, 3, 31, 10, 0, 0, 0, time.UTC)},
+		{months: 13, want: time.Date(2022, 2, 28, 10, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		got := addMonths(start, test.months)
+		if !got.Equal(test.want) {
+			t.Errorf("addMonths(%v): expected: %v, actual: %v", test.months, test.want, got)
+		}
+	}
+}
+
+func TestExportImport_schedules(t *testing.T) {
+	s := newTestService()
+	s.SetClock(&testClock{now: time.Date(2021, 1, 1, 9, 0, 0, 0, time.UTC)})
+
+	_, favorite, err := s.addFavorite(10_000_00, 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.SchedulePayment(favorite.ID, ScheduleOptions{Frequency: types.ScheduleMonthly, Count: 12})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.RunDueSchedules()
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(imported.schedules) != 1 || len(imported.scheduleRuns) != 1 {
+		t.Errorf("invalid import, schedules: %v, runs: %v", imported.schedules, imported.scheduleRuns)
+		return
+	}
+
+	got := *imported.schedules[0]
+	want := *s.schedules[0]
+	if !got.Next.Equal(want.Next) || !got.Start.Equal(want.Start) {
+		t.Errorf("invalid schedule times, expected: %v, actual: %v", want, got)
+	}
+
+	got.Start, got.Next, want.Start, want.Next = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("invalid schedule, expected: %v, actual: %v", want, got)
+	}
+}
+
+func TestExportImport_scheduleRunError(t *testing.T) {
+	s := newTestService()
+	run := &types.ScheduleRun{
+		ScheduleID: "schedule",
+		Time:       time.Date(2021, 1, 1, 9, 0, 0, 0, time.UTC),
+		Status:     types.ScheduleRunFail,
+		Error:      "pay: not enough balance;\nretry 100% later",
+	}
+	s.scheduleRuns = append(s.scheduleRuns, run)
+
+	dir := t.TempDir()
+	err := s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(imported.scheduleRuns) != 1 || imported.scheduleRuns[0].Error != run.Error {
+		t.Errorf("invalid runs, expected: %v, actual: %v", run, imported.scheduleRuns)
+	}
+}
@@ -1,16 +1,17 @@
 package wallet
 
 import (
-	"bufio"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log"
-	"math"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/a1ishm/wallet/pkg/types"
 	"github.com/google/uuid"
@@ -22,7 +23,11 @@ var ErrAccountNotFound = errors.New("account not found")
 var ErrNotEnoughBalance = errors.New("not enough balance")
 var ErrPaymentNotFound = errors.New("payment(s) not found")
 var ErrFavoriteNotFound = errors.New("favorite not found")
+var ErrPaymentRejected = errors.New("payment already rejected")
 
+// Error is the error type used before WalletError.
+//
+// Deprecated: errors are WalletError values wrapping sentinel errors.
 type Error string
 
 func (e Error) Error() string {
@@ -30,16 +35,64 @@ func (e Error) Error() string {
 }
 
 type Service struct {
-	nextAccountID int64
-	accounts      []*types.Account
-	payments      []*types.Payment
-	favorites     []*types.Favorite
+	nextAccountID  int64
+	accounts       []*types.Account
+	payments       []*types.Payment
+	favorites      []*types.Favorite
+	favoritesLimit int
+	feeRules       []FeeRule
+	campaigns      []*Campaign
+	rewards        []*types.Reward
+	bonuses        map[int64]types.Money
+	clock          Clock
+	retryPolicy    RetryPolicy
+	schedules      []*types.Schedule
+	scheduleRuns   []*types.ScheduleRun
+
+	categories       map[types.PaymentCategory]*Category
+	categoryAliases  map[types.PaymentCategory]types.PaymentCategory
+	strictCategories bool
+
+	budgets            []*types.Budget
+	budgetAlerts       []*types.BudgetAlert
+	budgetAlertHandler func(alert types.BudgetAlert)
+
+	ledger   []*types.Entry
+	deposits []*types.Deposit
+
+	riskRules []RiskRule
+	reviews   []*types.RiskReview
+
+	approvalPolicies []*types.ApprovalPolicy
+	approvals        []*types.Approval
+
+	users       []*types.User
+	memberships []*types.Membership
+	sessions    []*types.Session
+	sessionTTL  time.Duration
+
+	actor    string
+	auditing *auditCall
+	auditLog []*types.AuditRecord
+
+	eventsMu      sync.Mutex
+	eventSeq      int64
+	subscriptions []*Subscription
+	outboxEnabled bool
+	outbox        []*types.OutboxMessage
+
+	webhooksMu  sync.Mutex
+	webhooks    []*types.Webhook
+	deadLetters []*types.DeadLetter
 }
 
-func (s *Service) RegisterAccount(phone types.Phone) (*types.Account, error) {
+func (s *Service) RegisterAccount(phone types.Phone) (_ *types.Account, err error) {
+	call := s.audit("RegisterAccount", 0, phone)
+	defer call.done(&err)
+
 	for _, account := range s.accounts {
 		if account.Phone == phone {
-			return nil, ErrPhoneRegistered
+			return nil, newError("RegisterAccount", ErrPhoneRegistered, 0, string(phone), 0)
 		}
 	}
 	s.nextAccountID++
@@ -47,17 +100,35 @@ func (s *Service) RegisterAccount(phone types.Phone) (*types.Account, error) {
 		ID:      s.nextAccountID,
 		Phone:   phone,
 		Balance: 0,
+		Created: s.now(),
 	}
 
 	s.accounts = append(s.accounts, account)
+	call.setAccount(account.ID)
+	s.publish(types.EventAccountRegistered, account.ID, string(phone), 0, "")
 	return account, nil
 }
 
-func (s *Service) Deposit(accountID int64, amount types.Money) error {
+func (s *Service) Deposit(accountID int64, amount types.Money) (err error) {
+	defer s.audit("Deposit", accountID, accountID, amount).done(&err)
+
+	_, err = s.DepositWithOptions(accountID, amount, DepositOptions{})
+	return err
+}
+
+func (s *Service) Pay(accountID int64, amount types.Money, category types.PaymentCategory) (_ *types.Payment, err error) {
+	defer s.audit("Pay", accountID, accountID, amount, category).done(&err)
+
 	if amount <= 0 {
-		return ErrAmountMustBePositive
+		return nil, newError("Pay", ErrAmountMustBePositive, accountID, "", amount)
 	}
 
+	resolved, err := s.paymentCategory("Pay", accountID, category)
+	if err != nil {
+		return nil, err
+	}
+	category = resolved
+
 	var account *types.Account
 	for _, acc := range s.accounts {
 		if acc.ID == accountID {
@@ -65,36 +136,26 @@ func (s *Service) Deposit(accountID int64, amount types.Money) error {
 			break
 		}
 	}
-
 	if account == nil {
-		return ErrAccountNotFound
+		return nil, newError("Pay", ErrAccountNotFound, accountID, "", amount)
 	}
 
-	account.Balance += amount
-	return nil
-}
-
-func (s *Service) Pay(accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
-	if amount <= 0 {
-		return nil, ErrAmountMustBePositive
+	err = s.checkBudgets(accountID, amount, category)
+	if err != nil {
+		return nil, err
 	}
 
-	var account *types.Account
-	for _, acc := range s.accounts {
-		if acc.ID == accountID {
-			account = acc
-			break
-		}
-	}
-	if account == nil {
-		return nil, ErrAccountNotFound
+	fee := s.feeFor(account, amount, category)
+	if account.Balance < amount+fee {
+		return nil, newError("Pay", ErrNotEnoughBalance, accountID, "", amount+fee)
 	}
 
-	if account.Balance < amount {
-		return nil, ErrNotEnoughBalance
+	action, reasons := s.checkRisk(account, amount, category)
+	if action == RiskDeny {
+		return nil, newError("Pay", fmt.Errorf("%w: %v", ErrPaymentDenied, strings.Join(reasons, ", ")), accountID, "", amount)
 	}
 
-	account.Balance -= amount
+	account.Balance -= amount + fee
 	paymentID := uuid.New().String()
 	payment := &types.Payment{
 		ID:        paymentID,
@@ -102,11 +163,65 @@ func (s *Service) Pay(accountID int64, amount types.Money, category types.Paymen
 		Amount:    amount,
 		Category:  category,
 		Status:    types.PaymentStatusInProgress,
+		Fee:       fee,
+		Created:   s.now(),
+	}
+	approval := s.requireApproval(payment)
+	if approval != nil {
+		payment.Status = types.PaymentStatusPending
+		s.approvals = append(s.approvals, approval)
+	}
+	if action == RiskHold {
+		payment.Status = types.PaymentStatusReview
+		s.reviews = append(s.reviews, &types.RiskReview{
+			PaymentID: paymentID,
+			AccountID: accountID,
+			Amount:    amount,
+			Reasons:   reasons,
+			Created:   payment.Created,
+		})
 	}
 	s.payments = append(s.payments, payment)
+	s.record(accountID, types.EntryPayment, -(amount + fee), -fee, paymentID)
+	switch payment.Status {
+	case types.PaymentStatusReview:
+		s.publish(types.EventPaymentHeld, accountID, paymentID, amount, category)
+	case types.PaymentStatusPending:
+		s.publish(types.EventPaymentPending, accountID, paymentID, amount, category)
+	default:
+		s.startPayment(account, payment, types.EventPaymentCreated)
+	}
 	return payment, nil
 }
 
+// startPayment moves the payment to IN_PROGRESS. Payments held for review
+// or waiting for approval get here once they are let through, only then
+// they count for budgets and earn cashback.
+func (s *Service) startPayment(account *types.Account, payment *types.Payment, event types.EventType) {
+	payment.Status = types.PaymentStatusInProgress
+	s.trackBudgets(payment)
+	s.applyRewards(account, payment)
+	s.publish(event, account.ID, payment.ID, payment.Amount, payment.Category)
+}
+
+// releasePayment starts a payment that was held for review or waited for
+// approval. Hard budgets are checked again, as other payments may have been
+// made meanwhile; the payment is left as it is if it would exceed one.
+func (s *Service) releasePayment(op string, account *types.Account, payment *types.Payment, event types.EventType) error {
+	err := s.checkBudgets(account.ID, payment.Amount, payment.Category)
+	if err != nil {
+		return newError(op, err, account.ID, payment.ID, payment.Amount)
+	}
+
+	s.startPayment(account, payment, event)
+	return nil
+}
+
+// started reports whether the payment got to IN_PROGRESS.
+func started(payment *types.Payment) bool {
+	return payment.Status != types.PaymentStatusReview && payment.Status != types.PaymentStatusPending
+}
+
 func (s *Service) FindAccountByID(accountID int64) (*types.Account, error) {
 	var account *types.Account
 	for _, acc := range s.accounts {
@@ -117,12 +232,22 @@ func (s *Service) FindAccountByID(accountID int64) (*types.Account, error) {
 	}
 
 	if account == nil {
-		return nil, ErrAccountNotFound
+		return nil, newError("FindAccountByID", ErrAccountNotFound, accountID, "", 0)
 	}
 
 	return account, nil
 }
 
+// Accounts returns all accounts in the order they were registered.
+func (s *Service) Accounts() []types.Account {
+	accounts := make([]types.Account, 0, len(s.accounts))
+	for _, account := range s.accounts {
+		accounts = append(accounts, *account)
+	}
+
+	return accounts
+}
+
 func (s *Service) FindPaymentByID(paymentID string) (*types.Payment, error) {
 	var payment *types.Payment
 	for _, paym := range s.payments {
@@ -133,29 +258,48 @@ func (s *Service) FindPaymentByID(paymentID string) (*types.Payment, error) {
 	}
 
 	if payment == nil {
-		return nil, ErrPaymentNotFound
+		return nil, newError("FindPaymentByID", ErrPaymentNotFound, 0, paymentID, 0)
 	}
 
 	return payment, nil
 }
 
-func (s *Service) Reject(paymentID string) error {
+func (s *Service) Reject(paymentID string) (err error) {
+	defer s.audit("Reject", 0, paymentID).done(&err)
+
 	payment, err := s.FindPaymentByID(paymentID)
 	if err != nil {
 		return err
 	}
 
+	if payment.Status == types.PaymentStatusFail {
+		return newError("Reject", ErrPaymentRejected, payment.AccountID, paymentID, 0)
+	}
+
+	return s.rejectPayment(payment, types.EventPaymentRejected)
+}
+
+// rejectPayment fails the payment, returns its funds and publishes event.
+func (s *Service) rejectPayment(payment *types.Payment, event types.EventType) error {
 	account, err := s.FindAccountByID(payment.AccountID)
 	if err != nil {
 		return err
 	}
 
+	if started(payment) {
+		s.clawbackRewards(account, payment)
+		s.untrackBudgets(payment)
+	}
 	payment.Status = types.PaymentStatusFail
-	account.Balance += payment.Amount
+	account.Balance += payment.Amount + payment.Fee
+	s.record(account.ID, types.EntryRefund, payment.Amount+payment.Fee, payment.Fee, payment.ID)
+	s.publish(event, account.ID, payment.ID, payment.Amount, payment.Category)
 	return nil
 }
 
-func (s *Service) Repeat(paymentID string) (*types.Payment, error) {
+func (s *Service) Repeat(paymentID string) (_ *types.Payment, err error) {
+	defer s.audit("Repeat", 0, paymentID).done(&err)
+
 	payment, err := s.FindPaymentByID(paymentID)
 	if err != nil {
 		return nil, err
@@ -169,12 +313,24 @@ func (s *Service) Repeat(paymentID string) (*types.Payment, error) {
 	return repeated, nil
 }
 
-func (s *Service) FavoritePayment(paymentID string, name string) (*types.Favorite, error) {
+func (s *Service) FavoritePayment(paymentID string, name string) (_ *types.Favorite, err error) {
+	defer s.audit("FavoritePayment", 0, paymentID, name).done(&err)
+
 	payment, err := s.FindPaymentByID(paymentID)
 	if err != nil {
 		return nil, err
 	}
 
+	err = s.checkFavoriteName("FavoritePayment", payment.AccountID, "", name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.checkFavoritesLimit("FavoritePayment", payment.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
 	favorite := &types.Favorite{
 		ID:        uuid.New().String(),
 		AccountID: payment.AccountID,
@@ -184,6 +340,7 @@ func (s *Service) FavoritePayment(paymentID string, name string) (*types.Favorit
 	}
 
 	s.favorites = append(s.favorites, favorite)
+	s.publish(types.EventFavoriteCreated, favorite.AccountID, favorite.ID, favorite.Amount, favorite.Category)
 	return favorite, nil
 }
 
@@ -197,13 +354,15 @@ func (s *Service) FindFavoriteByID(favoriteID string) (*types.Favorite, error) {
 	}
 
 	if favorite == nil {
-		return nil, ErrFavoriteNotFound
+		return nil, newError("FindFavoriteByID", ErrFavoriteNotFound, 0, favoriteID, 0)
 	}
 
 	return favorite, nil
 }
 
-func (s *Service) PayFromFavorite(favoriteID string) (*types.Payment, error) {
+func (s *Service) PayFromFavorite(favoriteID string) (_ *types.Payment, err error) {
+	defer s.audit("PayFromFavorite", 0, favoriteID).done(&err)
+
 	favorite, err := s.FindFavoriteByID(favoriteID)
 	if err != nil {
 		return nil, err
@@ -255,7 +414,9 @@ func (s *Service) ExportToFile(path string) error {
 	return nil
 }
 
-func (s *Service) ImportFromFile(path string) error {
+func (s *Service) ImportFromFile(path string) (err error) {
+	defer s.audit("ImportFromFile", 0, path).done(&err)
+
 	file, err := os.Open(path)
 	if err != nil {
 		return err
@@ -313,372 +474,739 @@ func (s *Service) ImportFromFile(path string) error {
 }
 
 func (s *Service) Export(dir string) error {
-	var err error
+	return s.ExportContext(context.Background(), dir, nil)
+}
+
+// ExportContext is Export that stops when ctx is done. The dumps are
+// replaced only after all of them were written, so a cancelled export leaves
+// no partially written files behind. progress, if not nil, receives the
+// number of records written.
+func (s *Service) ExportContext(ctx context.Context, dir string, progress ProgressFunc) error {
 	abs, err := filepath.Abs(dir)
 	if err != nil {
 		return err
 	}
-	aPath := abs + "/accounts.dump" // если не примет, то убрать слэш в названии файла
-	pPath := abs + "/payments.dump"
-	fPath := abs + "/favorites.dump"
 
-	var accounts *os.File
-	var payments *os.File
-	var favorites *os.File
+	return ioError("Export", abs, writeDumps(ctx, abs, s.dumpFiles(), progress))
+}
+
+func (s *Service) dumpFiles() []dumpFile {
+	accounts := s.accounts
+	payments := s.payments
+	favorites := s.favorites
+
+	return []dumpFile{
+		{
+			name:   "accounts.dump",
+			count:  len(accounts),
+			line:   func(i int) string { return accountRecord(accounts[i]) },
+			legacy: true,
+		},
+		{
+			name:   "payments.dump",
+			count:  len(payments),
+			line:   func(i int) string { return paymentRecord(payments[i]) },
+			legacy: true,
+		},
+		{
+			name:   "favorites.dump",
+			count:  len(favorites),
+			line:   func(i int) string { return favoriteRecord(favorites[i]) },
+			legacy: true,
+		},
+		recordsDump("schedules.dump", s.scheduleRecords()),
+		recordsDump("schedule_runs.dump", s.scheduleRunRecords()),
+		recordsDump("budgets.dump", s.budgetRecords()),
+		recordsDump("campaigns.dump", s.campaignRecords()),
+		recordsDump("rewards.dump", s.rewardRecords()),
+		recordsDump("bonuses.dump", s.bonusRecords()),
+		recordsDump("categories.dump", s.categoryRecords()),
+		recordsDump("ledger.dump", s.ledgerRecords()),
+		recordsDump("deposits.dump", s.depositRecords()),
+		recordsDump("audit.dump", s.auditRecords()),
+		recordsDump("webhooks.dump", s.webhookRecords()),
+		recordsDump("dead_letters.dump", s.deadLetterRecords()),
+		recordsDump("outbox.dump", s.outboxRecords()),
+		recordsDump("reviews.dump", s.reviewRecords()),
+		recordsDump("approval_policies.dump", s.approvalPolicyRecords()),
+		recordsDump("approvals.dump", s.approvalRecords()),
+		recordsDump("users.dump", s.userRecords()),
+		recordsDump("memberships.dump", s.membershipRecords()),
+		recordsDump("sessions.dump", s.sessionRecords()),
+	}
+}
+
+func accountRecord(account *types.Account) string {
+	id := strconv.Itoa(int(account.ID))
+	phone := string(account.Phone)
+	balance := strconv.Itoa(int(account.Balance))
+
+	return id + ";" + phone + ";" + balance + optionalFields(string(account.Tier), formatTime(account.Created))
+}
 
-	aExist := true
-	pExist := true
-	fExist := true
+func paymentRecord(payment *types.Payment) string {
+	id := payment.ID
+	accountID := strconv.Itoa(int(payment.AccountID))
+	amount := strconv.Itoa(int(payment.Amount))
+	category := string(payment.Category)
+	status := string(payment.Status)
 
-	if len(s.accounts) == 0 {
-		aExist = false
+	return id + ";" + accountID + ";" + amount + ";" + category + ";" + status + optionalFields(optionalMoney(payment.Fee), formatTime(payment.Created))
+}
+
+func favoriteRecord(favorite *types.Favorite) string {
+	id := favorite.ID
+	accountID := strconv.Itoa(int(favorite.AccountID))
+	name := favorite.Name
+	amount := strconv.Itoa(int(favorite.Amount))
+	category := string(favorite.Category)
+
+	return id + ";" + accountID + ";" + name + ";" + amount + ";" + category
+}
+
+func parseAccount(props []string) (*types.Account, error) {
+	if len(props) < 3 {
+		return nil, ErrInvalidRecord
 	}
-	if len(s.payments) == 0 {
-		pExist = false
+
+	id, err := strconv.Atoi(props[0])
+	if err != nil {
+		return nil, err
 	}
-	if len(s.favorites) == 0 {
-		fExist = false
+	balance, err := strconv.Atoi(props[2])
+	if err != nil {
+		return nil, err
 	}
 
-	if aExist {
-		accounts, err = os.Create(aPath)
-		if err != nil {
-			return err
-		}
+	created, err := parseTime(optionalField(props, 4))
+	if err != nil {
+		return nil, err
 	}
-	if pExist {
-		payments, err = os.Create(pPath)
-		if err != nil {
-			return err
-		}
+
+	return &types.Account{
+		ID:      int64(id),
+		Phone:   types.Phone(props[1]),
+		Balance: types.Money(balance),
+		Tier:    types.AccountTier(optionalField(props, 3)),
+		Created: created,
+	}, nil
+}
+
+func parsePayment(props []string) (*types.Payment, error) {
+	if len(props) < 5 {
+		return nil, ErrInvalidRecord
 	}
-	if fExist {
-		favorites, err = os.Create(fPath)
+
+	accountID, err := strconv.Atoi(props[1])
+	if err != nil {
+		return nil, err
+	}
+	amount, err := strconv.Atoi(props[2])
+	if err != nil {
+		return nil, err
+	}
+
+	payment := &types.Payment{
+		ID:        props[0],
+		AccountID: int64(accountID),
+		Amount:    types.Money(amount),
+		Category:  types.PaymentCategory(props[3]),
+		Status:    types.PaymentStatus(props[4]),
+	}
+	if fee := optionalField(props, 5); fee != "" {
+		value, err := strconv.Atoi(fee)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		payment.Fee = types.Money(value)
+	}
+	payment.Created, err = parseTime(optionalField(props, 6))
+	if err != nil {
+		return nil, err
 	}
 
-	aData := ""
-	pData := ""
-	fData := ""
+	return payment, nil
+}
 
-	for i, account := range s.accounts {
-		if !aExist {
-			break
-		}
+func parseFavorite(props []string) (*types.Favorite, error) {
+	if len(props) < 5 {
+		return nil, ErrInvalidRecord
+	}
 
-		id := strconv.Itoa(int(account.ID))
-		phone := string(account.Phone)
-		balance := strconv.Itoa(int(account.Balance))
+	accountID, err := strconv.Atoi(props[1])
+	if err != nil {
+		return nil, err
+	}
+	amount, err := strconv.Atoi(props[3])
+	if err != nil {
+		return nil, err
+	}
 
-		line := id + ";" + phone + ";" + balance + "\n"
-		if i == len(s.accounts)-1 {
-			line = id + ";" + phone + ";" + balance
-		}
+	return &types.Favorite{
+		ID:        props[0],
+		AccountID: int64(accountID),
+		Name:      props[2],
+		Amount:    types.Money(amount),
+		Category:  types.PaymentCategory(props[4]),
+	}, nil
+}
 
-		aData += line
+// optionalFields renders fields that were added to the dump formats later.
+// Empty trailing fields are omitted, so records that don't use them keep
+// the original format and stay readable by older versions.
+func optionalFields(fields ...string) string {
+	last := len(fields)
+	for last > 0 && fields[last-1] == "" {
+		last--
 	}
 
-	for i, payment := range s.payments {
-		if !pExist {
-			break
-		}
+	line := ""
+	for _, field := range fields[:last] {
+		line += ";" + field
+	}
 
-		id := payment.ID
-		accountID := strconv.Itoa(int(payment.AccountID))
-		amount := strconv.Itoa(int(payment.Amount))
-		category := string(payment.Category)
-		status := string(payment.Status)
+	return line
+}
 
-		line := id + ";" + accountID + ";" + amount + ";" + category + ";" + status + "\n"
-		if i == len(s.payments)-1 {
-			line = id + ";" + accountID + ";" + amount + ";" + category + ";" + status
-		}
+func optionalMoney(value types.Money) string {
+	if value == 0 {
+		return ""
+	}
 
-		pData += line
+	return strconv.Itoa(int(value))
+}
+
+func optionalField(props []string, i int) string {
+	if i >= len(props) {
+		return ""
 	}
 
-	for i, favorite := range s.favorites {
-		if !fExist {
-			break
+	return strings.Trim(props[i], "\n")
+}
+
+func (s *Service) Import(dir string) (err error) {
+	defer s.audit("Import", 0, dir).done(&err)
+
+	return s.ImportContext(context.Background(), dir, nil)
+}
+
+var dumpNames = []string{
+	"accounts.dump",
+	"payments.dump",
+	"favorites.dump",
+	"schedules.dump",
+	"schedule_runs.dump",
+	"budgets.dump",
+	"campaigns.dump",
+	"rewards.dump",
+	"bonuses.dump",
+	"categories.dump",
+	"ledger.dump",
+	"deposits.dump",
+	"audit.dump",
+	"webhooks.dump",
+	"dead_letters.dump",
+	"outbox.dump",
+	"reviews.dump",
+	"approval_policies.dump",
+	"approvals.dump",
+	"users.dump",
+	"memberships.dump",
+	"sessions.dump",
+}
+
+// ImportContext is Import that stops when ctx is done. All dumps are read
+// and parsed before the service is changed, so a cancelled or failed import
+// leaves it as it was. progress, if not nil, receives the number of bytes
+// read. The audit log is taken from the dump, and records made here that it
+// doesn't have are chained after it.
+func (s *Service) ImportContext(ctx context.Context, dir string, progress ProgressFunc) (err error) {
+	defer s.audit("ImportContext", 0, dir).done(&err)
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	err = commitDumps(abs)
+	if err != nil {
+		return ioError("Import", abs, err)
+	}
+
+	total := 0
+	for _, name := range dumpNames {
+		total += dumpSize(filepath.Join(abs, name))
+	}
+	tracker := newProgressTracker(progress, total)
+
+	dumps := make(map[string][][]string)
+	for _, name := range dumpNames {
+		records, err := readDumpContext(ctx, filepath.Join(abs, name), tracker)
+		if err != nil {
+			return ioError("Import", filepath.Join(abs, name), err)
 		}
+		dumps[name] = records
+	}
 
-		id := favorite.ID
-		accountID := strconv.Itoa(int(favorite.AccountID))
-		name := favorite.Name
-		amount := strconv.Itoa(int(favorite.Amount))
-		category := string(favorite.Category)
+	var accounts []*types.Account
+	for i, record := range dumps["accounts.dump"] {
+		account, err := parseAccount(record)
+		if err != nil {
+			return dumpError("Import", "accounts.dump", i, err)
+		}
+		accounts = append(accounts, account)
+	}
 
-		line := id + ";" + accountID + ";" + name + ";" + amount + ";" + category + "\n"
-		if i == len(s.favorites)-1 {
-			line = id + ";" + accountID + ";" + name + ";" + amount + ";" + category
+	var payments []*types.Payment
+	for i, record := range dumps["payments.dump"] {
+		payment, err := parsePayment(record)
+		if err != nil {
+			return dumpError("Import", "payments.dump", i, err)
 		}
+		payments = append(payments, payment)
+	}
 
-		fData += line
+	var favorites []*types.Favorite
+	for i, record := range dumps["favorites.dump"] {
+		favorite, err := parseFavorite(record)
+		if err != nil {
+			return dumpError("Import", "favorites.dump", i, err)
+		}
+		favorites = append(favorites, favorite)
 	}
 
-	if aExist {
-		_, err = accounts.Write([]byte(aData))
+	var schedules []*types.Schedule
+	for i, record := range dumps["schedules.dump"] {
+		schedule, err := parseSchedule(record)
 		if err != nil {
-			return err
+			return dumpError("Import", "schedules.dump", i, err)
 		}
+		schedules = append(schedules, schedule)
 	}
-	if pExist {
-		_, err = payments.Write([]byte(pData))
+
+	var runs []*types.ScheduleRun
+	for i, record := range dumps["schedule_runs.dump"] {
+		run, err := parseScheduleRun(record)
 		if err != nil {
-			return err
+			return dumpError("Import", "schedule_runs.dump", i, err)
 		}
+		runs = append(runs, run)
 	}
-	if fExist {
-		_, err = favorites.Write([]byte(fData))
+
+	var budgets []*types.Budget
+	for i, record := range dumps["budgets.dump"] {
+		budget, err := parseBudget(record)
 		if err != nil {
-			return err
+			return dumpError("Import", "budgets.dump", i, err)
 		}
+		budgets = append(budgets, budget)
 	}
 
-	if aExist {
-		err = accounts.Close()
+	var campaigns []*Campaign
+	for i, record := range dumps["campaigns.dump"] {
+		campaign, err := parseCampaign(record)
 		if err != nil {
-			return err
+			return dumpError("Import", "campaigns.dump", i, err)
 		}
+		campaigns = append(campaigns, campaign)
 	}
-	if pExist {
-		err = payments.Close()
+
+	var rewards []*types.Reward
+	for i, record := range dumps["rewards.dump"] {
+		reward, err := parseReward(record)
 		if err != nil {
-			return err
+			return dumpError("Import", "rewards.dump", i, err)
 		}
+		rewards = append(rewards, reward)
 	}
-	if fExist {
-		err = favorites.Close()
+
+	bonuses := make(map[int64]types.Money)
+	for i, record := range dumps["bonuses.dump"] {
+		accountID, amount, err := parseBonus(record)
 		if err != nil {
-			return err
+			return dumpError("Import", "bonuses.dump", i, err)
 		}
+		bonuses[accountID] = amount
 	}
 
-	return nil
-}
+	registry := &categoryRegistry{}
+	for i, record := range dumps["categories.dump"] {
+		err = registry.parse(record)
+		if err != nil {
+			return dumpError("Import", "categories.dump", i, err)
+		}
+	}
 
-func (s *Service) Import(dir string) error {
-	abs, err := filepath.Abs(dir)
-	if err != nil {
-		return err
+	var ledger []*types.Entry
+	for i, record := range dumps["ledger.dump"] {
+		entry, err := parseEntry(record)
+		if err != nil {
+			return dumpError("Import", "ledger.dump", i, err)
+		}
+		ledger = append(ledger, entry)
 	}
 
-	aPath := abs + "/accounts.dump" // если не примет, то убрать слэш в названии файла
-	pPath := abs + "/payments.dump"
-	fPath := abs + "/favorites.dump"
+	var deposits []*types.Deposit
+	for i, record := range dumps["deposits.dump"] {
+		deposit, err := parseDeposit(record)
+		if err != nil {
+			return dumpError("Import", "deposits.dump", i, err)
+		}
+		deposits = append(deposits, deposit)
+	}
 
-	aExist := true
-	pExist := true
-	fExist := true
-	eof := false
-	accountFound := false
-	var reader *bufio.Reader
-	var nextAccountID int64
+	var auditLog []*types.AuditRecord
+	var auditRecords []types.AuditRecord
+	for i, record := range dumps["audit.dump"] {
+		auditRecord, err := parseAuditRecord(record)
+		if err != nil {
+			return dumpError("Import", "audit.dump", i, err)
+		}
+		auditLog = append(auditLog, auditRecord)
+		auditRecords = append(auditRecords, *auditRecord)
+	}
+	var webhooks []*types.Webhook
+	for i, record := range dumps["webhooks.dump"] {
+		webhook, err := parseWebhook(record)
+		if err != nil {
+			return dumpError("Import", "webhooks.dump", i, err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
 
-	accounts, err := os.Open(aPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			aExist = false
-		} else {
-			return err
+	var deadLetters []*types.DeadLetter
+	for i, record := range dumps["dead_letters.dump"] {
+		letter, err := parseDeadLetter(record)
+		if err != nil {
+			return dumpError("Import", "dead_letters.dump", i, err)
 		}
+		deadLetters = append(deadLetters, letter)
 	}
-	payments, err := os.Open(pPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			pExist = false
-		} else {
-			return err
+
+	var outbox []*types.OutboxMessage
+	for i, record := range dumps["outbox.dump"] {
+		message, err := parseOutboxMessage(record)
+		if err != nil {
+			return dumpError("Import", "outbox.dump", i, err)
 		}
+		outbox = append(outbox, message)
 	}
-	favorites, err := os.Open(fPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			fExist = false
-		} else {
-			return err
+
+	var reviews []*types.RiskReview
+	for i, record := range dumps["reviews.dump"] {
+		review, err := parseReview(record)
+		if err != nil {
+			return dumpError("Import", "reviews.dump", i, err)
 		}
+		reviews = append(reviews, review)
 	}
 
-	if aExist {
-		reader = bufio.NewReader(accounts)
+	var policies []*types.ApprovalPolicy
+	for i, record := range dumps["approval_policies.dump"] {
+		policy, err := parseApprovalPolicy(record)
+		if err != nil {
+			return dumpError("Import", "approval_policies.dump", i, err)
+		}
+		policies = append(policies, policy)
 	}
-	for {
-		if !aExist {
-			break
+
+	var approvals []*types.Approval
+	for i, record := range dumps["approvals.dump"] {
+		approval, err := parseApproval(record)
+		if err != nil {
+			return dumpError("Import", "approvals.dump", i, err)
 		}
+		approvals = append(approvals, approval)
+	}
 
-		line, err := reader.ReadString('\n') // есть очень тупая идея на запас*
-		if err == io.EOF {
-			eof = true
+	var users []*types.User
+	for i, record := range dumps["users.dump"] {
+		user, err := parseUser(record)
+		if err != nil {
+			return dumpError("Import", "users.dump", i, err)
 		}
-		var account types.Account
-		aProps := strings.Split(line, ";")
+		users = append(users, user)
+	}
 
-		id, err := strconv.Atoi(strings.Trim(aProps[0], "\n"))
+	var memberships []*types.Membership
+	for i, record := range dumps["memberships.dump"] {
+		membership, err := parseMembership(record)
 		if err != nil {
-			return err
+			return dumpError("Import", "memberships.dump", i, err)
 		}
-		phone := strings.Trim(aProps[1], "\n")
-		balance, err := strconv.Atoi(strings.Trim(aProps[2], "\n"))
+		memberships = append(memberships, membership)
+	}
+
+	var sessions []*types.Session
+	for i, record := range dumps["sessions.dump"] {
+		session, err := parseSession(record)
 		if err != nil {
-			return err
+			return dumpError("Import", "sessions.dump", i, err)
 		}
+		sessions = append(sessions, session)
+	}
 
-		account.ID = int64(id)
-		account.Phone = types.Phone(phone)
-		account.Balance = types.Money(balance)
+	err = VerifyAuditLog(auditRecords)
+	if err != nil {
+		return newError("Import", err, 0, "audit.dump", 0)
+	}
 
+	for _, account := range accounts {
+		found := false
 		for i, acc := range s.accounts {
 			if acc.ID == account.ID {
-				s.accounts[i] = &account
-				accountFound = true
+				s.accounts[i] = account
+				found = true
 				break
 			}
 		}
-		if !accountFound {
-			s.accounts = append(s.accounts, &account)
+		if !found {
+			s.accounts = append(s.accounts, account)
 		}
-		accountFound = false
+	}
 
-		if eof {
-			break
+	for _, payment := range payments {
+		found := false
+		for i, paym := range s.payments {
+			if paym.ID == payment.ID {
+				s.payments[i] = payment
+				found = true
+				break
+			}
+		}
+		if !found {
+			s.payments = append(s.payments, payment)
 		}
 	}
-	eof = false
 
-	if pExist {
-		reader = bufio.NewReader(payments)
-	}
-	for {
-		if !pExist {
-			break
+	for _, favorite := range favorites {
+		found := false
+		for i, fav := range s.favorites {
+			if fav.ID == favorite.ID {
+				s.favorites[i] = favorite
+				found = true
+				break
+			}
 		}
-
-		line, err := reader.ReadString('\n') // есть очень тупая идея на запас*
-		if err == io.EOF {
-			eof = true
+		if !found {
+			s.favorites = append(s.favorites, favorite)
 		}
-		var payment types.Payment
-		pProps := strings.Split(line, ";")
+	}
 
-		id := strings.Trim(pProps[0], "\n")
-		accountID, err := strconv.Atoi(strings.Trim(pProps[1], "\n"))
-		if err != nil {
-			return err
+	for _, schedule := range schedules {
+		found := false
+		for i, sched := range s.schedules {
+			if sched.ID == schedule.ID {
+				s.schedules[i] = schedule
+				found = true
+				break
+			}
 		}
-		amount, err := strconv.Atoi(strings.Trim(pProps[2], "\n"))
-		if err != nil {
-			return err
+		if !found {
+			s.schedules = append(s.schedules, schedule)
 		}
-		category := strings.Trim(pProps[3], "\n")
-		status := strings.Trim(pProps[4], "\n")
+	}
 
-		payment.ID = id
-		payment.AccountID = int64(accountID)
-		payment.Amount = types.Money(amount)
-		payment.Category = types.PaymentCategory(category)
-		payment.Status = types.PaymentStatus(status)
+	if dumps["schedule_runs.dump"] != nil {
+		s.scheduleRuns = runs
+	}
 
-		for i, paym := range s.payments {
-			if paym.ID == payment.ID {
-				s.payments[i] = &payment
-				accountFound = true
+	for _, budget := range budgets {
+		found := false
+		for i, b := range s.budgets {
+			if b.ID == budget.ID {
+				s.budgets[i] = budget
+				found = true
 				break
 			}
 		}
-		if !accountFound {
-			s.payments = append(s.payments, &payment)
+		if !found {
+			s.budgets = append(s.budgets, budget)
 		}
-		accountFound = false
+	}
 
-		if eof {
-			break
+	for _, campaign := range campaigns {
+		found := false
+		for i, c := range s.campaigns {
+			if c.ID == campaign.ID {
+				s.campaigns[i] = campaign
+				found = true
+				break
+			}
+		}
+		if !found {
+			s.campaigns = append(s.campaigns, campaign)
+		}
+	}
+
+	for _, reward := range rewards {
+		found := false
+		for i, r := range s.rewards {
+			if r.ID == reward.ID {
+				s.rewards[i] = reward
+				found = true
+				break
+			}
+		}
+		if !found {
+			s.rewards = append(s.rewards, reward)
 		}
 	}
-	eof = false
 
-	if fExist {
-		reader = bufio.NewReader(favorites)
+	if len(bonuses) != 0 && s.bonuses == nil {
+		s.bonuses = make(map[int64]types.Money)
 	}
-	for {
-		if !fExist {
-			break
+	for accountID, amount := range bonuses {
+		s.bonuses[accountID] = amount
+	}
+
+	if dumps["categories.dump"] != nil {
+		s.importCategories(registry)
+	}
+
+	if dumps["ledger.dump"] != nil {
+		s.ledger = ledger
+	}
+
+	if dumps["audit.dump"] != nil {
+		s.importAuditLog(auditLog)
+	}
+
+	for _, deposit := range deposits {
+		found := false
+		for i, dep := range s.deposits {
+			if dep.ID == deposit.ID {
+				s.deposits[i] = deposit
+				found = true
+				break
+			}
 		}
+		if !found {
+			s.deposits = append(s.deposits, deposit)
+		}
+	}
 
-		line, err := reader.ReadString('\n') // есть очень тупая идея на запас*
-		if err == io.EOF {
-			eof = true
+	for _, review := range reviews {
+		found := false
+		for i, item := range s.reviews {
+			if item.PaymentID == review.PaymentID {
+				s.reviews[i] = review
+				found = true
+				break
+			}
 		}
-		var favorite types.Favorite
-		fProps := strings.Split(line, ";")
+		if !found {
+			s.reviews = append(s.reviews, review)
+		}
+	}
 
-		id := strings.Trim(fProps[0], "\n")
-		accountID, err := strconv.Atoi(strings.Trim(fProps[1], "\n"))
-		if err != nil {
-			return err
+	for _, policy := range policies {
+		found := false
+		for i, item := range s.approvalPolicies {
+			if item.AccountID == policy.AccountID {
+				s.approvalPolicies[i] = policy
+				found = true
+				break
+			}
 		}
-		name := strings.Trim(fProps[2], "\n")
-		amount, err := strconv.Atoi(strings.Trim(fProps[3], "\n"))
-		if err != nil {
-			return err
+		if !found {
+			s.approvalPolicies = append(s.approvalPolicies, policy)
 		}
-		category := strings.Trim(fProps[4], "\n")
+	}
 
-		favorite.ID = id
-		favorite.AccountID = int64(accountID)
-		favorite.Name = name
-		favorite.Amount = types.Money(amount)
-		favorite.Category = types.PaymentCategory(category)
+	for _, approval := range approvals {
+		found := false
+		for i, item := range s.approvals {
+			if item.PaymentID == approval.PaymentID {
+				s.approvals[i] = approval
+				found = true
+				break
+			}
+		}
+		if !found {
+			s.approvals = append(s.approvals, approval)
+		}
+	}
 
-		for i, fav := range s.favorites {
-			if fav.ID == favorite.ID {
-				s.favorites[i] = &favorite
-				accountFound = true
+	for _, user := range users {
+		found := false
+		for i, item := range s.users {
+			if item.ID == user.ID {
+				s.users[i] = user
+				found = true
 				break
 			}
 		}
-		if !accountFound {
-			s.favorites = append(s.favorites, &favorite)
+		if !found {
+			s.users = append(s.users, user)
 		}
-		accountFound = false
+	}
 
-		if eof {
-			break
+	for _, membership := range memberships {
+		item := s.findMembership(membership.UserID, membership.AccountID)
+		if item != nil {
+			item.Role = membership.Role
+			continue
 		}
+		s.memberships = append(s.memberships, membership)
 	}
 
-	for _, acc := range s.accounts {
-		if acc.ID > nextAccountID {
-			nextAccountID = acc.ID
+	for _, session := range sessions {
+		found := false
+		for i, item := range s.sessions {
+			if item.TokenHash == session.TokenHash {
+				s.sessions[i] = session
+				found = true
+				break
+			}
+		}
+		if !found {
+			s.sessions = append(s.sessions, session)
 		}
 	}
-	s.nextAccountID = nextAccountID
 
-	if aExist {
-		err = accounts.Close()
-		if err != nil {
-			return err
+	s.webhooksMu.Lock()
+	for _, webhook := range webhooks {
+		found := false
+		for i, hook := range s.webhooks {
+			if hook.ID == webhook.ID {
+				s.webhooks[i] = webhook
+				found = true
+				break
+			}
+		}
+		if !found {
+			s.webhooks = append(s.webhooks, webhook)
 		}
 	}
-	if pExist {
-		err = payments.Close()
-		if err != nil {
-			return err
+	if dumps["dead_letters.dump"] != nil {
+		s.deadLetters = deadLetters
+	}
+	s.webhooksMu.Unlock()
+
+	s.eventsMu.Lock()
+	if dumps["outbox.dump"] != nil {
+		s.importOutbox(outbox)
+		s.outboxEnabled = true
+	}
+	for _, message := range s.outbox {
+		if message.Event.Seq > s.eventSeq {
+			s.eventSeq = message.Event.Seq
 		}
 	}
-	if fExist {
-		err = favorites.Close()
-		if err != nil {
-			return err
+	s.eventsMu.Unlock()
+
+	for _, acc := range s.accounts {
+		if acc.ID > s.nextAccountID {
+			s.nextAccountID = acc.ID
 		}
 	}
 
 	return nil
 }
 
+// ExportAccountHistory returns the payments of the account, see
+// ExportAccountTransactions for its deposits too.
 func (s *Service) ExportAccountHistory(accountID int64) ([]types.Payment, error) {
 	var account *types.Account
 	for _, acc := range s.accounts {
@@ -688,7 +1216,7 @@ func (s *Service) ExportAccountHistory(accountID int64) ([]types.Payment, error)
 		}
 	}
 	if account == nil {
-		return nil, ErrAccountNotFound
+		return nil, newError("ExportAccountHistory", ErrAccountNotFound, accountID, "", 0)
 	}
 
 	var payments []types.Payment
@@ -702,292 +1230,77 @@ func (s *Service) ExportAccountHistory(accountID int64) ([]types.Payment, error)
 }
 
 func (s *Service) HistoryToFiles(payments []types.Payment, dir string, records int) error {
+	return s.HistoryToFilesContext(context.Background(), payments, dir, records, nil)
+}
+
+// HistoryToFilesContext is HistoryToFiles that stops when ctx is done,
+// removing the files it has written so far. progress, if not nil, receives
+// the number of payments written.
+func (s *Service) HistoryToFilesContext(ctx context.Context, payments []types.Payment, dir string, records int, progress ProgressFunc) error {
 	if payments == nil {
 		return nil
 	}
 
 	if records < 1 {
-		return Error("there must be at least 1 record")
-	}
-
-	if records > len(payments) {
-		records = len(payments)
+		return newError("HistoryToFiles", ErrInvalidRecordsCount, 0, "", 0)
 	}
 
-	abs, err := filepath.Abs(dir)
-	if err != nil {
-		return err
-	}
-
-	num := (len(payments) / records)
-	if len(payments)%records != 0 && num >= 1 {
-		num++
-	}
-
-	var iter int
-	for i := 0; i < num; i++ {
-		path := abs + "/payments" + strconv.Itoa(i+1) + ".dump"
-		if num <= 1 {
-			path = abs + "/payments.dump"
-		}
-
-		record, err := os.Create(path)
-		if err != nil {
-			return err
+	i := 0
+	writer := &historyWriter{options: HistoryOptions{Records: records}, legacy: true}
+	return writeHistory(ctx, func() (*types.Payment, bool) {
+		if i == len(payments) {
+			return nil, false
 		}
-		defer func() {
-			cerr := record.Close()
-			if cerr != nil {
-				log.Print(cerr)
-			}
-		}()
-
-		if iter == records {
-			if len(payments)%records != 0 {
-				records = len(payments) % records
-			}
-		}
-
-		var data string
-		for x := 0; x < records; x++ {
-			payment := payments[iter]
-			iter++
-
-			id := payment.ID
-			accountID := strconv.Itoa(int(payment.AccountID))
-			amount := strconv.Itoa(int(payment.Amount))
-			category := string(payment.Category)
-			status := string(payment.Status)
-
-			line := id + ";" + accountID + ";" + amount + ";" + category + ";" + status + "\n"
-			if x == records-1 {
-				line = id + ";" + accountID + ";" + amount + ";" + category + ";" + status
-			}
-
-			data += line
-		}
-
-		_, err = record.Write([]byte(data))
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+		i++
+		return &payments[i-1], true
+	}, len(payments), dir, writer, progress)
 }
 
 func (s *Service) SumPayments(goroutines int) types.Money {
-	wg := sync.WaitGroup{}
-	mu := sync.Mutex{}
-
-	sum := int64(0)
-	start := 0
-	end := 0
-
-	if goroutines > len(s.payments) {
-		goroutines = len(s.payments)
-	}
-	if goroutines == 0 {
-		goroutines = 1
-	}
-
-	ratio := []int{}
-	add := float64(len(s.payments)) / float64(goroutines)
-	ratioSum := 0
-
-	for i := 0; i < goroutines; i++ {
-		if i == goroutines-1 {
-			ratio = append(ratio, len(s.payments)-ratioSum)
-			break
-		}
-
-		ratio = append(ratio, int(math.Ceil(add)))
-		ratioSum += ratio[i]
-	}
-
-	for i := 0; i < goroutines; i++ {
-		if goroutines == 1 {
-			payments := s.payments
-
-			val := int64(0)
-			for _, payment := range payments {
-				val += int64(payment.Amount)
-			}
-			sum += val
-			break
-		}
-
-		wg.Add(1)
-		go func(iter int) {
-			defer wg.Done()
-
-			end += ratio[iter]
-			payments := append([]*types.Payment{}, s.payments[start:end]...)
-			start += ratio[iter]
-
-			val := int64(0)
-			for _, payment := range payments {
-				val += int64(payment.Amount)
-			}
+	sum, _ := s.SumPaymentsContext(context.Background(), goroutines, nil)
+	return sum
+}
 
-			mu.Lock()
-			defer mu.Unlock()
-			sum += val
-		}(i)
-		wg.Wait()
+// SumPaymentsContext is SumPayments that stops when ctx is done. progress,
+// if not nil, receives the number of payments summed.
+func (s *Service) SumPaymentsContext(ctx context.Context, goroutines int, progress ProgressFunc) (types.Money, error) {
+	result, err := s.mapReducePaymentsContext(ctx, goroutines, progress, func(payments []*types.Payment) interface{} {
+		sum := types.Money(0)
+		for _, payment := range payments {
+			sum += payment.Amount
+		}
+		return sum
+	}, func(result interface{}, partial interface{}) interface{} {
+		return result.(types.Money) + partial.(types.Money)
+	}, types.Money(0))
+	if err != nil {
+		return 0, err
 	}
 
-	return types.Money(sum)
+	return result.(types.Money), nil
 }
 
 func (s *Service) FilterPayments(accountID int64, goroutines int) ([]types.Payment, error) {
-	wg := sync.WaitGroup{}
-	mu := sync.Mutex{}
-
-	filteredPayments := []types.Payment{}
-	start := 0
-	end := 0
-
 	_, err := s.FindAccountByID(accountID)
 	if err != nil {
 		return nil, err
 	}
 
-	if goroutines > len(s.payments) {
-		goroutines = len(s.payments)
-	}
-	if goroutines == 0 {
-		goroutines = 1
-	}
-
-	ratio := []int{}
-	add := float64(len(s.payments)) / float64(goroutines)
-	ratioSum := 0
-
-	for i := 0; i < goroutines; i++ {
-		if i == goroutines-1 {
-			ratio = append(ratio, len(s.payments)-ratioSum)
-			break
-		}
-
-		ratio = append(ratio, int(math.Ceil(add)))
-		ratioSum += ratio[i]
-	}
-
-	for i := 0; i < goroutines; i++ {
-		if goroutines == 1 {
-			payments := s.payments
-
-			var filtered []*types.Payment
-			for _, payment := range payments {
-				if payment.AccountID == accountID {
-					filtered = append(filtered, payment)
-				}
-			}
-
-			for _, payment := range filtered {
-				filteredPayments = append(filteredPayments, *payment)
-			}
-			break
-		}
-
-		wg.Add(1)
-		go func(iter int) {
-			defer wg.Done()
-
-			end += ratio[iter]
-			payments := append([]*types.Payment{}, s.payments[start:end]...)
-			start += ratio[iter]
-
-			var filtered []*types.Payment
-			for _, payment := range payments {
-				if payment.AccountID == accountID {
-					filtered = append(filtered, payment)
-				}
-			}
-			
-			mu.Lock()
-			defer mu.Unlock()
-			for _, payment := range filtered {
-				filteredPayments = append(filteredPayments, *payment)
-			}
-		}(i)
-		wg.Wait()
-	}
+	filtered := s.filterPayments(func(payment *types.Payment) bool {
+		return payment.AccountID == accountID
+	}, goroutines)
 
-	return filteredPayments, nil
+	return filtered, nil
 }
 
 func (s *Service) FilterPaymentsByFn(filter func(payment types.Payment) bool, goroutines int) ([]types.Payment, error) {
-	wg := sync.WaitGroup{}
-	mu := sync.Mutex{}
-
-	filteredPayments := []types.Payment{}
-	start := 0
-	end := 0
-
-	if goroutines > len(s.payments) {
-		goroutines = len(s.payments)
-	}
-	if goroutines == 0 {
-		goroutines = 1
-	}
-
-	ratio := []int{}
-	add := float64(len(s.payments)) / float64(goroutines)
-	ratioSum := 0
-
-	for i := 0; i < goroutines; i++ {
-		if i == goroutines-1 {
-			ratio = append(ratio, len(s.payments)-ratioSum)
-			break
-		}
-
-		ratio = append(ratio, int(math.Ceil(add)))
-		ratioSum += ratio[i]
-	}
-
-	for i := 0; i < goroutines; i++ {
-		if goroutines == 1 {
-			payments := s.payments
-
-			var filtered []*types.Payment
-			for _, payment := range payments {
-				if filter(*payment) {
-					filtered = append(filtered, payment)
-				}
-			}
-
-			for _, payment := range filtered {
-				filteredPayments = append(filteredPayments, *payment)
-			}
-			break
-		}
-
-		wg.Add(1)
-		go func(iter int) {
-			defer wg.Done()
-
-			end += ratio[iter]
-			payments := append([]*types.Payment{}, s.payments[start:end]...)
-			start += ratio[iter]
-
-			var filtered []*types.Payment
-			for _, payment := range payments {
-				if filter(*payment) {
-					filtered = append(filtered, payment)
-				}
-			}
-			
-			mu.Lock()
-			defer mu.Unlock()
-			for _, payment := range filtered {
-				filteredPayments = append(filteredPayments, *payment)
-			}
-		}(i)
-		wg.Wait()
-	}
-
-	return filteredPayments, nil
+	return s.FilterPaymentsByFnContext(context.Background(), filter, goroutines, nil)
 }
 
+// FilterPaymentsByFnContext is FilterPaymentsByFn that stops when ctx is
+// done. progress, if not nil, receives the number of payments checked.
+func (s *Service) FilterPaymentsByFnContext(ctx context.Context, filter func(payment types.Payment) bool, goroutines int, progress ProgressFunc) ([]types.Payment, error) {
+	return s.filterPaymentsContext(ctx, func(payment *types.Payment) bool {
+		return filter(*payment)
+	}, goroutines, progress)
+}
@@ -1,6 +1,7 @@
 package wallet
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
 	"reflect"
@@ -108,7 +109,7 @@ func TestService_FindAccountByID_notFound(t *testing.T) {
 		return
 	}
 
-	if err != ErrAccountNotFound {
+	if !errors.Is(err, ErrAccountNotFound) {
 		t.Errorf("FindAccountByID(): must return ErrAccountNotFound, returned %v", err)
 	}
 
@@ -158,12 +159,41 @@ func TestService_Reject_notFound(t *testing.T) {
 		return
 	}
 
-	if err != ErrPaymentNotFound {
+	if !errors.Is(err, ErrPaymentNotFound) {
 		t.Errorf("Reject(): must return ErrPaymentNotFound, returned %v", err)
 		return
 	}
 }
 
+func TestService_Reject_twice(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 1000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	payment, err := s.Pay(account.ID, 600, "food")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	entries := len(s.ledger)
+
+	err = s.Reject(payment.ID)
+	if !errors.Is(err, ErrPaymentRejected) {
+		t.Errorf("Reject(): must return ErrPaymentRejected, returned %v", err)
+	}
+	if account.Balance != 1000 || len(s.ledger) != entries {
+		t.Errorf("second reject must not refund again, balance: %v, entries: %v", account.Balance, len(s.ledger))
+	}
+}
+
 func TestService_Repeat(t *testing.T) {
 	s := newTestService()
 	account, payments, err := s.addAccount(defaultTestAccount)
@@ -200,7 +230,7 @@ func TestService_Repeat_notFound(t *testing.T) {
 		return
 	}
 
-	if err != ErrPaymentNotFound {
+	if !errors.Is(err, ErrPaymentNotFound) {
 		t.Errorf("Repeat(): must return ErrPaymentNotFound, returned = %v", err)
 		return
 	}
@@ -276,7 +306,7 @@ func TestService_PayFromFavorite_notFound(t *testing.T) {
 		return
 	}
 
-	if err != ErrFavoriteNotFound {
+	if !errors.Is(err, ErrFavoriteNotFound) {
 		t.Errorf("PayFromFavorite(): must return ErrFavoriteNotFound, returned %v", err)
 		return
 	}
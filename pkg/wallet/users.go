@@ -0,0 +1,525 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+var ErrLoginTaken = errors.New("login already taken")
+var ErrInvalidLogin = errors.New("invalid login")
+var ErrWeakCredential = errors.New("PIN must be 4 to 12 digits, password at least 8 characters")
+var ErrInvalidRole = errors.New("invalid role")
+var ErrInvalidCredentials = errors.New("invalid login or credential")
+var ErrSessionNotFound = errors.New("session not found or expired")
+var ErrForbidden = errors.New("not allowed for the account")
+var ErrMembershipNotFound = errors.New("user is not linked to the account")
+
+const defaultSessionTTL = 24 * time.Hour
+const tokenSize = 32
+
+// dummy is a credential hashed once and checked against when a login is
+// unknown, so such logins take as long as wrong secrets.
+var dummy struct {
+	once       sync.Once
+	credential string
+}
+
+// CreateUser adds a user who logs in with login and secret: a PIN of 4 to
+// 12 digits or a password of at least 8 characters. Only a salted PBKDF2
+// hash of the secret is kept.
+func (s *Service) CreateUser(login string, secret string) (_ *types.User, err error) {
+	defer s.audit("CreateUser", 0, login).done(&err)
+
+	if login == "" || strings.TrimSpace(login) != login {
+		return nil, newError("CreateUser", ErrInvalidLogin, 0, login, 0)
+	}
+	if !validSecret(secret) {
+		return nil, newError("CreateUser", ErrWeakCredential, 0, login, 0)
+	}
+	if s.findUserByLogin(login) != nil {
+		return nil, newError("CreateUser", ErrLoginTaken, 0, login, 0)
+	}
+
+	credential, err := hashCredential(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &types.User{
+		ID:         uuid.New().String(),
+		Login:      login,
+		Credential: credential,
+		Created:    s.now(),
+	}
+	s.users = append(s.users, user)
+	return user, nil
+}
+
+func validSecret(secret string) bool {
+	if len(secret) >= 8 {
+		return true
+	}
+	if len(secret) < 4 {
+		return false
+	}
+	for _, r := range secret {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ChangeCredential replaces the PIN or password of the user and ends all of
+// the user's sessions.
+func (s *Service) ChangeCredential(userID string, secret string) (err error) {
+	defer s.audit("ChangeCredential", 0, userID).done(&err)
+
+	user, err := s.FindUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if !validSecret(secret) {
+		return newError("ChangeCredential", ErrWeakCredential, 0, user.Login, 0)
+	}
+
+	credential, err := hashCredential(secret)
+	if err != nil {
+		return err
+	}
+
+	user.Credential = credential
+	s.endSessions(userID)
+	return nil
+}
+
+func (s *Service) FindUserByID(userID string) (*types.User, error) {
+	for _, user := range s.users {
+		if user.ID == userID {
+			return user, nil
+		}
+	}
+
+	return nil, newError("FindUserByID", ErrUserNotFound, 0, userID, 0)
+}
+
+func (s *Service) findUserByLogin(login string) *types.User {
+	for _, user := range s.users {
+		if user.Login == login {
+			return user
+		}
+	}
+
+	return nil
+}
+
+// LinkAccount gives the user a role on the account, replacing the role the
+// user had.
+func (s *Service) LinkAccount(userID string, accountID int64, role types.Role) (err error) {
+	defer s.audit("LinkAccount", accountID, userID, role).done(&err)
+
+	_, err = s.FindUserByID(userID)
+	if err != nil {
+		return err
+	}
+	_, err = s.FindAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+	if role != types.RoleOwner && role != types.RoleJointOwner && role != types.RoleViewer {
+		return newError("LinkAccount", ErrInvalidRole, accountID, string(role), 0)
+	}
+
+	membership := s.findMembership(userID, accountID)
+	if membership != nil {
+		membership.Role = role
+		return nil
+	}
+
+	s.memberships = append(s.memberships, &types.Membership{UserID: userID, AccountID: accountID, Role: role})
+	return nil
+}
+
+// UnlinkAccount takes the user's role on the account away.
+func (s *Service) UnlinkAccount(userID string, accountID int64) (err error) {
+	defer s.audit("UnlinkAccount", accountID, userID).done(&err)
+
+	for i, membership := range s.memberships {
+		if membership.UserID == userID && membership.AccountID == accountID {
+			s.memberships = append(s.memberships[:i], s.memberships[i+1:]...)
+			return nil
+		}
+	}
+
+	return newError("UnlinkAccount", ErrMembershipNotFound, accountID, userID, 0)
+}
+
+// UserAccounts returns the memberships of the user.
+func (s *Service) UserAccounts(userID string) ([]types.Membership, error) {
+	_, err := s.FindUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	memberships := []types.Membership{}
+	for _, membership := range s.memberships {
+		if membership.UserID == userID {
+			memberships = append(memberships, *membership)
+		}
+	}
+
+	return memberships, nil
+}
+
+func (s *Service) findMembership(userID string, accountID int64) *types.Membership {
+	for _, membership := range s.memberships {
+		if membership.UserID == userID && membership.AccountID == accountID {
+			return membership
+		}
+	}
+
+	return nil
+}
+
+// SetSessionTTL sets how long new sessions last, a day by default.
+func (s *Service) SetSessionTTL(ttl time.Duration) {
+	defer s.audit("SetSessionTTL", 0, ttl).done(nil)
+
+	s.sessionTTL = ttl
+}
+
+// Login checks the credential of the user and starts a session. The
+// returned token is shown only once, the service keeps its hash.
+func (s *Service) Login(login string, secret string) (_ string, err error) {
+	defer s.audit("Login", 0, login).done(&err)
+
+	user := s.findUserByLogin(login)
+	if user == nil {
+		// Spend the same time as for a wrong secret so logins can't be
+		// probed by timing.
+		verifyCredential(dummyCredential(), secret)
+		return "", newError("Login", ErrInvalidCredentials, 0, login, 0)
+	}
+	if !verifyCredential(user.Credential, secret) {
+		return "", newError("Login", ErrInvalidCredentials, 0, login, 0)
+	}
+
+	buf := make([]byte, tokenSize)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	ttl := s.sessionTTL
+	if ttl <= 0 {
+		ttl = defaultSessionTTL
+	}
+	now := s.now()
+	s.sessions = append(s.sessions, &types.Session{
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+		Created:   now,
+		Expires:   now.Add(ttl),
+	})
+	return token, nil
+}
+
+func dummyCredential() string {
+	dummy.once.Do(func() {
+		dummy.credential, _ = hashCredential("dummy credential")
+	})
+
+	return dummy.credential
+}
+
+// Logout ends the session of the token.
+func (s *Service) Logout(token string) (err error) {
+	defer s.audit("Logout", 0).done(&err)
+
+	hash := hashToken(token)
+	for i, session := range s.sessions {
+		if session.TokenHash == hash {
+			s.sessions = append(s.sessions[:i], s.sessions[i+1:]...)
+			return nil
+		}
+	}
+
+	return newError("Logout", ErrSessionNotFound, 0, "", 0)
+}
+
+func (s *Service) endSessions(userID string) {
+	sessions := s.sessions[:0]
+	for _, session := range s.sessions {
+		if session.UserID != userID {
+			sessions = append(sessions, session)
+		}
+	}
+	s.sessions = sessions
+}
+
+// SessionUser returns the user logged in with the token.
+func (s *Service) SessionUser(token string) (*types.User, error) {
+	hash := hashToken(token)
+	now := s.now()
+	for _, session := range s.sessions {
+		if session.TokenHash != hash {
+			continue
+		}
+		if !now.Before(session.Expires) {
+			break
+		}
+		return s.FindUserByID(session.UserID)
+	}
+
+	return nil, newError("SessionUser", ErrSessionNotFound, 0, "", 0)
+}
+
+// ExpireSessions drops sessions expired by the service clock.
+func (s *Service) ExpireSessions() int {
+	defer s.audit("ExpireSessions", 0).done(nil)
+
+	now := s.now()
+	sessions := s.sessions[:0]
+	for _, session := range s.sessions {
+		if now.Before(session.Expires) {
+			sessions = append(sessions, session)
+		}
+	}
+
+	expired := len(s.sessions) - len(sessions)
+	s.sessions = sessions
+	return expired
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authorized is the service as seen by a logged in user. Every call checks
+// the session and the user's role on the account it is about, and is
+// audited on behalf of the user's login.
+type Authorized struct {
+	s     *Service
+	token string
+}
+
+// Authorize checks the token and returns the calls the user may make.
+func (s *Service) Authorize(token string) (*Authorized, error) {
+	_, err := s.SessionUser(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Authorized{s: s, token: token}, nil
+}
+
+func (a *Authorized) User() (*types.User, error) {
+	return a.s.SessionUser(a.token)
+}
+
+// check returns the user if the session is valid and the user has one of
+// roles on the account.
+func (a *Authorized) check(op string, accountID int64, roles ...types.Role) (*types.User, error) {
+	user, err := a.s.SessionUser(a.token)
+	if err != nil {
+		return nil, err
+	}
+
+	membership := a.s.findMembership(user.ID, accountID)
+	if membership != nil {
+		for _, role := range roles {
+			if membership.Role == role {
+				return user, nil
+			}
+		}
+	}
+
+	return nil, newError(op, ErrForbidden, accountID, user.Login, 0)
+}
+
+// as makes the following service calls on behalf of the user, the returned
+// func restores the previous actor.
+func (a *Authorized) as(user *types.User) func() {
+	actor := a.s.actor
+	a.s.actor = user.Login
+	return func() {
+		a.s.actor = actor
+	}
+}
+
+// Accounts returns the accounts the user has any role on.
+func (a *Authorized) Accounts() ([]types.Account, error) {
+	user, err := a.s.SessionUser(a.token)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := []types.Account{}
+	for _, membership := range a.s.memberships {
+		if membership.UserID != user.ID {
+			continue
+		}
+		account, err := a.s.FindAccountByID(membership.AccountID)
+		if err == nil {
+			accounts = append(accounts, *account)
+		}
+	}
+
+	return accounts, nil
+}
+
+// FindAccountByID is allowed to any role on the account.
+func (a *Authorized) FindAccountByID(accountID int64) (*types.Account, error) {
+	_, err := a.check("FindAccountByID", accountID, types.RoleOwner, types.RoleJointOwner, types.RoleViewer)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.s.FindAccountByID(accountID)
+}
+
+// Pay is allowed to owners and joint owners of the account.
+func (a *Authorized) Pay(accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
+	user, err := a.check("Pay", accountID, types.RoleOwner, types.RoleJointOwner)
+	if err != nil {
+		return nil, err
+	}
+	defer a.as(user)()
+
+	return a.s.Pay(accountID, amount, category)
+}
+
+// Reject is allowed to owners and joint owners of the payment's account.
+// Unknown payments are reported as forbidden too, so users can't probe
+// payments of other accounts.
+func (a *Authorized) Reject(paymentID string) error {
+	user, err := a.s.SessionUser(a.token)
+	if err != nil {
+		return err
+	}
+
+	payment, err := a.s.FindPaymentByID(paymentID)
+	if err == nil {
+		_, err = a.check("Reject", payment.AccountID, types.RoleOwner, types.RoleJointOwner)
+	}
+	if err != nil {
+		return newError("Reject", ErrForbidden, 0, user.Login, 0)
+	}
+	defer a.as(user)()
+
+	return a.s.Reject(paymentID)
+}
+
+// LinkAccount is allowed to owners of the account.
+func (a *Authorized) LinkAccount(userID string, accountID int64, role types.Role) error {
+	user, err := a.check("LinkAccount", accountID, types.RoleOwner)
+	if err != nil {
+		return err
+	}
+	defer a.as(user)()
+
+	return a.s.LinkAccount(userID, accountID, role)
+}
+
+func (s *Service) userRecords() [][]string {
+	var records [][]string
+	for _, user := range s.users {
+		records = append(records, []string{
+			user.ID,
+			url.QueryEscape(user.Login),
+			user.Credential,
+			formatTime(user.Created),
+		})
+	}
+
+	return records
+}
+
+func parseUser(record []string) (*types.User, error) {
+	if len(record) < 4 {
+		return nil, ErrInvalidRecord
+	}
+
+	var err error
+	user := &types.User{ID: record[0], Credential: record[2]}
+	if user.Login, err = url.QueryUnescape(record[1]); err != nil {
+		return nil, err
+	}
+	if user.Created, err = parseTime(record[3]); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (s *Service) membershipRecords() [][]string {
+	var records [][]string
+	for _, membership := range s.memberships {
+		records = append(records, []string{
+			membership.UserID,
+			strconv.FormatInt(membership.AccountID, 10),
+			string(membership.Role),
+		})
+	}
+
+	return records
+}
+
+func parseMembership(record []string) (*types.Membership, error) {
+	if len(record) < 3 {
+		return nil, ErrInvalidRecord
+	}
+
+	var err error
+	membership := &types.Membership{UserID: record[0], Role: types.Role(record[2])}
+	if membership.AccountID, err = strconv.ParseInt(record[1], 10, 64); err != nil {
+		return nil, err
+	}
+
+	return membership, nil
+}
+
+func (s *Service) sessionRecords() [][]string {
+	var records [][]string
+	for _, session := range s.sessions {
+		records = append(records, []string{
+			session.TokenHash,
+			session.UserID,
+			formatTime(session.Created),
+			formatTime(session.Expires),
+		})
+	}
+
+	return records
+}
+
+func parseSession(record []string) (*types.Session, error) {
+	if len(record) < 4 {
+		return nil, ErrInvalidRecord
+	}
+
+	var err error
+	session := &types.Session{TokenHash: record[0], UserID: record[1]}
+	if session.Created, err = parseTime(record[2]); err != nil {
+		return nil, err
+	}
+	if session.Expires, err = parseTime(record[3]); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
@@ -0,0 +1,373 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+// fastKDF makes hashing credentials cheap for the test.
+func fastKDF(t *testing.T) {
+	iterations := kdfIterations
+	kdfIterations = 1_000
+	t.Cleanup(func() {
+		kdfIterations = iterations
+	})
+}
+
+func newUsersTestService(t *testing.T) (*testService, *testClock, *types.Account) {
+	fastKDF(t)
+	return newRiskTestService(t, 1_000)
+}
+
+// authorize creates a user, links it to the account with role unless role is
+// empty, and logs it in.
+func (s *testService) authorize(t *testing.T, login string, accountID int64, role types.Role) *Authorized {
+	user, err := s.CreateUser(login, "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != "" {
+		err = s.LinkAccount(user.ID, accountID, role)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	token, err := s.Login(login, "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorized, err := s.Authorize(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return authorized
+}
+
+// TestPBKDF2 checks PBKDF2-HMAC-SHA256 against the test vectors of RFC 7914
+// section 11, which take two blocks, and widely used single block ones.
+func TestPBKDF2(t *testing.T) {
+	tests := []struct {
+		secret     string
+		salt       string
+		iterations int
+		key        string
+	}{
+		{"password", "salt", 1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+			"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56" +
+			"a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	}
+
+	for _, test := range tests {
+		key := hex.EncodeToString(pbkdf2([]byte(test.secret), []byte(test.salt), test.iterations, len(test.key)/2))
+		if key != test.key {
+			t.Errorf("pbkdf2(%q, %q, %v): invalid result, expected: %v, actual: %v", test.secret, test.salt, test.iterations, test.key, key)
+		}
+	}
+}
+
+func TestService_CreateUser(t *testing.T) {
+	s, _, _ := newUsersTestService(t)
+
+	user, err := s.CreateUser("alice", "1234")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if strings.Contains(user.Credential, "1234") || !strings.HasPrefix(user.Credential, kdfName+"$") {
+		t.Errorf("credential must be hashed, credential: %v", user.Credential)
+	}
+
+	_, err = s.CreateUser("alice", "correct horse")
+	if !errors.Is(err, ErrLoginTaken) {
+		t.Errorf("CreateUser(): must return ErrLoginTaken, returned %v", err)
+	}
+
+	for _, secret := range []string{"", "123", "12ab", "short"} {
+		_, err = s.CreateUser("bob", secret)
+		if !errors.Is(err, ErrWeakCredential) {
+			t.Errorf("CreateUser(%q): must return ErrWeakCredential, returned %v", secret, err)
+		}
+	}
+}
+
+func TestService_Login(t *testing.T) {
+	s, clock, _ := newUsersTestService(t)
+	s.SetSessionTTL(time.Hour)
+
+	user, err := s.CreateUser("alice", "correct horse")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.Login("alice", "wrong horse")
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Login(): must return ErrInvalidCredentials, returned %v", err)
+	}
+	_, err = s.Login("mallory", "correct horse")
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Login(): must return ErrInvalidCredentials, returned %v", err)
+	}
+
+	token, err := s.Login("alice", "correct horse")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if s.sessions[0].TokenHash == token {
+		t.Error("session must keep the hash of the token")
+	}
+
+	found, err := s.SessionUser(token)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if found.ID != user.ID {
+		t.Errorf("invalid result, expected: %v, actual: %v", user.ID, found.ID)
+	}
+
+	clock.advance(time.Hour)
+	_, err = s.SessionUser(token)
+	if !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("SessionUser(): must return ErrSessionNotFound, returned %v", err)
+	}
+	if s.ExpireSessions() != 1 {
+		t.Error("ExpireSessions(): must drop the expired session")
+	}
+
+	token, err = s.Login("alice", "correct horse")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Logout(token)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.SessionUser(token)
+	if !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("SessionUser(): must return ErrSessionNotFound, returned %v", err)
+	}
+}
+
+func TestService_ChangeCredential(t *testing.T) {
+	s, _, _ := newUsersTestService(t)
+
+	user, err := s.CreateUser("alice", "1234")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	token, err := s.Login("alice", "1234")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.ChangeCredential(user.ID, "987654")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.SessionUser(token)
+	if !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("SessionUser(): must return ErrSessionNotFound, returned %v", err)
+	}
+	_, err = s.Login("alice", "1234")
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Login(): must return ErrInvalidCredentials, returned %v", err)
+	}
+	_, err = s.Login("alice", "987654")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAuthorized(t *testing.T) {
+	s, _, account := newUsersTestService(t)
+	other, err := s.addAccountWithBalance("+992000000002", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	logins := map[types.Role]string{
+		types.RoleOwner:      "owner",
+		types.RoleJointOwner: "joint",
+		types.RoleViewer:     "viewer",
+	}
+	tokens := map[types.Role]string{}
+	for role, login := range logins {
+		user, err := s.CreateUser(login, "1234")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		err = s.LinkAccount(user.ID, account.ID, role)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		tokens[role], err = s.Login(login, "1234")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	_, err = s.Authorize("unknown")
+	if !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Authorize(): must return ErrSessionNotFound, returned %v", err)
+	}
+
+	viewer, err := s.Authorize(tokens[types.RoleViewer])
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = viewer.FindAccountByID(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = viewer.FindAccountByID(other.ID)
+	if !errors.Is(err, ErrForbidden) {
+		t.Errorf("FindAccountByID(): must return ErrForbidden, returned %v", err)
+	}
+	_, err = viewer.Pay(account.ID, 100, "auto")
+	if !errors.Is(err, ErrForbidden) {
+		t.Errorf("Pay(): must return ErrForbidden, returned %v", err)
+	}
+
+	joint, err := s.Authorize(tokens[types.RoleJointOwner])
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := joint.Pay(account.ID, 100, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = viewer.Reject(payment.ID)
+	if !errors.Is(err, ErrForbidden) {
+		t.Errorf("Reject(): must return ErrForbidden, returned %v", err)
+	}
+	unknownErr := viewer.Reject("unknown")
+	if !errors.Is(unknownErr, ErrForbidden) || unknownErr.Error() != err.Error() {
+		t.Errorf("Reject(): unknown payments must look forbidden, returned %v", unknownErr)
+	}
+	err = joint.Reject(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account.Balance != 1_000 {
+		t.Errorf("rejected payment must return the funds, balance: %v", account.Balance)
+	}
+	_, err = joint.Pay(other.ID, 100, "auto")
+	if !errors.Is(err, ErrForbidden) {
+		t.Errorf("Pay(): must return ErrForbidden, returned %v", err)
+	}
+
+	viewerUser, _ := viewer.User()
+	err = joint.LinkAccount(viewerUser.ID, account.ID, types.RoleOwner)
+	if !errors.Is(err, ErrForbidden) {
+		t.Errorf("LinkAccount(): must return ErrForbidden, returned %v", err)
+	}
+	owner, err := s.Authorize(tokens[types.RoleOwner])
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = owner.LinkAccount(viewerUser.ID, account.ID, types.RoleJointOwner)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = viewer.Pay(account.ID, 100, "auto")
+	if err != nil {
+		t.Errorf("Pay(): must be allowed after the role changed, returned %v", err)
+	}
+
+	actors := map[string]bool{}
+	for _, record := range s.AuditLog(AuditQuery{}) {
+		if record.Operation == "Pay" || record.Operation == "Reject" {
+			actors[record.Actor] = true
+		}
+	}
+	if !actors["joint"] || !actors["viewer"] {
+		t.Errorf("calls must be audited on behalf of the user, actors: %v", actors)
+	}
+
+	err = s.Logout(tokens[types.RoleJointOwner])
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = joint.FindAccountByID(account.ID)
+	if !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("FindAccountByID(): must return ErrSessionNotFound, returned %v", err)
+	}
+}
+
+func TestService_ExportImport_users(t *testing.T) {
+	s, clock, account := newUsersTestService(t)
+
+	user, err := s.CreateUser("alice", "1234")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.LinkAccount(user.ID, account.ID, types.RoleOwner)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	token, err := s.Login("alice", "1234")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	imported := newTestService()
+	imported.SetClock(clock)
+	err = imported.Import(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	authorized, err := imported.Authorize(token)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = authorized.Pay(account.ID, 100, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = imported.Login("alice", "1234")
+	if err != nil {
+		t.Error(err)
+	}
+}
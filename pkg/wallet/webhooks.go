@@ -0,0 +1,482 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrWebhookNotFound = errors.New("webhook not found")
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+// Headers of webhook requests. The signature is the hex HMAC-SHA256 of the
+// timestamp, a dot and the body keyed with the webhook secret, the delivery
+// ID is the event ID and stays the same across retries.
+const (
+	WebhookEventHeader     = "X-Wallet-Event"
+	WebhookDeliveryHeader  = "X-Wallet-Delivery"
+	WebhookTimestampHeader = "X-Wallet-Timestamp"
+	WebhookSignatureHeader = "X-Wallet-Signature"
+)
+
+var eventTypes = []types.EventType{
+	types.EventAccountRegistered,
+	types.EventDeposited,
+	types.EventPaymentCreated,
+	types.EventPaymentRejected,
+	types.EventPaymentHeld,
+	types.EventPaymentReleased,
+	types.EventPaymentPending,
+	types.EventPaymentApproved,
+	types.EventPaymentExpired,
+	types.EventPaymentConfirmed,
+	types.EventFavoriteCreated,
+}
+
+// WebhookOptions controls delivery of webhooks. An event is sent up to
+// Attempts times, waiting Backoff before the second attempt and twice as
+// long before every next one, but not longer than MaxBackoff. Zero fields
+// get defaults.
+type WebhookOptions struct {
+	Client     *http.Client
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Workers    int
+}
+
+// WebhookDispatcher sends events to registered webhooks in the background.
+// Events that can't be delivered become dead letters.
+type WebhookDispatcher struct {
+	s       *Service
+	options WebhookOptions
+	sub     *Subscription
+	stop    chan struct{}
+	closed  sync.Once
+}
+
+type webhookPayload struct {
+	ID        string                `json:"id"`
+	Seq       int64                 `json:"seq"`
+	Type      types.EventType       `json:"type"`
+	AccountID int64                 `json:"account_id"`
+	ObjectID  string                `json:"object_id"`
+	Amount    types.Money           `json:"amount"`
+	Category  types.PaymentCategory `json:"category,omitempty"`
+	Time      time.Time             `json:"time"`
+}
+
+type webhookStatusError struct {
+	status int
+}
+
+func (e *webhookStatusError) Error() string {
+	return "webhook responded with status " + strconv.Itoa(e.status)
+}
+
+// RegisterWebhook registers an http or https endpoint for events of the
+// given types, all types when none are given.
+func (s *Service) RegisterWebhook(rawURL string, secret string, events ...types.EventType) (_ *types.Webhook, err error) {
+	defer s.audit("RegisterWebhook", 0, rawURL, events).done(&err)
+
+	endpoint, err := url.Parse(rawURL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, newError("RegisterWebhook", ErrInvalidWebhook, 0, rawURL, 0)
+	}
+	if secret == "" {
+		return nil, newError("RegisterWebhook", ErrInvalidWebhook, 0, rawURL, 0)
+	}
+	for _, event := range events {
+		known := false
+		for _, eventType := range eventTypes {
+			known = known || event == eventType
+		}
+		if !known {
+			return nil, newError("RegisterWebhook", ErrInvalidWebhook, 0, string(event), 0)
+		}
+	}
+
+	webhook := &types.Webhook{
+		ID:      uuid.New().String(),
+		URL:     rawURL,
+		Secret:  secret,
+		Events:  append([]types.EventType(nil), events...),
+		Created: s.now(),
+	}
+
+	s.webhooksMu.Lock()
+	s.webhooks = append(s.webhooks, webhook)
+	s.webhooksMu.Unlock()
+	return webhook, nil
+}
+
+func (s *Service) RemoveWebhook(webhookID string) (err error) {
+	defer s.audit("RemoveWebhook", 0, webhookID).done(&err)
+
+	s.webhooksMu.Lock()
+	defer s.webhooksMu.Unlock()
+
+	for i, webhook := range s.webhooks {
+		if webhook.ID == webhookID {
+			s.webhooks = append(s.webhooks[:i], s.webhooks[i+1:]...)
+			return nil
+		}
+	}
+
+	return newError("RemoveWebhook", ErrWebhookNotFound, 0, webhookID, 0)
+}
+
+// Webhooks returns the registered webhooks.
+func (s *Service) Webhooks() []types.Webhook {
+	s.webhooksMu.Lock()
+	defer s.webhooksMu.Unlock()
+
+	webhooks := make([]types.Webhook, 0, len(s.webhooks))
+	for _, webhook := range s.webhooks {
+		webhooks = append(webhooks, *webhook)
+	}
+
+	return webhooks
+}
+
+// DeadLetters returns events that couldn't be delivered, oldest first.
+func (s *Service) DeadLetters() []types.DeadLetter {
+	s.webhooksMu.Lock()
+	defer s.webhooksMu.Unlock()
+
+	letters := make([]types.DeadLetter, 0, len(s.deadLetters))
+	for _, letter := range s.deadLetters {
+		letters = append(letters, *letter)
+	}
+
+	return letters
+}
+
+func (s *Service) webhooksFor(eventType types.EventType) []types.Webhook {
+	var webhooks []types.Webhook
+	for _, webhook := range s.Webhooks() {
+		if len(webhook.Events) == 0 {
+			webhooks = append(webhooks, webhook)
+			continue
+		}
+		for _, event := range webhook.Events {
+			if event == eventType {
+				webhooks = append(webhooks, webhook)
+				break
+			}
+		}
+	}
+
+	return webhooks
+}
+
+// StartWebhooks starts sending events to the registered webhooks until the
+// dispatcher is closed. Events of an account are sent in order.
+func (s *Service) StartWebhooks(options WebhookOptions) *WebhookDispatcher {
+	if options.Client == nil {
+		options.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if options.Attempts < 1 {
+		options.Attempts = 5
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = time.Second
+	}
+	if options.MaxBackoff < options.Backoff {
+		options.MaxBackoff = time.Minute
+	}
+	if options.Workers < 1 {
+		options.Workers = 4
+	}
+
+	d := &WebhookDispatcher{s: s, options: options, stop: make(chan struct{})}
+	d.sub = s.SubscribeAsync(func(event types.Event) error {
+		for _, webhook := range s.webhooksFor(event.Type) {
+			d.dispatch(webhook, event)
+		}
+		return nil
+	}, options.Workers)
+	return d
+}
+
+// Close stops the dispatcher and waits until events already queued are
+// delivered or become dead letters. When ctx is done the remaining events
+// are tried without waiting between attempts. Calls after the first one
+// only wait for it.
+func (d *WebhookDispatcher) Close(ctx context.Context) {
+	d.closed.Do(func() {
+		closed := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+			case <-closed:
+			}
+			close(d.stop)
+		}()
+
+		d.sub.Close()
+		close(closed)
+	})
+}
+
+// Redeliver tries to send the dead letters again and returns the number of
+// delivered ones, which are removed. Letters of removed webhooks are kept.
+func (d *WebhookDispatcher) Redeliver(ctx context.Context) (int, error) {
+	s := d.s
+	delivered := 0
+	for _, letter := range s.DeadLetters() {
+		err := ctx.Err()
+		if err != nil {
+			return delivered, err
+		}
+
+		var webhook *types.Webhook
+		for _, item := range s.Webhooks() {
+			if item.ID == letter.WebhookID {
+				webhook = &item
+				break
+			}
+		}
+		if webhook == nil {
+			continue
+		}
+
+		attempts, err := d.send(*webhook, letter.Event)
+		s.webhooksMu.Lock()
+		for i, item := range s.deadLetters {
+			if item.ID != letter.ID {
+				continue
+			}
+			if err == nil {
+				s.deadLetters = append(s.deadLetters[:i], s.deadLetters[i+1:]...)
+				delivered++
+			} else {
+				item.Attempts += attempts
+				item.Error = err.Error()
+				item.Failed = s.now()
+			}
+			break
+		}
+		s.webhooksMu.Unlock()
+	}
+
+	return delivered, nil
+}
+
+func (d *WebhookDispatcher) dispatch(webhook types.Webhook, event types.Event) {
+	attempts, err := d.send(webhook, event)
+	if err == nil {
+		return
+	}
+
+	s := d.s
+	s.webhooksMu.Lock()
+	s.deadLetters = append(s.deadLetters, &types.DeadLetter{
+		ID:        uuid.New().String(),
+		WebhookID: webhook.ID,
+		Event:     event,
+		Attempts:  attempts,
+		Error:     err.Error(),
+		Failed:    s.now(),
+	})
+	s.webhooksMu.Unlock()
+}
+
+// send posts the event with retries and returns the number of attempts
+// made. Client errors other than 408 and 429 aren't retried.
+func (d *WebhookDispatcher) send(webhook types.Webhook, event types.Event) (int, error) {
+	body, err := json.Marshal(webhookPayload{
+		ID:        event.ID,
+		Seq:       event.Seq,
+		Type:      event.Type,
+		AccountID: event.AccountID,
+		ObjectID:  event.ObjectID,
+		Amount:    event.Amount,
+		Category:  event.Category,
+		Time:      event.Time.UTC(),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	backoff := d.options.Backoff
+	attempts := 0
+	for {
+		attempts++
+		err = d.post(webhook, event, body)
+		if err == nil {
+			return attempts, nil
+		}
+
+		var statusErr *webhookStatusError
+		if errors.As(err, &statusErr) && statusErr.status < 500 &&
+			statusErr.status != http.StatusRequestTimeout && statusErr.status != http.StatusTooManyRequests {
+			return attempts, err
+		}
+		if attempts >= d.options.Attempts {
+			return attempts, err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-d.stop:
+			timer.Stop()
+			return attempts, err
+		}
+
+		backoff *= 2
+		if backoff > d.options.MaxBackoff {
+			backoff = d.options.MaxBackoff
+		}
+	}
+}
+
+func (d *WebhookDispatcher) post(webhook types.Webhook, event types.Event, body []byte) error {
+	request, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(d.s.now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookEventHeader, string(event.Type))
+	request.Header.Set(WebhookDeliveryHeader, event.ID)
+	request.Header.Set(WebhookTimestampHeader, timestamp)
+	request.Header.Set(WebhookSignatureHeader, SignWebhook(webhook.Secret, timestamp, body))
+
+	response, err := d.options.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, err = io.Copy(ioutil.Discard, response.Body)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return &webhookStatusError{status: response.StatusCode}
+	}
+
+	return nil
+}
+
+// SignWebhook returns the signature of a webhook request.
+func SignWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook reports whether signature is the signature of a webhook
+// request, receivers should also reject old timestamps.
+func VerifyWebhook(secret string, timestamp string, body []byte, signature string) bool {
+	expected := SignWebhook(secret, timestamp, body)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+func (s *Service) webhookRecords() [][]string {
+	s.webhooksMu.Lock()
+	defer s.webhooksMu.Unlock()
+
+	var records [][]string
+	for _, webhook := range s.webhooks {
+		events := make([]string, 0, len(webhook.Events))
+		for _, event := range webhook.Events {
+			events = append(events, string(event))
+		}
+
+		records = append(records, []string{
+			webhook.ID,
+			url.QueryEscape(webhook.URL),
+			url.QueryEscape(webhook.Secret),
+			strings.Join(events, ","),
+			formatTime(webhook.Created),
+		})
+	}
+
+	return records
+}
+
+func parseWebhook(record []string) (*types.Webhook, error) {
+	if len(record) < 5 {
+		return nil, ErrInvalidRecord
+	}
+
+	var err error
+	webhook := &types.Webhook{ID: record[0]}
+	if webhook.URL, err = url.QueryUnescape(record[1]); err != nil {
+		return nil, err
+	}
+	if webhook.Secret, err = url.QueryUnescape(record[2]); err != nil {
+		return nil, err
+	}
+	if record[3] != "" {
+		for _, event := range strings.Split(record[3], ",") {
+			webhook.Events = append(webhook.Events, types.EventType(event))
+		}
+	}
+	if webhook.Created, err = parseTime(record[4]); err != nil {
+		return nil, err
+	}
+
+	return webhook, nil
+}
+
+func (s *Service) deadLetterRecords() [][]string {
+	s.webhooksMu.Lock()
+	defer s.webhooksMu.Unlock()
+
+	var records [][]string
+	for _, letter := range s.deadLetters {
+		records = append(records, append([]string{
+			letter.ID,
+			letter.WebhookID,
+			strconv.Itoa(letter.Attempts),
+			url.QueryEscape(letter.Error),
+			formatTime(letter.Failed),
+		}, eventFields(letter.Event)...))
+	}
+
+	return records
+}
+
+func parseDeadLetter(record []string) (*types.DeadLetter, error) {
+	if len(record) < 5+eventFieldsCount {
+		return nil, ErrInvalidRecord
+	}
+
+	var err error
+	letter := &types.DeadLetter{ID: record[0], WebhookID: record[1]}
+	if letter.Attempts, err = strconv.Atoi(record[2]); err != nil {
+		return nil, err
+	}
+	if letter.Error, err = url.QueryUnescape(record[3]); err != nil {
+		return nil, err
+	}
+	if letter.Failed, err = parseTime(record[4]); err != nil {
+		return nil, err
+	}
+	if letter.Event, err = parseEventFields(record[5:]); err != nil {
+		return nil, err
+	}
+
+	return letter, nil
+}
@@ -0,0 +1,235 @@
+package wallet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/a1ishm/wallet/pkg/types"
+)
+
+type webhookReceiver struct {
+	mu       sync.Mutex
+	secret   string
+	statuses []int
+	requests []*http.Request
+	payloads []webhookPayload
+	invalid  int
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !VerifyWebhook(r.secret, req.Header.Get(WebhookTimestampHeader), body, req.Header.Get(WebhookSignatureHeader)) {
+		r.invalid++
+	}
+
+	var payload webhookPayload
+	err = json.Unmarshal(body, &payload)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.requests = append(r.requests, req)
+	r.payloads = append(r.payloads, payload)
+
+	status := http.StatusNoContent
+	if len(r.statuses) != 0 {
+		status = r.statuses[0]
+		r.statuses = r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func newWebhookTest(t *testing.T, statuses ...int) (*testService, *types.Account, *webhookReceiver, *httptest.Server) {
+	receiver := &webhookReceiver{secret: "secret", statuses: statuses}
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 1_000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, account, receiver, server
+}
+
+func TestService_StartWebhooks(t *testing.T) {
+	s, account, receiver, server := newWebhookTest(t)
+	_, err := s.RegisterWebhook(server.URL, "secret", types.EventPaymentCreated, types.EventPaymentRejected)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	d := s.StartWebhooks(WebhookOptions{Backoff: time.Millisecond})
+	payment, err := s.Pay(account.ID, 100, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Deposit(account.ID, 100)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	d.Close(context.Background())
+	d.Close(context.Background())
+
+	if len(receiver.payloads) != 2 || receiver.invalid != 0 {
+		t.Errorf("invalid deliveries: %v, invalid signatures: %v", receiver.payloads, receiver.invalid)
+		return
+	}
+
+	created := receiver.payloads[0]
+	if created.Type != types.EventPaymentCreated || created.ObjectID != payment.ID || created.AccountID != account.ID || created.Amount != 100 {
+		t.Errorf("invalid payload: %+v", created)
+	}
+	if receiver.payloads[1].Type != types.EventPaymentRejected {
+		t.Errorf("invalid payload: %+v", receiver.payloads[1])
+	}
+	if delivery := receiver.requests[0].Header.Get(WebhookDeliveryHeader); delivery != created.ID {
+		t.Errorf("invalid delivery ID, expected: %v, actual: %v", created.ID, delivery)
+	}
+}
+
+func TestService_StartWebhooks_retries(t *testing.T) {
+	s, account, receiver, server := newWebhookTest(t, http.StatusInternalServerError, http.StatusTooManyRequests)
+	_, err := s.RegisterWebhook(server.URL, "secret")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	d := s.StartWebhooks(WebhookOptions{Attempts: 3, Backoff: time.Millisecond})
+	_, err = s.Pay(account.ID, 100, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	d.Close(context.Background())
+
+	if len(receiver.payloads) != 3 {
+		t.Errorf("invalid number of attempts, expected: %v, actual: %v", 3, len(receiver.payloads))
+	}
+	if receiver.payloads[0].ID != receiver.payloads[2].ID {
+		t.Errorf("retries must have the same ID: %v", receiver.payloads)
+	}
+	if letters := s.DeadLetters(); len(letters) != 0 {
+		t.Errorf("delivered event must not be a dead letter: %v", letters)
+	}
+}
+
+func TestService_StartWebhooks_deadLetters(t *testing.T) {
+	s, account, receiver, server := newWebhookTest(t,
+		http.StatusBadGateway, http.StatusBadGateway, http.StatusBadRequest)
+	webhook, err := s.RegisterWebhook(server.URL, "secret", types.EventPaymentCreated)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	d := s.StartWebhooks(WebhookOptions{Attempts: 2, Backoff: time.Millisecond})
+	first, err := s.Pay(account.ID, 100, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	second, err := s.Pay(account.ID, 200, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	d.Close(context.Background())
+
+	letters := s.DeadLetters()
+	if len(letters) != 2 {
+		t.Errorf("invalid number of dead letters, expected: %v, actual: %v", 2, len(letters))
+		return
+	}
+	if letters[0].WebhookID != webhook.ID || letters[0].Event.ObjectID != first.ID || letters[0].Attempts != 2 {
+		t.Errorf("invalid dead letter: %+v", letters[0])
+	}
+	if letters[1].Event.ObjectID != second.ID || letters[1].Attempts != 1 {
+		t.Errorf("client errors must not be retried: %+v", letters[1])
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(imported.Webhooks()) != 1 || len(imported.DeadLetters()) != 2 {
+		t.Errorf("invalid import: %v, %v", imported.Webhooks(), imported.DeadLetters())
+		return
+	}
+	if event := imported.DeadLetters()[0].Event; event.ID != letters[0].Event.ID || event.Time.Unix() != letters[0].Event.Time.Unix() {
+		t.Errorf("invalid imported event: %+v", event)
+	}
+
+	d = imported.StartWebhooks(WebhookOptions{Attempts: 1})
+	delivered, err := d.Redeliver(context.Background())
+	d.Close(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if delivered != 2 || len(imported.DeadLetters()) != 0 {
+		t.Errorf("invalid redelivery: %v delivered, %v left", delivered, len(imported.DeadLetters()))
+	}
+	if len(receiver.payloads) != 5 {
+		t.Errorf("invalid number of requests, expected: %v, actual: %v", 5, len(receiver.payloads))
+	}
+}
+
+func TestService_RegisterWebhook_invalid(t *testing.T) {
+	s := newTestService()
+
+	tests := []struct {
+		url    string
+		secret string
+		events []types.EventType
+	}{
+		{"ftp://example.com", "secret", nil},
+		{"http://", "secret", nil},
+		{"https://example.com/hook", "", nil},
+		{"https://example.com/hook", "secret", []types.EventType{"UNKNOWN"}},
+	}
+	for _, test := range tests {
+		_, err := s.RegisterWebhook(test.url, test.secret, test.events...)
+		if !errors.Is(err, ErrInvalidWebhook) {
+			t.Errorf("RegisterWebhook(%v): must return ErrInvalidWebhook, returned %v", test.url, err)
+		}
+	}
+
+	err := s.RemoveWebhook("unknown")
+	if !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("RemoveWebhook(): must return ErrWebhookNotFound, returned %v", err)
+	}
+}